@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Hashing or signing JSON, with the sha256-hashing example for instance, only works if everyone
+// produces exactly the same bytes for the same data
+
+// encoding/json doesn't guarantee that: struct fields come out in declaration order, HTML
+// characters are escaped, and a document received from elsewhere may use different whitespace,
+// member order, escapes or number spellings (1.0 and 1, "A" and "A")
+
+// The JSON Canonicalization Scheme (RFC 8785) fixes one spelling for every JSON value:
+//   no whitespace
+//   object members sorted by their names' UTF-16 code units
+//   strings with only the escapes JSON requires, everything else as literal UTF-8
+//   numbers formatted the way JavaScript's Number.prototype.toString does it
+
+// Canonicalize rewrites a JSON document in canonical form
+// as RFC 8785 requires, the input must be I-JSON: duplicate member names, numbers that don't fit
+// in a float64, and strings that aren't valid Unicode are rejected
+func Canonicalize(data []byte) ([]byte, error) {
+	// encoding/json would quietly turn invalid strings into U+FFFD, so different documents would
+	// come out the same
+	if err := checkStrings(data); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err := writeValue(dec, &buf); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("canonicalize: unexpected data after the top-level value")
+	}
+	return buf.Bytes(), nil
+}
+
+// Marshal encodes a Go value in canonical form
+// it uses encoding/json for the Go-to-JSON mapping, so struct tags and Marshaler implementations
+// work as usual, and then canonicalizes the result
+// encoding/json replaces invalid UTF-8 in strings with U+FFFD, so strings are checked first
+func Marshal(v interface{}) ([]byte, error) {
+	// json.Marshal goes first, as it catches cycles that validStrings would go round forever
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if !validStrings(reflect.ValueOf(v)) {
+		return nil, errors.New("canonicalize: invalid UTF-8 in a string")
+	}
+	return Canonicalize(b)
+}
+
+// validStrings reports whether every string in a Go value that encoding/json would write is
+// valid UTF-8
+func validStrings(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.ValidString(v.String())
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil() || validStrings(v.Elem())
+	case reflect.Slice, reflect.Array:
+		// a []byte is written as base64
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return true
+		}
+		for i := 0; i < v.Len(); i++ {
+			if !validStrings(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if !validStrings(iter.Key()) || !validStrings(iter.Value()) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); (f.PkgPath == "" || f.Anonymous) && !validStrings(v.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkStrings reports invalid UTF-8, and \u escapes of surrogates that aren't in a high-low
+// pair, which I-JSON doesn't allow; the rest of the syntax is left to the decoder
+func checkStrings(data []byte) error {
+	if !utf8.Valid(data) {
+		return errors.New("canonicalize: invalid UTF-8")
+	}
+	inString := false
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '"':
+			inString = !inString
+		case c == '\\' && inString:
+			i++
+			if i == len(data) || data[i] != 'u' {
+				continue
+			}
+			r := escapedRune(data[i+1:])
+			switch {
+			case utf16.IsSurrogate(r) && r < 0xDC00:
+				// a high surrogate must be followed by an escaped low one
+				if rest := data[i+5:]; len(rest) < 6 || rest[0] != '\\' || rest[1] != 'u' ||
+					utf16.DecodeRune(r, escapedRune(rest[2:])) == utf8.RuneError {
+					return fmt.Errorf("canonicalize: unpaired surrogate \\u%s", data[i+1:i+5])
+				}
+				i += 10
+			case utf16.IsSurrogate(r):
+				return fmt.Errorf("canonicalize: unpaired surrogate \\u%s", data[i+1:i+5])
+			default:
+				i += 4
+			}
+		}
+	}
+	return nil
+}
+
+// escapedRune reads the four hex digits of a \u escape, returning -1 if they aren't there
+func escapedRune(b []byte) rune {
+	if len(b) < 4 {
+		return -1
+	}
+	n, err := strconv.ParseUint(string(b[:4]), 16, 16)
+	if err != nil {
+		return -1
+	}
+	return rune(n)
+}
+
+// member is an object member whose value has already been canonicalized
+type member struct {
+	name  string
+	key   []uint16
+	value []byte
+}
+
+func writeValue(dec *json.Decoder, buf *bytes.Buffer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			buf.WriteByte('[')
+			for i := 0; dec.More(); i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if err := writeValue(dec, buf); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte(']')
+			_, err := dec.Token()
+			return err
+		}
+
+		// members have to be sorted, so each value is written to its own buffer first
+		var members []member
+		seen := make(map[string]bool)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			name := tok.(string)
+			if seen[name] {
+				return fmt.Errorf("canonicalize: duplicate member %q", name)
+			}
+			seen[name] = true
+
+			var value bytes.Buffer
+			if err := writeValue(dec, &value); err != nil {
+				return err
+			}
+			members = append(members, member{name, utf16.Encode([]rune(name)), value.Bytes()})
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+
+		// sorting by UTF-16 code units rather than by bytes matters for characters outside the
+		// Basic Multilingual Plane, whose surrogate pairs sort before U+E000 to U+FFFF
+		sort.Slice(members, func(i, j int) bool { return lessUTF16(members[i].key, members[j].key) })
+
+		buf.WriteByte('{')
+		for i, m := range members {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeString(buf, m.name)
+			buf.WriteByte(':')
+			buf.Write(m.value)
+		}
+		buf.WriteByte('}')
+		return nil
+
+	case string:
+		writeString(buf, t)
+	case json.Number:
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return fmt.Errorf("canonicalize: number %s out of range", t)
+		}
+		s, err := FormatNumber(f)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case bool:
+		buf.WriteString(strconv.FormatBool(t))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func lessUTF16(a, b []uint16) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// writeString writes a string literal with the minimal escaping RFC 8785 specifies
+func writeString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[r>>4])
+				buf.WriteByte(hex[r&0xf])
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// FormatNumber formats a float64 as ECMAScript's Number.prototype.toString does, which is the
+// number format RFC 8785 uses
+// the digits are the shortest that round-trip, which strconv already computes; ECMAScript only
+// differs in where it switches to exponential notation and how it writes the exponent
+func FormatNumber(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("canonicalize: %v is not valid JSON", f)
+	}
+	if f == 0 {
+		// this includes -0, which is written as 0
+		return "0", nil
+	}
+
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+
+	// 'e' with precision -1 gives the shortest digits, as d.ddde±xx
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp, _ := strings.Cut(e, "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	x, _ := strconv.Atoi(exp)
+
+	// n is the position of the decimal point relative to the start of the digits, and k the
+	// number of digits, using the names from the ECMAScript specification
+	n := x + 1
+	k := len(digits)
+
+	var s string
+	switch {
+	case k <= n && n <= 21:
+		s = digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		s = digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		s = "0." + strings.Repeat("0", -n) + digits
+	default:
+		s = digits[:1]
+		if k > 1 {
+			s += "." + digits[1:]
+		}
+		if n-1 >= 0 {
+			s += "e+" + strconv.Itoa(n-1)
+		} else {
+			s += "e" + strconv.Itoa(n-1)
+		}
+	}
+	return sign + s, nil
+}
+
+// Digest computes the SHA256 of a value's canonical form, as in the sha256-hashing example
+func Digest(v interface{}) ([]byte, error) {
+	b, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write(b)
+	return h.Sum(nil), nil
+}
+
+// Order is declared with its fields in a different order from the JSON document below, and its
+// note contains characters encoding/json escapes by default
+type Order struct {
+	Total float64  `json:"total"`
+	ID    int      `json:"id"`
+	Items []string `json:"items"`
+	Note  string   `json:"note"`
+}
+
+func main() {
+	// the same order written three ways: different member order, whitespace, escapes and
+	// number spellings
+	docs := []string{
+		`{"id":7,"items":["apple","pear"],"note":"<fragile> & \"fresh\"","total":10.5}`,
+		`{
+			"total": 1.05E1,
+			"note": "<fragile> & \"fresh\"",
+			"items": ["apple", "pear"],
+			"id": 7.0
+		}`,
+	}
+
+	for _, d := range docs {
+		// hashing the raw bytes gives a different digest for each spelling
+		raw := sha256.Sum256([]byte(d))
+
+		c, err := Canonicalize([]byte(d))
+		if err != nil {
+			panic(err)
+		}
+		canon := sha256.Sum256(c)
+
+		fmt.Printf("raw %x\n", raw[:8])
+		fmt.Printf("jcs %x  %s\n", canon[:8], c)
+	}
+
+	// a Go value gives the same canonical bytes, and so the same digest
+	o := Order{Total: 10.5, ID: 7, Items: []string{"apple", "pear"}, Note: `<fragile> & "fresh"`}
+	sum, err := Digest(o)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("go  %x\n", sum[:8])
+
+	// some numbers, formatted as JavaScript would
+	for _, f := range []float64{1e21, 1e20, 0.000001, 1e-7, 333333333.33333329, -0.0, 4.5, 2e-3, 1e30} {
+		s, _ := FormatNumber(f)
+		fmt.Printf("%v -> %s\n", f, s)
+	}
+
+	// duplicate member names are rejected, since different parsers disagree on which one wins
+	if _, err := Canonicalize([]byte(`{"a":1,"a":2}`)); err != nil {
+		fmt.Println("error:", err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// The expected outputs are from RFC 8785: the example in section 3.2.2, the sorting example in
+// section 3.2.3, and the number formatting table in appendix B
+
+func TestCanonicalize(t *testing.T) {
+	var tests = []struct {
+		name, in, want string
+	}{
+		{"rfc example", `{
+			"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+			"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+			"literals": [null, true, false]
+		}`, "{\"literals\":[null,true,false],\"numbers\":[333333333.3333333,1e+30,4.5,0.002,1e-27]," +
+			"\"string\":\"\u20ac$\\u000f\\nA'B\\\"\\\\\\\\\\\"/\"}"},
+		{"utf-16 ordering", `{
+			"\u20ac": "Euro Sign",
+			"\r": "Carriage Return",
+			"\ufb33": "Hebrew Letter Dalet With Dagesh",
+			"1": "One",
+			"\ud83d\ude00": "Emoji: Grinning Face",
+			"\u0080": "Control",
+			"\u00f6": "Latin Small Letter O With Diaeresis"
+		}`, "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\"," +
+			"\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\"," +
+			"\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}"},
+		{"nested", `{"b":[{"z":1,"y":{}}],"a":[]}`, `{"a":[],"b":[{"y":{},"z":1}]}`},
+		{"html isn't escaped", `"<a & b> "`, "\"<a & b> \""},
+		{"negative zero", `-0.0`, `0`},
+		{"scalar", `  true  `, `true`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Canonicalize([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeErrors(t *testing.T) {
+	var tests = []struct {
+		name, in, err string
+	}{
+		{"duplicate member", `{"a":1,"b":2,"a":3}`, `duplicate member "a"`},
+		{"nested duplicate", `[{"x":{"a":1,"a":1}}]`, `duplicate member "a"`},
+		{"invalid utf-8", "{\"a\":\"\xff\"}", "invalid UTF-8"},
+		{"invalid utf-8 in a name", "{\"\xc3\":1}", "invalid UTF-8"},
+		{"lone high surrogate", `{"b":"\ud800"}`, `unpaired surrogate \ud800`},
+		{"lone low surrogate", `"\udc00x"`, `unpaired surrogate \udc00`},
+		{"high surrogate without a low one", `"\ud83dA"`, `unpaired surrogate \ud83d`},
+		{"two high surrogates", `"\ud83d\ud83d"`, `unpaired surrogate \ud83d`},
+		{"number out of range", `1e400`, "out of range"},
+		{"trailing data", `{} {}`, "unexpected data"},
+		{"syntax", `{"a":}`, "missing value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Canonicalize([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	// an escaped backslash followed by u isn't an escape
+	if got, err := Canonicalize([]byte(`"\\ud800"`)); err != nil || string(got) != `"\\ud800"` {
+		t.Errorf(`"\\ud800": got %s, %v`, got, err)
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	var tests = []struct {
+		bits uint64
+		want string
+	}{
+		{0x0000000000000000, "0"},
+		{0x8000000000000000, "0"},
+		{0x0000000000000001, "5e-324"},
+		{0x8000000000000001, "-5e-324"},
+		{0x7fefffffffffffff, "1.7976931348623157e+308"},
+		{0xffefffffffffffff, "-1.7976931348623157e+308"},
+		{0x4340000000000000, "9007199254740992"},
+		{0xc340000000000000, "-9007199254740992"},
+		{0x4430000000000000, "295147905179352830000"},
+		{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+		{0x44b52d02c7e14af6, "1e+23"},
+		{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+		{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+		{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+		{0x444b1ae4d6e2ef50, "1e+21"},
+		{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+		{0x3eb0c6f7a0b5ed8d, "0.000001"},
+		{0x41b3de4355555553, "333333333.3333332"},
+		{0x41b3de4355555554, "333333333.33333325"},
+		{0x41b3de4355555555, "333333333.3333333"},
+		{0x41b3de4355555556, "333333333.3333334"},
+		{0x41b3de4355555557, "333333333.33333343"},
+		{0xbecbf647612f3696, "-0.0000033333333333333333"},
+		{0x43143ff3c1cb0959, "1424953923781206.2"},
+		// and some from section 3.2.2.3
+		{math.Float64bits(1e21), "1e+21"},
+		{math.Float64bits(1e20), "100000000000000000000"},
+		{math.Float64bits(1e-7), "1e-7"},
+		{math.Float64bits(333333333.33333329), "333333333.3333333"},
+	}
+	for _, tt := range tests {
+		f := math.Float64frombits(tt.bits)
+		got, err := FormatNumber(f)
+		if err != nil || got != tt.want {
+			t.Errorf("%016x (%v): got %s, %v; want %s", tt.bits, f, got, err, tt.want)
+		}
+	}
+
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := FormatNumber(f); err == nil {
+			t.Errorf("%v: got no error", f)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	// a Go value and a differently written document for the same data give the same bytes, and so
+	// the same digest
+	o := Order{Total: 10.5, ID: 7, Items: []string{"apple", "pear"}, Note: `<fragile> & "fresh"`}
+	got, err := Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := Canonicalize([]byte(`{ "total": 1.05E1, "note": "<fragile> & \"fresh\"", "items": ["apple", "pear"], "id": 7.0 }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, doc) {
+		t.Errorf("Marshal gave %s, Canonicalize gave %s", got, doc)
+	}
+
+	// encoding/json would turn invalid UTF-8 into U+FFFD, which is a real U+FFFD's encoding
+	for _, v := range []interface{}{
+		"\xff",
+		map[string]int{"\xff": 1},
+		[]interface{}{Order{Note: "ok\xc3"}},
+	} {
+		if _, err := Marshal(v); err == nil || !strings.Contains(err.Error(), "invalid UTF-8") {
+			t.Errorf("%q: got error %v", v, err)
+		}
+	}
+	if _, err := Marshal("\ufffd"); err != nil {
+		t.Errorf("a real U+FFFD: %v", err)
+	}
+}
@@ -0,0 +1,3 @@
+module example/canonical-json
+
+go 1.18
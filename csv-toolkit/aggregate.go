@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Grouping collects rows with the same values in the group columns into one output row, with
+// aggregates over each group: how many rows, and the sum, average, least and greatest of a column
+// Only one row per group is kept in memory, with a running value for each aggregate, however many
+// rows the input has
+
+// Aggregate is one output column of a group-by, such as count or sum:salary
+type Aggregate struct {
+	Func   string
+	Column int
+	Name   string
+}
+
+// ParseAggregates parses a list such as count,sum:salary,max:age
+// the output columns are named count, sum_salary and max_age
+func ParseAggregates(s string, header []string) ([]Aggregate, error) {
+	var aggs []Aggregate
+	for _, item := range strings.Split(s, ",") {
+		fn, col, hasCol := strings.Cut(item, ":")
+		a := Aggregate{Func: fn, Column: -1, Name: fn}
+		switch fn {
+		case "count":
+			if hasCol {
+				return nil, fmt.Errorf("count doesn't take a column")
+			}
+		case "sum", "avg", "min", "max":
+			if !hasCol {
+				return nil, fmt.Errorf("%s needs a column, as in %s:price", fn, fn)
+			}
+			i, err := columnIndex(header, col)
+			if err != nil {
+				return nil, err
+			}
+			a.Column, a.Name = i, fn+"_"+col
+		default:
+			return nil, fmt.Errorf("unknown aggregate %q: want count, sum, avg, min or max", fn)
+		}
+		aggs = append(aggs, a)
+	}
+	return aggs, nil
+}
+
+// group holds one group's key values and running aggregates
+type group struct {
+	key    []string
+	count  int
+	sums   []float64
+	counts []int
+	values []string
+}
+
+// Grouper groups rows by columns, keeping groups in the order they were first seen
+type Grouper struct {
+	columns []int
+	aggs    []Aggregate
+	groups  map[string]*group
+	order   []*group
+}
+
+// NewGrouper groups rows by columns
+func NewGrouper(columns []int, aggs []Aggregate) *Grouper {
+	return &Grouper{columns: columns, aggs: aggs, groups: make(map[string]*group)}
+}
+
+// Header is the output header: the group columns, then the aggregates
+func (g *Grouper) Header(header []string) []string {
+	var out []string
+	for _, c := range g.columns {
+		out = append(out, header[c])
+	}
+	for _, a := range g.aggs {
+		out = append(out, a.Name)
+	}
+	return out
+}
+
+// Add adds a row to its group
+// sum and avg ignore empty fields, as spreadsheets do, but any other field that isn't a number
+// is an error, rather than being quietly counted as 0
+func (g *Grouper) Add(row []string) error {
+	key := make([]string, len(g.columns))
+	for i, c := range g.columns {
+		key[i] = row[c]
+	}
+	// NUL doesn't appear in text, so it can't make two different keys the same
+	k := strings.Join(key, "\x00")
+	grp, ok := g.groups[k]
+	if !ok {
+		n := len(g.aggs)
+		grp = &group{key: key, sums: make([]float64, n), counts: make([]int, n), values: make([]string, n)}
+		g.groups[k] = grp
+		g.order = append(g.order, grp)
+	}
+
+	grp.count++
+	for i, a := range g.aggs {
+		if a.Column < 0 {
+			continue
+		}
+		v := row[a.Column]
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		switch a.Func {
+		case "sum", "avg":
+			f, ok := number(v)
+			if !ok {
+				return fmt.Errorf("%s: %q isn't a number", a.Name, v)
+			}
+			grp.sums[i] += f
+		case "min":
+			if grp.counts[i] == 0 || compare(v, grp.values[i]) < 0 {
+				grp.values[i] = v
+			}
+		case "max":
+			if grp.counts[i] == 0 || compare(v, grp.values[i]) > 0 {
+				grp.values[i] = v
+			}
+		}
+		grp.counts[i]++
+	}
+	return nil
+}
+
+// Rows calls emit with a row for each group
+func (g *Grouper) Rows(emit func([]string) error) error {
+	for _, grp := range g.order {
+		row := append([]string(nil), grp.key...)
+		for i, a := range g.aggs {
+			var v string
+			switch a.Func {
+			case "count":
+				v = strconv.Itoa(grp.count)
+			case "sum":
+				v = formatNumber(grp.sums[i])
+			case "avg":
+				// the average of no numbers is left empty
+				if grp.counts[i] > 0 {
+					v = formatNumber(grp.sums[i] / float64(grp.counts[i]))
+				}
+			default:
+				v = grp.values[i]
+			}
+			row = append(row, v)
+		}
+		if err := emit(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatNumber writes whole numbers without a decimal point, and others in as few digits as
+// identify them
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
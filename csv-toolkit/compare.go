@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV has no types: every field is text. Comparing "9" and "10" as text puts "10" first, so
+// fields are compared as the most specific type both sides parse as: numbers, then dates, then
+// text
+
+// dateLayouts are the date formats recognised, tried in order
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+func parseDate(s string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// number parses a field as a number; ParseFloat also accepts NaN and Inf, which are more likely to
+// be words in a text column than numbers
+func number(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// compare compares two fields, returning -1, 0 or 1
+func compare(a, b string) int {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := parseDate(a); ok {
+		if y, ok := parseDate(b); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// Condition is a filter on one column, such as age>=30 or name~^A
+type Condition struct {
+	Column string
+	Op     string
+	Value  string
+
+	re *regexp.Regexp
+}
+
+// operators are tried longest first, so <= isn't read as <
+var operators = []string{"<=", ">=", "!=", "!~", "==", "<", ">", "=", "~"}
+
+// ParseCondition parses column op value, where op is one of ==, !=, <, <=, >, >=, ~ (matches a
+// regular expression) or !~; = is the same as ==, and the value can be quoted
+func ParseCondition(s string) (*Condition, error) {
+	col, op, value := "", "", ""
+	best := -1
+	for _, o := range operators {
+		if i := strings.Index(s, o); i > 0 && (best < 0 || i < best || i == best && len(o) > len(op)) {
+			best, col, op, value = i, s[:i], o, s[i+len(o):]
+		}
+	}
+	if best < 0 {
+		return nil, fmt.Errorf("condition %q: want column, operator and value, such as age>=30", s)
+	}
+	if op == "=" {
+		op = "=="
+	}
+
+	c := &Condition{Column: strings.TrimSpace(col), Op: op, Value: strings.TrimSpace(value)}
+	if unquoted, err := strconv.Unquote(c.Value); err == nil {
+		c.Value = unquoted
+	} else if len(c.Value) >= 2 && c.Value[0] == '\'' && c.Value[len(c.Value)-1] == '\'' {
+		c.Value = c.Value[1 : len(c.Value)-1]
+	}
+
+	if op == "~" || op == "!~" {
+		re, err := regexp.Compile(c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %v", s, err)
+		}
+		c.re = re
+	}
+	return c, nil
+}
+
+// Match reports whether a field satisfies the condition
+func (c *Condition) Match(field string) bool {
+	switch c.Op {
+	case "~":
+		return c.re.MatchString(field)
+	case "!~":
+		return !c.re.MatchString(field)
+	}
+
+	n := compare(field, c.Value)
+	switch c.Op {
+	case "==":
+		return n == 0
+	case "!=":
+		return n != 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	}
+	return n >= 0
+}
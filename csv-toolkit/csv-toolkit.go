@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// The file examples read and write text a line or a byte at a time, but a lot of data comes as
+// tables, and CSV is the lowest common denominator for those
+// encoding/csv handles the format (quoting, embedded commas and newlines), and this command
+// builds the usual table operations on it, streaming rows through them so files of any size can
+// be processed:
+//   -where keeps rows matching conditions, comparing numbers and dates as such (compare.go)
+//   -group collapses rows into one per group, with aggregates (aggregate.go)
+//   -sort sorts, spilling to temporary files when there are too many rows for memory (sort.go)
+//   -select picks and reorders columns
+//   -o writes CSV, JSON Lines or an aligned table (output.go)
+// They're applied in that order, so -sort and -select can use the columns -group makes
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (s *stringList) String() string     { return strings.Join(*s, " ") }
+func (s *stringList) Set(v string) error { *s = append(*s, v); return nil }
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+
+	// Pick columns, in a new order, from the rows that match
+	// >> go run . -where 'dept=Engineering' -where 'salary>=90000' -select name,salary -o table employees.csv
+	// name          salary
+	// ------------  ------
+	// Ada Lovelace  125000
+	// Grace Hopper  118000
+	// Ken Thompson   97500
+
+	// Aggregate by department, highest average salary first
+	// >> go run . -group dept -agg count,avg:salary,max:hired -sort -avg_salary -o table employees.csv
+	// dept         count  avg_salary  max_hired
+	// -----------  -----  ----------  ----------
+	// Management       1      131000  2015-04-27
+	// Engineering      4      107375  2021-11-22
+	// Research         3      101750  2022-02-07
+
+	// Sort a file too big for memory, 100,000 rows at a time, as JSON Lines
+	// >> go run . -sort -hired,name -mem 100000 -o jsonl huge.csv
+}
+
+// run is the whole command, with its input and output passed in so it can be tested
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("csv-toolkit", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var where stringList
+	flags.Var(&where, "where", "keep rows matching a `condition` such as age>=30 or name~^A; can be repeated")
+	selectCols := flags.String("select", "", "comma-separated `columns` to write, in order")
+	groupCols := flags.String("group", "", "comma-separated `columns` to group by")
+	aggs := flags.String("agg", "count", "with -group, comma-separated aggregates: count, sum:col, avg:col, min:col, max:col")
+	sortKeys := flags.String("sort", "", "comma-separated `columns` to sort by; -col sorts descending")
+	maxRows := flags.Int("mem", 100000, "most rows -sort holds in memory before using temporary files")
+	format := flags.String("o", "csv", "output format: csv, jsonl or table")
+	delim := flags.String("d", ",", "input field delimiter")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: csv-toolkit [flags] [file]")
+		fmt.Fprintln(stderr, "reads CSV with a header row from the file, or stdin")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	in := stdin
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+	if name := flags.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(stderr, "csv-toolkit:", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	r := csv.NewReader(in)
+	if c, n := utf8.DecodeRuneInString(*delim); n == len(*delim) && n > 0 {
+		r.Comma = c
+	} else {
+		fmt.Fprintf(stderr, "csv-toolkit: the delimiter must be one character, not %q\n", *delim)
+		return 2
+	}
+
+	p := &plan{where: where, selectCols: *selectCols, groupCols: *groupCols, aggs: *aggs,
+		sortKeys: *sortKeys, maxRows: *maxRows, format: *format}
+	if err := p.run(r, stdout); err != nil {
+		fmt.Fprintln(stderr, "csv-toolkit:", err)
+		return 1
+	}
+	return 0
+}
+
+// plan is what to do to the rows
+type plan struct {
+	where                       []string
+	selectCols, groupCols, aggs string
+	sortKeys                    string
+	maxRows                     int
+	format                      string
+}
+
+// columnIndex finds a column by name
+func columnIndex(header []string, name string) (int, error) {
+	for i, h := range header {
+		if h == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no column named %q; the columns are %s", name, strings.Join(header, ", "))
+}
+
+func columnIndexes(header []string, names string) ([]int, error) {
+	var cols []int
+	for _, name := range strings.Split(names, ",") {
+		i, err := columnIndex(header, name)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, i)
+	}
+	return cols, nil
+}
+
+func (p *plan) run(r *csv.Reader, w io.Writer) error {
+	header, err := r.Read()
+	if err == io.EOF {
+		return fmt.Errorf("no header row")
+	}
+	if err != nil {
+		return err
+	}
+	// the header is kept, so the reader must not reuse its slice
+	header = append([]string(nil), header...)
+
+	type filter struct {
+		col int
+		c   *Condition
+	}
+	var filters []filter
+	for _, s := range p.where {
+		c, err := ParseCondition(s)
+		if err != nil {
+			return err
+		}
+		i, err := columnIndex(header, c.Column)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, filter{i, c})
+	}
+
+	var grouper *Grouper
+	if p.groupCols != "" {
+		cols, err := columnIndexes(header, p.groupCols)
+		if err != nil {
+			return err
+		}
+		aggs, err := ParseAggregates(p.aggs, header)
+		if err != nil {
+			return err
+		}
+		grouper = NewGrouper(cols, aggs)
+		header = grouper.Header(header)
+	}
+
+	var sorter *Sorter
+	if p.sortKeys != "" {
+		keys, err := ParseSortKeys(p.sortKeys, header)
+		if err != nil {
+			return err
+		}
+		sorter = NewSorter(keys, p.maxRows)
+		defer sorter.Close()
+	}
+
+	selected := make([]int, len(header))
+	for i := range selected {
+		selected[i] = i
+	}
+	if p.selectCols != "" {
+		if selected, err = columnIndexes(header, p.selectCols); err != nil {
+			return err
+		}
+	}
+	outHeader := make([]string, len(selected))
+	for i, c := range selected {
+		outHeader[i] = header[c]
+	}
+	out, err := NewOutput(p.format, w, outHeader)
+	if err != nil {
+		return err
+	}
+
+	// the stages are connected from the end back, each passing rows to the next
+	write := func(row []string) error {
+		picked := make([]string, len(selected))
+		for i, c := range selected {
+			picked[i] = row[c]
+		}
+		return out.Write(picked)
+	}
+	afterGroup := write
+	if sorter != nil {
+		afterGroup = sorter.Add
+	}
+	afterWhere := afterGroup
+	if grouper != nil {
+		afterWhere = grouper.Add
+	}
+
+rows:
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, f := range filters {
+			if !f.c.Match(row[f.col]) {
+				continue rows
+			}
+		}
+		if err := afterWhere(row); err != nil {
+			line, _ := r.FieldPos(0)
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+
+	if grouper != nil {
+		if err := grouper.Rows(afterGroup); err != nil {
+			return err
+		}
+	}
+	if sorter != nil {
+		if err := sorter.Sorted(write); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
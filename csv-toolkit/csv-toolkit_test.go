@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCondition(t *testing.T) {
+	var tests = []struct {
+		cond  string
+		field string
+		want  bool
+	}{
+		{"age>=30", "30", true},
+		{"age>=30", "9", false},
+		{"age<100", "99.5", true},
+		// numbers are compared as numbers, not text
+		{"age<10", "9", true},
+		{"age>9", "10", true},
+		{"name=Ada", "Ada", true},
+		{"name==Ada", "ada", false},
+		{"name!=Ada", "Grace", true},
+		{`name = "Ada Lovelace"`, "Ada Lovelace", true},
+		{"name='x y'", "x y", true},
+		{"hired<2020-01-01", "2019-12-31", true},
+		{"hired>2020-01-01", "2020-01-01T10:00:00Z", true},
+		{"name~^A", "Ada", true},
+		{"name!~^A", "Ada", false},
+		{"name~(?i)lovelace$", "Ada LOVELACE", true},
+		// text against a number falls back to comparing text
+		{"code<5", "A1", false},
+		{"word==nan", "nan", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cond+" "+tt.field, func(t *testing.T) {
+			c, err := ParseCondition(tt.cond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Match(tt.field); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"age", "=3", "name~("} {
+		if _, err := ParseCondition(bad); err == nil {
+			t.Errorf("%q: got no error", bad)
+		}
+	}
+}
+
+func TestExternalSort(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var rows [][]string
+	for i := 0; i < 1000; i++ {
+		// few distinct keys, so stability matters
+		rows = append(rows, []string{fmt.Sprint(rng.Intn(20)), fmt.Sprintf("row %d", i), "a,\"quoted\"\nfield"})
+	}
+	keys := []SortKey{{Column: 0, Descending: true}}
+
+	sorted := func(maxRows int) [][]string {
+		s := NewSorter(keys, maxRows)
+		for _, r := range rows {
+			if err := s.Add(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var out [][]string
+		if err := s.Sorted(func(r []string) error { out = append(out, r); return nil }); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	want := sorted(len(rows) + 1)
+	for i := 1; i < len(want); i++ {
+		if compare(want[i-1][0], want[i][0]) < 0 {
+			t.Fatalf("in-memory sort out of order at %d", i)
+		}
+	}
+	for _, maxRows := range []int{1, 7, 100, 999, 1000} {
+		got := sorted(maxRows)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("maxRows %d: external sort differs from the stable in-memory sort", maxRows)
+		}
+	}
+}
+
+func TestMergePasses(t *testing.T) {
+	// a run per row gives more runs than can be merged at once, and then more than that again
+	n := mergeFanIn*mergeFanIn + 10
+	s := NewSorter([]SortKey{{Column: 0}}, 1)
+	defer s.Close()
+	for i := 0; i < n; i++ {
+		// every key twice, the second copy marked, to check the order of equal rows
+		if err := s.Add([]string{fmt.Sprint((n - i) % (n / 2)), fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got [][]string
+	if err := s.Sorted(func(r []string) error { got = append(got, r); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if s.passes != 2 {
+		t.Errorf("got %d merge passes, want 2", s.passes)
+	}
+	if len(got) != n {
+		t.Fatalf("got %d rows, want %d", len(got), n)
+	}
+	for i := 1; i < n; i++ {
+		a, b := got[i-1], got[i]
+		if c := compare(a[0], b[0]); c > 0 || c == 0 && compare(a[1], b[1]) > 0 {
+			t.Fatalf("rows %d and %d out of order: %v, %v", i-1, i, a, b)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var tests = []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"passthrough", nil, `name,dept,salary,hired,city
+Ada Lovelace,Engineering,125000,2019-03-04,London
+Grace Hopper,Engineering,118000,2017-09-18,New York
+Alan Turing,Research,99000,2020-01-13,Manchester
+Katherine Johnson,Research,104500,2016-06-01,Hampton
+"Hamilton, Margaret",Engineering,89000,2021-11-22,Boston
+Edsger Dijkstra,Research,,2022-02-07,Eindhoven
+Barbara Liskov,Management,131000,2015-04-27,Boston
+Ken Thompson,Engineering,97500,2018-08-30,New York
+`},
+		{"select and where", []string{"-where", "city=Boston", "-select", "city,name"}, `city,name
+Boston,"Hamilton, Margaret"
+Boston,Barbara Liskov
+`},
+		{"sort", []string{"-where", "hired>=2020-01-01", "-sort", "dept,-name", "-select", "name", "-mem", "2"}, `name
+"Hamilton, Margaret"
+Edsger Dijkstra
+Alan Turing
+`},
+		{"group", []string{"-group", "dept", "-agg", "count,sum:salary,avg:salary,min:hired,max:name"}, `dept,count,sum_salary,avg_salary,min_hired,max_name
+Engineering,4,429500,107375,2017-09-18,Ken Thompson
+Research,3,203500,101750,2016-06-01,Katherine Johnson
+Management,1,131000,131000,2015-04-27,Barbara Liskov
+`},
+		{"group, sort and select", []string{"-group", "city", "-sort", "-count,city", "-select", "count,city", "-where", "salary>0"}, `count,city
+2,Boston
+2,New York
+1,Hampton
+1,London
+1,Manchester
+`},
+		{"jsonl", []string{"-where", "name~^(Ada|Edsger)", "-select", "name,salary", "-o", "jsonl"},
+			`{"name":"Ada Lovelace","salary":125000}
+{"name":"Edsger Dijkstra","salary":""}
+`},
+		{"table", []string{"-where", "dept=Research", "-select", "name,salary,city", "-o", "table"}, `name               salary  city
+-----------------  ------  ----------
+Alan Turing         99000  Manchester
+Katherine Johnson  104500  Hampton
+Edsger Dijkstra            Eindhoven
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errs bytes.Buffer
+			if status := run(append(tt.args, "employees.csv"), nil, &out, &errs); status != 0 {
+				t.Fatalf("status %d: %s", status, errs.String())
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	var tests = []struct {
+		name  string
+		args  []string
+		input string
+		want  string
+	}{
+		{"unknown column", []string{"-select", "age"}, "name\nAda\n", `no column named "age"`},
+		{"unknown aggregate", []string{"-group", "name", "-agg", "median:name"}, "name\nAda\n", "unknown aggregate"},
+		{"sum of text", []string{"-group", "a", "-agg", "sum:b"}, "a,b\nx,1\nx,two\n", `line 3: sum_b: "two" isn't a number`},
+		{"ragged row", nil, "a,b\n1,2\n3\n", "wrong number of fields"},
+		{"empty input", nil, "", "no header row"},
+		{"bad format", []string{"-o", "xml"}, "a\n1\n", "unknown output format"},
+		{"bad delimiter", []string{"-d", "ab"}, "a\n", "one character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errs bytes.Buffer
+			if status := run(tt.args, strings.NewReader(tt.input), &out, &errs); status == 0 {
+				t.Fatalf("got status 0, output %q", out.String())
+			}
+			if !strings.Contains(errs.String(), tt.want) {
+				t.Errorf("got %q, want an error containing %q", errs.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTabDelimited(t *testing.T) {
+	var out, errs bytes.Buffer
+	in := "name\tage\nAda\t36\nAlan\t41\n"
+	if status := run([]string{"-d", "\t", "-where", "age>40", "-o", "jsonl"}, strings.NewReader(in), &out, &errs); status != 0 {
+		t.Fatal(errs.String())
+	}
+	if got, want := out.String(), `{"name":"Alan","age":41}`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
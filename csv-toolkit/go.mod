@@ -0,0 +1,3 @@
+module example/csv-toolkit
+
+go 1.18
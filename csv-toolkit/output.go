@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rows can be written as CSV again, as JSON Lines (one object per row, keyed by the header), or
+// as a table with aligned columns for reading in a terminal
+
+// Output writes rows in one format
+type Output interface {
+	Write(row []string) error
+	Flush() error
+}
+
+// NewOutput returns an Output writing rows with header in format: csv, jsonl or table
+func NewOutput(format string, w io.Writer, header []string) (Output, error) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		return &csvOutput{cw}, nil
+	case "jsonl":
+		return &jsonOutput{w: bufio.NewWriter(w), header: header}, nil
+	case "table":
+		return &tableOutput{w: w, rows: [][]string{header}}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q: want csv, jsonl or table", format)
+}
+
+type csvOutput struct {
+	w *csv.Writer
+}
+
+func (o *csvOutput) Write(row []string) error { return o.w.Write(row) }
+
+func (o *csvOutput) Flush() error {
+	o.w.Flush()
+	return o.w.Error()
+}
+
+// jsonOutput writes each row as an object, with members in column order
+// fields that are JSON numbers are written as numbers; anything else, including numbers with
+// leading zeros such as zip codes, is a string
+type jsonOutput struct {
+	w      *bufio.Writer
+	header []string
+}
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+func (o *jsonOutput) Write(row []string) error {
+	o.w.WriteByte('{')
+	for i, name := range o.header {
+		if i > 0 {
+			o.w.WriteByte(',')
+		}
+		k, _ := json.Marshal(name)
+		o.w.Write(k)
+		o.w.WriteByte(':')
+		if jsonNumber.MatchString(row[i]) {
+			o.w.WriteString(row[i])
+		} else {
+			v, _ := json.Marshal(row[i])
+			o.w.Write(v)
+		}
+	}
+	_, err := o.w.WriteString("}\n")
+	return err
+}
+
+func (o *jsonOutput) Flush() error { return o.w.Flush() }
+
+// tableOutput has to see every row before it knows how wide the columns are, so it holds them all
+// numeric columns are aligned right, so their digits line up
+type tableOutput struct {
+	w    io.Writer
+	rows [][]string
+}
+
+func (o *tableOutput) Write(row []string) error {
+	o.rows = append(o.rows, row)
+	return nil
+}
+
+func (o *tableOutput) Flush() error {
+	header := o.rows[0]
+	widths := make([]int, len(header))
+	numeric := make([]bool, len(header))
+	for i := range numeric {
+		numeric[i] = len(o.rows) > 1
+	}
+	for r, row := range o.rows {
+		for i, v := range row {
+			if n := utf8.RuneCountInString(v); n > widths[i] {
+				widths[i] = n
+			}
+			if _, ok := number(v); r > 0 && !ok && v != "" {
+				numeric[i] = false
+			}
+		}
+	}
+
+	w := bufio.NewWriter(o.w)
+	line := func(row []string) {
+		for i, v := range row {
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(v))
+			if i > 0 {
+				w.WriteString("  ")
+			}
+			switch {
+			case numeric[i]:
+				w.WriteString(pad + v)
+			case i < len(row)-1:
+				w.WriteString(v + pad)
+			default:
+				// no trailing spaces after the last column
+				w.WriteString(v)
+			}
+		}
+		w.WriteByte('\n')
+	}
+
+	line(header)
+	rule := make([]string, len(header))
+	for i := range rule {
+		rule[i] = strings.Repeat("-", widths[i])
+	}
+	line(rule)
+	for _, row := range o.rows[1:] {
+		line(row)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Sorting needs every row before it can write the first, so sorting a file bigger than memory
+// needs an external merge sort:
+//   rows are collected until there are maxRows of them, sorted, and written to a temporary file
+//   at the end, the sorted files (runs) are read back together, always taking the smallest row at
+//   the head of any run, using a heap
+//   each run being read is an open file, so with more than mergeFanIn runs, they're first merged
+//   mergeFanIn at a time into longer runs, in as many passes as it takes
+// Memory then holds maxRows rows while collecting, and one row per run while merging
+// The sort is stable: each run is sorted stably, runs are merged in the order they were written,
+// and rows that compare equal are taken from the earlier run first
+
+// mergeFanIn is the most runs merged at once, which keeps well under the usual limits on open files
+const mergeFanIn = 64
+
+// SortKey is a column to sort by
+type SortKey struct {
+	Column     int
+	Descending bool
+}
+
+// ParseSortKeys parses a list such as -age,name: columns in order of precedence, with a leading -
+// for descending order
+func ParseSortKeys(s string, header []string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, name := range strings.Split(s, ",") {
+		k := SortKey{}
+		if strings.HasPrefix(name, "-") {
+			k.Descending = true
+			name = name[1:]
+		}
+		i, err := columnIndex(header, name)
+		if err != nil {
+			return nil, err
+		}
+		k.Column = i
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// less orders rows by keys
+func less(keys []SortKey, a, b []string) bool {
+	for _, k := range keys {
+		n := compare(a[k.Column], b[k.Column])
+		if k.Descending {
+			n = -n
+		}
+		if n != 0 {
+			return n < 0
+		}
+	}
+	return false
+}
+
+// Sorter sorts rows, spilling them to temporary files beyond maxRows
+type Sorter struct {
+	keys    []SortKey
+	maxRows int
+	rows    [][]string
+
+	// runs are the temporary files waiting to be merged, in the order they were written
+	runs []string
+	// passes counts the merges of runs into longer runs, before the final one
+	passes int
+}
+
+// NewSorter returns a Sorter holding at most maxRows rows in memory
+func NewSorter(keys []SortKey, maxRows int) *Sorter {
+	if maxRows < 1 {
+		maxRows = 1
+	}
+	return &Sorter{keys: keys, maxRows: maxRows}
+}
+
+// Add adds a row
+func (s *Sorter) Add(row []string) error {
+	s.rows = append(s.rows, row)
+	if len(s.rows) >= s.maxRows {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *Sorter) sortRows() {
+	sort.SliceStable(s.rows, func(i, j int) bool { return less(s.keys, s.rows[i], s.rows[j]) })
+}
+
+// spill sorts the rows in memory and writes them out as a run
+func (s *Sorter) spill() error {
+	s.sortRows()
+	i := 0
+	err := s.writeRun(func() ([]string, error) {
+		if i == len(s.rows) {
+			return nil, nil
+		}
+		i++
+		return s.rows[i-1], nil
+	})
+	s.rows = s.rows[:0]
+	return err
+}
+
+// writeRun writes the rows next returns, until it returns nil, to a new run
+// the file is closed once it's written, so only the runs being merged are open at once
+func (s *Sorter) writeRun(next func() ([]string, error)) error {
+	f, err := os.CreateTemp("", "csv-toolkit-run-*.csv")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	for {
+		row, err := next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("writing sort run: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing sort run: %v", err)
+	}
+	return nil
+}
+
+// Close removes any temporary files
+func (s *Sorter) Close() {
+	for _, name := range s.runs {
+		os.Remove(name)
+	}
+	s.runs = nil
+}
+
+// Sorted calls emit with every row, in order
+func (s *Sorter) Sorted(emit func([]string) error) error {
+	defer s.Close()
+
+	// everything fitted in memory
+	if len(s.runs) == 0 {
+		s.sortRows()
+		for _, row := range s.rows {
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	for len(s.runs) > mergeFanIn {
+		if err := s.mergePass(); err != nil {
+			return err
+		}
+	}
+	m, err := s.merge(s.runs)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+	for {
+		row, err := m.next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			return nil
+		}
+		if err := emit(row); err != nil {
+			return err
+		}
+	}
+}
+
+// mergePass merges the runs, mergeFanIn at a time, into fewer, longer runs
+// each group's files are removed once they're merged, so the disk holds about one copy of the rows
+func (s *Sorter) mergePass() error {
+	runs := s.runs
+	s.runs = nil
+	defer func() {
+		// on an error, what's left of the old runs is removed with the new ones by Close
+		s.runs = append(s.runs, runs...)
+	}()
+	for len(runs) > 0 {
+		n := mergeFanIn
+		if n > len(runs) {
+			n = len(runs)
+		}
+		m, err := s.merge(runs[:n])
+		if err != nil {
+			return err
+		}
+		err = s.writeRun(m.next)
+		m.close()
+		if err != nil {
+			return err
+		}
+		for _, name := range runs[:n] {
+			os.Remove(name)
+		}
+		runs = runs[n:]
+	}
+	s.passes++
+	return nil
+}
+
+// merger reads runs back together, in order
+type merger struct {
+	h     *runHeap
+	files []*os.File
+}
+
+// merge opens runs for merging
+func (s *Sorter) merge(runs []string) (*merger, error) {
+	m := &merger{h: &runHeap{keys: s.keys}}
+	for i, name := range runs {
+		f, err := os.Open(name)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		m.files = append(m.files, f)
+		r := &sortRun{index: i, r: csv.NewReader(f)}
+		r.r.FieldsPerRecord = -1
+		if err := r.next(); err != nil {
+			m.close()
+			return nil, err
+		}
+		if r.row != nil {
+			m.h.runs = append(m.h.runs, r)
+		}
+	}
+	heap.Init(m.h)
+	return m, nil
+}
+
+// next returns the smallest row at the head of any run, or nil when they've all been read
+func (m *merger) next() ([]string, error) {
+	if m.h.Len() == 0 {
+		return nil, nil
+	}
+	r := m.h.runs[0]
+	row := r.row
+	if err := r.next(); err != nil {
+		return nil, err
+	}
+	if r.row == nil {
+		heap.Pop(m.h)
+	} else {
+		heap.Fix(m.h, 0)
+	}
+	return row, nil
+}
+
+func (m *merger) close() {
+	for _, f := range m.files {
+		f.Close()
+	}
+}
+
+// sortRun is a sorted temporary file being merged, with the row at its head
+type sortRun struct {
+	index int
+	r     *csv.Reader
+	row   []string
+}
+
+// next reads the run's next row, leaving row nil at the end
+func (r *sortRun) next() error {
+	row, err := r.r.Read()
+	if err == io.EOF {
+		r.row = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading sort run: %v", err)
+	}
+	r.row = row
+	return nil
+}
+
+// runHeap orders runs by their head rows, and equal rows by run, which keeps the sort stable
+type runHeap struct {
+	keys []SortKey
+	runs []*sortRun
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+
+func (h *runHeap) Less(i, j int) bool {
+	a, b := h.runs[i], h.runs[j]
+	if less(h.keys, a.row, b.row) {
+		return true
+	}
+	if less(h.keys, b.row, a.row) {
+		return false
+	}
+	return a.index < b.index
+}
+
+func (h *runHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+
+func (h *runHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*sortRun)) }
+
+func (h *runHeap) Pop() interface{} {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
@@ -0,0 +1,3 @@
+module example/health-checks
+
+go 1.18
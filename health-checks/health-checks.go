@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
+	rpprof "runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Long-running servers are usually managed by something that needs to know whether they're alive
+// and whether they're ready to take traffic, such as a load balancer or a container orchestrator
+
+// The convention is two endpoints:
+// /healthz (liveness) fails when the process is broken and should be restarted
+// /readyz (readiness) fails when the process shouldn't be sent requests right now, for example
+// while it's warming up or draining during a graceful shutdown
+
+// Both are driven by registered checks, each with its own timeout so one slow dependency can't hang
+// the probe
+
+// Check reports whether some part of the server is healthy
+// It should return promptly once ctx is done
+type Check func(ctx context.Context) error
+
+// defaultTimeout is the timeout for a check registered without one
+const defaultTimeout = 5 * time.Second
+
+type namedCheck struct {
+	name    string
+	timeout time.Duration
+	check   Check
+}
+
+// Health holds the liveness and readiness checks for a server
+type Health struct {
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+
+	// draining is set to 1 once a graceful shutdown has started
+	draining int32
+}
+
+// AddLiveness registers a check that's run by /healthz
+// a timeout of zero, which would fail the check every time, means defaultTimeout
+func (h *Health) AddLiveness(name string, timeout time.Duration, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, newCheck(name, timeout, check))
+}
+
+// AddReadiness registers a check that's run by /readyz, with a timeout as for AddLiveness
+func (h *Health) AddReadiness(name string, timeout time.Duration, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, newCheck(name, timeout, check))
+}
+
+func newCheck(name string, timeout time.Duration, check Check) namedCheck {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return namedCheck{name, timeout, check}
+}
+
+// Drain marks the server as shutting down, so that /readyz starts failing and load balancers stop
+// routing new requests to it
+func (h *Health) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Draining reports whether Drain has been called
+func (h *Health) Draining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+// result is the outcome of a single check, as reported in the JSON response
+type result struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// run executes every check concurrently, each under its own timeout
+// a check that ignores its context is abandoned when the timeout expires rather than waited for
+func run(ctx context.Context, checks []namedCheck) ([]result, bool) {
+	results := make([]result, len(checks))
+	var wg sync.WaitGroup
+
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+
+			cctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			// the channel is buffered so the check's goroutine can always finish, even if we've
+			// stopped waiting for it
+			done := make(chan error, 1)
+			start := time.Now()
+			go func() { done <- c.check(cctx) }()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-cctx.Done():
+				// the probe's own request ending, when the caller gives up, cancels the check too,
+				// and that isn't the check timing out
+				err = cctx.Err()
+				if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+					err = fmt.Errorf("timed out after %v", c.timeout)
+				}
+			}
+
+			results[i] = result{Name: c.name, Status: "ok", Duration: time.Since(start).String()}
+			if err != nil {
+				results[i].Status = "failed"
+				results[i].Error = err.Error()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	ok := true
+	for _, r := range results {
+		if r.Status != "ok" {
+			ok = false
+		}
+	}
+
+	return results, ok
+}
+
+// respond writes the check results as JSON with a 200 or 503 status
+func respond(w http.ResponseWriter, ok bool, results []result) {
+	status := http.StatusOK
+	body := struct {
+		Status string   `json:"status"`
+		Checks []result `json:"checks"`
+	}{"ok", results}
+
+	if !ok {
+		status = http.StatusServiceUnavailable
+		body.Status = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Healthz is the liveness handler
+func (h *Health) Healthz(w http.ResponseWriter, req *http.Request) {
+	h.mu.RLock()
+	checks := h.liveness
+	h.mu.RUnlock()
+
+	results, ok := run(req.Context(), checks)
+	respond(w, ok, results)
+}
+
+// Readyz is the readiness handler
+// it fails immediately while the server is draining, without running any checks
+func (h *Health) Readyz(w http.ResponseWriter, req *http.Request) {
+	if h.Draining() {
+		respond(w, false, []result{{Name: "shutdown", Status: "failed", Error: "server is draining"}})
+		return
+	}
+
+	h.mu.RLock()
+	checks := h.readiness
+	h.mu.RUnlock()
+
+	results, ok := run(req.Context(), checks)
+	respond(w, ok, results)
+}
+
+// debugMux builds the handlers for the private debug listener
+// importing net/http/pprof registers its handlers on http.DefaultServeMux as a side effect, so the
+// public server must never use the default mux; here we wire them up explicitly instead
+func debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// expvar publishes memstats, the command line and any variables we define ourselves
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	// a full dump of every goroutine's stack, handy when a server seems stuck
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rpprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+
+	return mux
+}
+
+// requests counts the requests served by the public listener, and shows up in /debug/vars
+var requests = expvar.NewMap("requests")
+
+func hello(w http.ResponseWriter, req *http.Request) {
+	requests.Add(req.URL.Path, 1)
+	fmt.Fprintf(w, "hello\n")
+}
+
+func main() {
+	health := &Health{}
+
+	// liveness only checks things the process can fix by restarting
+	health.AddLiveness("goroutines", time.Second, func(ctx context.Context) error {
+		// a runaway number of goroutines usually means something is leaking them
+		if n := runtime.NumGoroutine(); n > 10000 {
+			return fmt.Errorf("%d goroutines running", n)
+		}
+		return nil
+	})
+
+	// readiness checks dependencies; this one simulates a warm-up period after starting
+	started := time.Now()
+	health.AddReadiness("warm-up", time.Second, func(ctx context.Context) error {
+		if time.Since(started) < 5*time.Second {
+			return errors.New("still warming up")
+		}
+		return nil
+	})
+
+	// a dependency check that watches its context, so it gives up as soon as its timeout expires
+	health.AddReadiness("slow-dependency", 200*time.Millisecond, func(ctx context.Context) error {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", hello)
+	mux.HandleFunc("/healthz", health.Healthz)
+	mux.HandleFunc("/readyz", health.Readyz)
+
+	public := &http.Server{Addr: ":8093", Handler: mux}
+
+	// the debug listener is bound to loopback only, so profiles and stack dumps never leave the
+	// machine
+	debug := &http.Server{Addr: "localhost:6060", Handler: debugMux()}
+
+	go func() {
+		if err := debug.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintln(os.Stderr, "debug server:", err)
+		}
+	}()
+
+	go func() {
+		if err := public.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintln(os.Stderr, "server:", err)
+			os.Exit(1)
+		}
+	}()
+
+	// as in the signals example, wait for SIGINT or SIGTERM to start a graceful shutdown
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	fmt.Println("server: received", <-sigs)
+
+	// first fail readiness and give load balancers time to notice before we stop accepting
+	// connections; in-flight requests keep being served during this window
+	health.Drain()
+	fmt.Println("server: draining")
+	time.Sleep(5 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := public.Shutdown(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "server: shutdown:", err)
+	}
+	debug.Shutdown(ctx)
+
+	// print the final request counts, sorted for stable output
+	var paths []string
+	requests.Do(func(kv expvar.KeyValue) { paths = append(paths, kv.Key) })
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Println("server: served", p, requests.Get(p))
+	}
+	fmt.Println("server: stopped")
+
+	// Run the server
+	// >> go run . &
+
+	// Readiness fails for the first few seconds, then passes
+	// >> curl -i localhost:8093/readyz
+
+	// Liveness is independent of readiness
+	// >> curl -i localhost:8093/healthz
+
+	// The debug endpoints are only reachable on the loopback listener
+	// >> curl localhost:6060/debug/vars
+	// >> curl localhost:6060/debug/goroutines
+	// >> go tool pprof localhost:6060/debug/pprof/heap
+
+	// Send SIGTERM and /readyz returns 503 while the server drains
+	// >> kill -TERM %1; curl -i localhost:8093/readyz
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+)
+
+// Cross-site request forgery is another site's page submitting a form to ours, which the browser
+// sends along with our cookies
+// ProtectCSRF uses the double-submit cookie pattern: each browser gets a random token in a cookie,
+// and pages put the same token in their forms; another site can make the browser send the cookie,
+// but can't read it to put it in the form as well
+
+const (
+	csrfCookie = "csrf_token"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+type csrfKey struct{}
+
+// CSRFToken returns the token ProtectCSRF gave the request, for putting in forms
+func CSRFToken(req *http.Request) string {
+	token, _ := req.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// ProtectCSRF makes sure every browser has a CSRF token, and rejects requests that could change
+// something, anything but GET, HEAD, OPTIONS and TRACE, unless they send the token back in a form
+// field or header
+func ProtectCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var token string
+		if c, err := req.Cookie(csrfCookie); err == nil && len(c.Value) == 44 {
+			token = c.Value
+		} else {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			token = base64.URLEncoding.EncodeToString(b)
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			sent := req.Header.Get(csrfHeader)
+			if sent == "" {
+				sent = req.PostFormValue(csrfField)
+			}
+			// a constant-time comparison doesn't give away how much of a guess was right
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				http.Error(w, "invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), csrfKey{}, token)))
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// A flash message is a one-off notice, such as "Thanks for signing", set by a handler that then
+// redirects, and shown by the next page rendered
+// Flashes travel in a cookie, so they survive the redirect without any server-side session; the
+// cookie isn't signed, but all a visitor could do by changing it is show themselves a different
+// message, which html/template escapes like any other data
+
+const flashCookie = "flash"
+
+// Flash is one message; Kind is for styling, such as "success" or "error"
+type Flash struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// AddFlash queues a message for the next page rendered, after any not yet shown
+// that page can be the one this handler renders, as when a form is shown again with an error
+func AddFlash(w http.ResponseWriter, req *http.Request, kind, message string) {
+	fs := append(flashes(w, req), Flash{kind, message})
+	b, _ := json.Marshal(fs)
+	setFlashCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    base64.URLEncoding.EncodeToString(b),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// flashes returns the messages waiting to be shown: those in the cookie already set on this
+// response, if AddFlash or clearFlashes has set it, or else those the request brought
+// a cookie that can't be read holds none
+func flashes(w http.ResponseWriter, req *http.Request) []Flash {
+	var value string
+	if c := responseFlashCookie(w); c != nil {
+		value = c.Value
+	} else if c, err := req.Cookie(flashCookie); err == nil {
+		value = c.Value
+	}
+	b, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	var fs []Flash
+	if json.Unmarshal(b, &fs) != nil {
+		return nil
+	}
+	return fs
+}
+
+// responseFlashCookie returns the flash cookie set on the response so far, if there is one
+func responseFlashCookie(w http.ResponseWriter) *http.Cookie {
+	// http.Response parses Set-Cookie headers, which is all that's needed of it here
+	resp := http.Response{Header: http.Header{"Set-Cookie": w.Header().Values("Set-Cookie")}}
+	for _, c := range resp.Cookies() {
+		if c.Name == flashCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+// setFlashCookie sets the flash cookie, replacing one already set on the response
+func setFlashCookie(w http.ResponseWriter, c *http.Cookie) {
+	var kept []string
+	for _, v := range w.Header().Values("Set-Cookie") {
+		if !strings.HasPrefix(v, flashCookie+"=") {
+			kept = append(kept, v)
+		}
+	}
+	w.Header()["Set-Cookie"] = kept
+	http.SetCookie(w, c)
+}
+
+func clearFlashes(w http.ResponseWriter) {
+	setFlashCookie(w, &http.Cookie{Name: flashCookie, Path: "/", MaxAge: -1})
+}
@@ -0,0 +1,3 @@
+module example/html-templates
+
+go 1.18
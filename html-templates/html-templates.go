@@ -0,0 +1,121 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The http-servers example writes its responses with fmt.Fprintf, which is fine for plain text
+// For HTML, anything a visitor typed has to be escaped before it goes in a page, and the right
+// escaping depends on where it goes: element text, an attribute, a URL, a script
+// html/template does that automatically, working out the context of every {{action}}; views.go
+// builds a small view layer on it for handlers, csrf.go protects forms, and flash.go carries
+// messages across redirects
+
+// Here it serves a guestbook, where every entry is untrusted input
+
+//go:embed templates
+var embedded embed.FS
+
+// Entry is one signature in the guestbook
+type Entry struct {
+	Name, Website, Message string
+}
+
+type guestbook struct {
+	views *Views
+
+	mu      sync.Mutex
+	entries []Entry
+}
+
+func (g *guestbook) index(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	g.mu.Lock()
+	entries := append([]Entry(nil), g.entries...)
+	g.mu.Unlock()
+
+	g.views.Render(w, req, http.StatusOK, "index", map[string]interface{}{"Entries": entries})
+}
+
+func (g *guestbook) sign(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	e := Entry{
+		Name:    strings.TrimSpace(req.PostFormValue("name")),
+		Website: strings.TrimSpace(req.PostFormValue("website")),
+		Message: strings.TrimSpace(req.PostFormValue("message")),
+	}
+	if e.Name == "" || e.Message == "" {
+		AddFlash(w, req, "error", "Please give your name and a message")
+	} else {
+		g.mu.Lock()
+		g.entries = append(g.entries, e)
+		g.mu.Unlock()
+		AddFlash(w, req, "success", "Thanks for signing, "+e.Name+"!")
+	}
+
+	// Post/Redirect/Get: reloading the page afterwards doesn't submit the form again
+	http.Redirect(w, req, "/", http.StatusSeeOther)
+}
+
+// broken renders a page whose data fails part way through, which becomes a 500
+func (g *guestbook) broken(w http.ResponseWriter, req *http.Request) {
+	g.views.Render(w, req, http.StatusOK, "broken", map[string]interface{}{
+		"Visitors": func() (int, error) { return 0, errors.New("visitor counter unavailable") },
+	})
+}
+
+func main() {
+	dev := flag.Bool("dev", false, "read templates from ./templates on every request, so edits show without a restart")
+	flag.Parse()
+
+	var fsys fs.FS
+	if *dev {
+		fsys = os.DirFS("templates")
+	} else {
+		fsys, _ = fs.Sub(embedded, "templates")
+	}
+	views, err := NewViews(fsys, *dev, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := &guestbook{views: views}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", g.index)
+	mux.HandleFunc("/sign", g.sign)
+	mux.HandleFunc("/broken", g.broken)
+
+	log.Fatal(http.ListenAndServe(":8097", ProtectCSRF(mux)))
+
+	// Run the server, or run it in dev mode and edit the templates while it's running
+	// >> go run . &
+	// >> go run . -dev &
+
+	// Visit http://localhost:8097 to sign the guestbook: names and messages containing HTML are
+	// shown as text, and a website of javascript:alert(1) is replaced with #ZgotmplZ, html/template's
+	// marker for an unsafe URL
+
+	// Posting without the form's CSRF token is refused
+	// >> curl -i -d name=Mallory -d message=hi localhost:8097/sign
+	// HTTP/1.1 403 Forbidden
+
+	// A template error is a clean 500, not half a page
+	// >> curl -i localhost:8097/broken
+	// HTTP/1.1 500 Internal Server Error
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testViews(t *testing.T) *Views {
+	t.Helper()
+	fsys, _ := fs.Sub(embedded, "templates")
+	v, err := NewViews(fsys, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRender(t *testing.T) {
+	v := testViews(t)
+
+	var tests = []struct {
+		name   string
+		page   string
+		data   interface{}
+		status int
+		want   []string
+	}{
+		{"escaped", "index", map[string]interface{}{"Entries": []Entry{
+			{"<b>Eve</b>", "javascript:alert(1)", `"quoted" & <script>`},
+			{"Ada", "https://example.com/?a=1&b=2", "hi"},
+		}}, http.StatusOK, []string{
+			`<title>Guestbook (2)</title>`,
+			`<a href="#ZgotmplZ">&lt;b&gt;Eve&lt;/b&gt;</a>: &#34;quoted&#34; &amp; &lt;script&gt;`,
+			`<a href="https://example.com/?a=1&amp;b=2">Ada</a>`,
+		}},
+		{"status kept", "index", map[string]interface{}{"Entries": []Entry{}}, http.StatusTeapot,
+			[]string{"Nobody has signed yet"}},
+		{"template error", "broken", map[string]interface{}{
+			"Visitors": func() (int, error) { return 0, errors.New("boom") },
+		}, http.StatusInternalServerError, []string{"Internal Server Error"}},
+		{"unknown page", "missing", nil, http.StatusInternalServerError, []string{"Internal Server Error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			v.Render(rec, httptest.NewRequest("GET", "/", nil), tt.status, tt.page, tt.data)
+			if rec.Code != tt.status {
+				t.Errorf("status: got %d, want %d", rec.Code, tt.status)
+			}
+			body := rec.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("body doesn't contain %q:\n%s", want, body)
+				}
+			}
+			// a failed render sends none of the page
+			if rec.Code == http.StatusInternalServerError && strings.Contains(body, "never sent") {
+				t.Errorf("failed render sent part of the page:\n%s", body)
+			}
+		})
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	h := ProtectCSRF(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(CSRFToken(req)))
+	}))
+
+	// a first visit gets a token, in a cookie and for the page
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != rec.Body.String() || rec.Body.Len() == 0 {
+		t.Fatalf("got cookies %v and token %q", cookies, rec.Body.String())
+	}
+	token := cookies[0]
+
+	var tests = []struct {
+		name   string
+		form   string
+		header string
+		cookie bool
+		want   int
+	}{
+		{"form field", "csrf_token=" + url.QueryEscape(token.Value), "", true, http.StatusOK},
+		{"header", "", token.Value, true, http.StatusOK},
+		{"no token", "", "", true, http.StatusForbidden},
+		{"wrong token", "csrf_token=" + strings.Repeat("A", 44), "", true, http.StatusForbidden},
+		{"no cookie", "csrf_token=" + url.QueryEscape(token.Value), "", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				req.Header.Set(csrfHeader, tt.header)
+			}
+			if tt.cookie {
+				req.AddCookie(token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlash(t *testing.T) {
+	v := testViews(t)
+
+	// a handler adds two flashes and redirects
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/sign", nil)
+	AddFlash(rec, req, "success", "<i>saved</i>")
+	req.AddCookie(rec.Result().Cookies()[0])
+	rec = httptest.NewRecorder()
+	AddFlash(rec, req, "error", "and again")
+	flash := rec.Result().Cookies()[0]
+
+	// the next page shows both, escaped, and clears them
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(flash)
+	v.Render(rec, req, http.StatusOK, "index", map[string]interface{}{"Entries": []Entry{}})
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<p class="flash success">&lt;i&gt;saved&lt;/i&gt;</p>`,
+		`<p class="flash error">and again</p>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body doesn't contain %q:\n%s", want, body)
+		}
+	}
+	if c := rec.Result().Cookies(); len(c) != 1 || c[0].Name != flashCookie || c[0].MaxAge >= 0 {
+		t.Errorf("flashes weren't cleared: %v", c)
+	}
+
+	// a failed render keeps them for the next page
+	rec = httptest.NewRecorder()
+	v.Render(rec, req, http.StatusOK, "broken", map[string]interface{}{
+		"Visitors": func() (int, error) { return 0, errors.New("boom") },
+	})
+	if c := rec.Result().Cookies(); len(c) != 0 {
+		t.Errorf("failed render changed cookies: %v", c)
+	}
+}
+
+func TestFlashSameResponse(t *testing.T) {
+	v := testViews(t)
+
+	// a handler adds flashes, to one the request brought, and renders the form again rather than
+	// redirecting
+	rec := httptest.NewRecorder()
+	AddFlash(rec, httptest.NewRequest("POST", "/sign", nil), "success", "earlier")
+	req := httptest.NewRequest("POST", "/sign", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+
+	rec = httptest.NewRecorder()
+	AddFlash(rec, req, "error", "Please give your name")
+	AddFlash(rec, req, "error", "and a message")
+	v.Render(rec, req, http.StatusUnprocessableEntity, "index", map[string]interface{}{"Entries": []Entry{}})
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<p class="flash success">earlier</p>`,
+		`<p class="flash error">Please give your name</p>`,
+		`<p class="flash error">and a message</p>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body doesn't contain %q:\n%s", want, body)
+		}
+	}
+	// they've all been shown, so the one cookie left clears them
+	if c := rec.Result().Cookies(); len(c) != 1 || c[0].Name != flashCookie || c[0].MaxAge >= 0 {
+		t.Errorf("flashes weren't cleared: %v", c)
+	}
+}
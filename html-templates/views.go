@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// This file is the view layer: it turns a page name and some data into an HTML response
+// Templates are laid out as:
+//   layout.html   the page structure, with {{block}}s for pages to fill in
+//   partials/     {{define}}s shared by the layout and pages
+//   pages/        one file per page, {{define}}ing the blocks it fills in
+// Each page is parsed into its own clone of the layout and partials, since every page defines
+// the same blocks
+
+// Views renders pages from a set of templates
+type Views struct {
+	fsys  fs.FS
+	dev   bool
+	funcs template.FuncMap
+
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+}
+
+// NewViews parses the templates in fsys
+// in dev mode they're parsed again for every render, so edits show up on the next request without
+// restarting the server; otherwise they're parsed once, here, and a broken template stops the
+// server starting rather than failing requests later
+func NewViews(fsys fs.FS, dev bool, funcs template.FuncMap) (*Views, error) {
+	v := &Views{fsys: fsys, dev: dev, funcs: funcs}
+	pages, err := v.parse()
+	if err != nil {
+		return nil, err
+	}
+	v.pages = pages
+	return v, nil
+}
+
+func (v *Views) parse() (map[string]*template.Template, error) {
+	base, err := template.New("layout.html").Funcs(v.funcs).ParseFS(v.fsys, "layout.html")
+	if err != nil {
+		return nil, err
+	}
+	if partials, _ := fs.Glob(v.fsys, "partials/*.html"); len(partials) > 0 {
+		if _, err := base.ParseFS(v.fsys, partials...); err != nil {
+			return nil, err
+		}
+	}
+
+	names, err := fs.Glob(v.fsys, "pages/*.html")
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		t, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := t.ParseFS(v.fsys, name); err != nil {
+			return nil, err
+		}
+		pages[strings.TrimSuffix(path.Base(name), ".html")] = t
+	}
+	return pages, nil
+}
+
+func (v *Views) page(name string) (*template.Template, error) {
+	if v.dev {
+		pages, err := v.parse()
+		if err != nil {
+			return nil, err
+		}
+		v.mu.Lock()
+		v.pages = pages
+		v.mu.Unlock()
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	t, ok := v.pages[name]
+	if !ok {
+		return nil, fmt.Errorf("no page named %q", name)
+	}
+	return t, nil
+}
+
+// View is what templates are executed with: the handler's data, and the helpers every page can use
+type View struct {
+	Data    interface{}
+	Flashes []Flash
+
+	csrf string
+}
+
+// CSRFToken is the request's CSRF token, for forms built by hand or for JavaScript to send in the
+// X-CSRF-Token header
+func (v View) CSRFToken() string {
+	return v.csrf
+}
+
+// CSRFField is a hidden form field holding the CSRF token, for {{.CSRFField}} inside a <form>
+func (v View) CSRFField() template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfField + `" value="` +
+		template.HTMLEscapeString(v.csrf) + `">`)
+}
+
+// Render executes a page and sends it with the given status
+// the page is executed into a buffer first, so a template error becomes a 500 response rather
+// than half a page sent with a 200 status that can no longer be changed
+func (v *Views) Render(w http.ResponseWriter, req *http.Request, status int, name string, data interface{}) {
+	t, err := v.page(name)
+	if err != nil {
+		v.fail(w, name, err)
+		return
+	}
+
+	view := View{Data: data, Flashes: flashes(w, req), csrf: CSRFToken(req)}
+	var b bytes.Buffer
+	if err := t.ExecuteTemplate(&b, "layout.html", view); err != nil {
+		v.fail(w, name, err)
+		return
+	}
+
+	// flashes are only cleared once they've been shown, so a failed render doesn't lose them
+	if len(view.Flashes) > 0 {
+		clearFlashes(w)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	b.WriteTo(w)
+}
+
+// fail logs a rendering error and sends a 500
+// the details are only shown in dev mode: in production they'd tell visitors about the code
+func (v *Views) fail(w http.ResponseWriter, name string, err error) {
+	log.Printf("rendering %s: %v", name, err)
+	msg := http.StatusText(http.StatusInternalServerError)
+	if v.dev {
+		msg += ": " + err.Error()
+	}
+	http.Error(w, msg, http.StatusInternalServerError)
+}
@@ -0,0 +1,3 @@
+module example/http-caching
+
+go 1.18
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In the http-clients example every request goes to the network, even if we fetched the same page
+// a moment ago
+
+// HTTP has a detailed caching model (RFC 9111): servers describe how long a response stays fresh
+// with Cache-Control and Expires, which request headers it depends on with Vary, and give
+// validators (ETag and Last-Modified) so a stale copy can be checked cheaply with a conditional
+// request instead of downloaded again
+
+// Here we'll build a private cache, the kind a browser has, as an http.RoundTripper
+// Each response it returns is marked with an X-Cache header of HIT, MISS or REVALIDATED
+
+// Storage persists cache entries by key
+type Storage interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte) error
+	Delete(key string) error
+}
+
+// MemoryStorage keeps entries in a map
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	entries map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{entries: make(map[string][]byte)}
+}
+
+func (m *MemoryStorage) Get(key string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.entries[key]
+	return v, ok
+}
+
+func (m *MemoryStorage) Set(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = value
+	return nil
+}
+
+func (m *MemoryStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// DiskStorage keeps each entry in its own file, named by the SHA256 of its key so that arbitrary
+// URLs map onto safe file names
+type DiskStorage struct {
+	Dir string
+}
+
+func (d DiskStorage) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (d DiskStorage) Get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(d.path(key))
+	return b, err == nil
+}
+
+// Set writes to a temporary file and renames it into place, so a reader never sees a partly
+// written entry
+func (d DiskStorage) Set(key string, value []byte) error {
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(d.Dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(value); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), d.path(key))
+}
+
+func (d DiskStorage) Delete(key string) error {
+	err := os.Remove(d.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// entry is a stored response along with what we need to compute its age and match its variants
+type entry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+
+	// RequestTime and ResponseTime bracket the request that produced the response, as in the age
+	// calculation of RFC 9111 section 4.2.3
+	RequestTime  time.Time `json:"request_time"`
+	ResponseTime time.Time `json:"response_time"`
+
+	// Vary holds the values of the request headers named by the response's Vary header
+	// a later request only matches if it has the same values
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+// Cache status values reported in the X-Cache header
+const (
+	Hit         = "HIT"
+	Miss        = "MISS"
+	Revalidated = "REVALIDATED"
+)
+
+// Transport is a caching http.RoundTripper
+type Transport struct {
+	// Base performs the actual requests; http.DefaultTransport is used if nil
+	Base http.RoundTripper
+
+	// Storage holds the cache; a MemoryStorage is used if nil
+	Storage Storage
+
+	// MaxBodySize is the largest response body that will be cached; 0 means defaultMaxBodySize
+	MaxBodySize int64
+
+	// now is overridable so the freshness logic doesn't depend on the wall clock
+	now func() time.Time
+
+	// defaults fills in the fields left unset the first time the Transport is used
+	defaults sync.Once
+}
+
+const defaultMaxBodySize = 10 << 20
+
+// NewTransport returns a caching transport over storage
+// a Transport literal works as well, with the defaults described on its fields
+func NewTransport(storage Storage) *Transport {
+	return &Transport{Storage: storage, MaxBodySize: defaultMaxBodySize, now: time.Now}
+}
+
+// setDefaults fills in the fields a Transport literal can leave unset
+func (t *Transport) setDefaults() {
+	t.defaults.Do(func() {
+		if t.Storage == nil {
+			t.Storage = NewMemoryStorage()
+		}
+		if t.MaxBodySize == 0 {
+			t.MaxBodySize = defaultMaxBodySize
+		}
+		if t.now == nil {
+			t.now = time.Now
+		}
+	})
+}
+
+// directives parses a Cache-Control header into a map of lowercased directive names to values
+// directives without a value, like no-store, map to the empty string
+func directives(h http.Header) map[string]string {
+	d := make(map[string]string)
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			d[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return d
+}
+
+// seconds reads a delta-seconds directive value
+func seconds(d map[string]string, name string) (time.Duration, bool) {
+	v, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// heuristicallyCacheable lists the status codes that may be cached without explicit freshness
+// information (RFC 9110 section 15.1)
+var heuristicallyCacheable = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// storable reports whether a response to req may be stored at all (RFC 9111 section 3)
+// as a private cache we're allowed to store responses marked private
+func storable(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	// entries are keyed by URL alone, so part of a body stored under one would later be served as
+	// the whole of it; a cache that stores them has to combine the parts (section 3.3)
+	if req.Header.Get("Range") != "" || resp.StatusCode == http.StatusPartialContent {
+		return false
+	}
+	if _, ok := directives(req.Header)["no-store"]; ok {
+		return false
+	}
+
+	cc := directives(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if strings.TrimSpace(resp.Header.Get("Vary")) == "*" {
+		return false
+	}
+
+	// without explicit freshness or a heuristically cacheable status, there's no point keeping it
+	_, maxAge := cc["max-age"]
+	_, noCache := cc["no-cache"]
+	return maxAge || noCache || resp.Header.Get("Expires") != "" || heuristicallyCacheable[resp.StatusCode]
+}
+
+// freshnessLifetime works out how long a stored response stays fresh (RFC 9111 section 4.2.1)
+func freshnessLifetime(e *entry) time.Duration {
+	cc := directives(e.Header)
+	if d, ok := seconds(cc, "max-age"); ok {
+		return d
+	}
+
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+
+	if exp := e.Header.Get("Expires"); exp != "" {
+		// an invalid Expires, such as "0", means already expired
+		t, err := http.ParseTime(exp)
+		if err != nil || !t.After(date) {
+			return 0
+		}
+		return t.Sub(date)
+	}
+
+	// with nothing explicit, a common heuristic is 10% of the time since the resource last changed
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && heuristicallyCacheable[e.Status] {
+		if date.After(lm) {
+			return date.Sub(lm) / 10
+		}
+	}
+
+	return 0
+}
+
+// currentAge estimates how old a stored response is (RFC 9111 section 4.2.3)
+func currentAge(e *entry, now time.Time) time.Duration {
+	var apparent time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		if d := e.ResponseTime.Sub(date); d > 0 {
+			apparent = d
+		}
+	}
+
+	var ageValue time.Duration
+	if n, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && n > 0 {
+		ageValue = time.Duration(n) * time.Second
+	}
+
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	initial := apparent
+	if correctedAge > initial {
+		initial = correctedAge
+	}
+
+	return initial + now.Sub(e.ResponseTime)
+}
+
+// usable reports whether a stored response can be served to req without contacting the server,
+// taking the request's own max-age, min-fresh and max-stale directives into account
+func usable(req *http.Request, e *entry, now time.Time) bool {
+	rcc := directives(req.Header)
+	cc := directives(e.Header)
+
+	if _, ok := rcc["no-cache"]; ok {
+		return false
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+	if req.Header.Get("Pragma") == "no-cache" && req.Header.Get("Cache-Control") == "" {
+		return false
+	}
+
+	age := currentAge(e, now)
+	lifetime := freshnessLifetime(e)
+
+	if d, ok := seconds(rcc, "max-age"); ok && age > d {
+		return false
+	}
+	if d, ok := seconds(rcc, "min-fresh"); ok {
+		age += d
+	}
+	if age < lifetime {
+		return true
+	}
+
+	// the response is stale; the client may still accept it with max-stale, unless the server
+	// insisted on revalidation
+	_, mustRevalidate := cc["must-revalidate"]
+	if v, ok := rcc["max-stale"]; ok && !mustRevalidate {
+		if v == "" {
+			return true
+		}
+		if d, ok := seconds(rcc, "max-stale"); ok && age-lifetime <= d {
+			return true
+		}
+	}
+
+	return false
+}
+
+// varyMatches reports whether req selects the stored variant
+func varyMatches(req *http.Request, e *entry) bool {
+	for name, value := range e.Vary {
+		if strings.Join(req.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// varyValues records the request headers that the response says it varies on
+func varyValues(req *http.Request, resp *http.Response) map[string]string {
+	v := make(map[string]string)
+	for _, line := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" {
+				v[name] = strings.Join(req.Header.Values(name), ", ")
+			}
+		}
+	}
+	return v
+}
+
+func cacheKey(req *http.Request) string {
+	return req.Method + " " + req.URL.String()
+}
+
+func (t *Transport) load(key string) *entry {
+	b, ok := t.Storage.Get(key)
+	if !ok {
+		return nil
+	}
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		// a corrupt entry is simply a miss
+		t.Storage.Delete(key)
+		return nil
+	}
+	return &e
+}
+
+func (t *Transport) store(key string, e *entry) {
+	b, err := json.Marshal(e)
+	if err == nil {
+		t.Storage.Set(key, b)
+	}
+}
+
+// response turns a stored entry back into an *http.Response
+func (t *Transport) response(req *http.Request, e *entry, status string) *http.Response {
+	h := e.Header.Clone()
+	h.Set("Age", strconv.FormatInt(int64(currentAge(e, t.now())/time.Second), 10))
+	h.Set("X-Cache", status)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// Headers that describe the message rather than the resource, and so aren't updated from a 304
+var notUpdatedBy304 = map[string]bool{
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Content-Range":     true,
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.setDefaults()
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	key := cacheKey(req)
+
+	// unsafe methods change the resource, so any stored copy of it is now suspect (RFC 9111
+	// section 4.4)
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := base.RoundTrip(req)
+		if err == nil && resp.StatusCode < 400 {
+			t.Storage.Delete(http.MethodGet + " " + req.URL.String())
+			if loc, err := req.URL.Parse(resp.Header.Get("Location")); err == nil && loc.Host == req.URL.Host {
+				t.Storage.Delete(http.MethodGet + " " + loc.String())
+			}
+		}
+		return resp, err
+	}
+	if req.Method != http.MethodGet {
+		return base.RoundTrip(req)
+	}
+
+	e := t.load(key)
+	if e != nil && !varyMatches(req, e) {
+		e = nil
+	}
+
+	if e != nil && usable(req, e, t.now()) {
+		return t.response(req, e, Hit), nil
+	}
+
+	// only-if-cached asks us not to go to the network at all
+	if _, ok := directives(req.Header)["only-if-cached"]; ok {
+		return &http.Response{
+			Status:     "504 Gateway Timeout",
+			StatusCode: http.StatusGatewayTimeout,
+			Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+			Header:  http.Header{"X-Cache": {Miss}},
+			Body:    http.NoBody,
+			Request: req,
+		}, nil
+	}
+
+	// a stale entry with validators can be revalidated with a conditional request; we only add
+	// conditions the caller didn't set, so their own conditional requests pass through untouched
+	out := req
+	conditional := false
+	if e != nil && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		etag, lm := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+		if etag != "" || lm != "" {
+			out = req.Clone(req.Context())
+			if etag != "" {
+				out.Header.Set("If-None-Match", etag)
+			}
+			if lm != "" {
+				out.Header.Set("If-Modified-Since", lm)
+			}
+			conditional = true
+		}
+	}
+
+	requestTime := t.now()
+	resp, err := base.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := t.now()
+
+	// the stored response is still good: freshen its headers and serve it (RFC 9111 section 4.3.4)
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		for k, vv := range resp.Header {
+			if !notUpdatedBy304[k] {
+				e.Header[k] = vv
+			}
+		}
+		e.RequestTime, e.ResponseTime = requestTime, responseTime
+		t.store(key, e)
+
+		return t.response(req, e, Revalidated), nil
+	}
+
+	resp.Header.Set("X-Cache", Miss)
+	if !storable(req, resp) {
+		// a stale variant that can no longer be stored shouldn't linger
+		if e != nil {
+			t.Storage.Delete(key)
+		}
+		return resp, nil
+	}
+
+	// read the body to store it, giving up on caching (but not on the response) if it's too big
+	body, err := io.ReadAll(io.LimitReader(resp.Body, t.MaxBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > t.MaxBodySize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	stored := resp.Header.Clone()
+	stored.Del("X-Cache")
+	t.store(key, &entry{
+		Status:       resp.StatusCode,
+		Header:       stored,
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Vary:         varyValues(req, resp),
+	})
+
+	return resp, nil
+}
+
+// demoServer serves a few resources with different caching rules
+func demoServer() *httptest.Server {
+	mux := http.NewServeMux()
+	version := time.Now().UTC().Truncate(time.Second)
+
+	// fresh for one second, then revalidated by ETag
+	mux.HandleFunc("/fresh", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=1")
+		w.Header().Set("ETag", `"v1"`)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintln(w, "fresh for a second")
+	})
+
+	// always revalidated, by Last-Modified this time
+	mux.HandleFunc("/validate", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Last-Modified", version.Format(http.TimeFormat))
+		if t, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !version.After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintln(w, "check with me every time")
+	})
+
+	// a different representation for each language
+	mux.HandleFunc("/greeting", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		if strings.HasPrefix(req.Header.Get("Accept-Language"), "fr") {
+			fmt.Fprintln(w, "bonjour")
+			return
+		}
+		fmt.Fprintln(w, "hello")
+	})
+
+	// never stored
+	mux.HandleFunc("/secret", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprintln(w, "don't keep this")
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func main() {
+	srv := demoServer()
+	defer srv.Close()
+
+	get := func(client *http.Client, path string, header ...string) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Printf("%-10s %-12s %s", path, resp.Header.Get("X-Cache"), body)
+	}
+
+	// an in-memory cache plugs into an ordinary client
+	client := &http.Client{Transport: NewTransport(NewMemoryStorage())}
+
+	get(client, "/fresh")
+	get(client, "/fresh")
+	time.Sleep(1100 * time.Millisecond)
+	get(client, "/fresh")
+
+	get(client, "/validate")
+	get(client, "/validate")
+
+	get(client, "/greeting", "Accept-Language", "en")
+	get(client, "/greeting", "Accept-Language", "en")
+	get(client, "/greeting", "Accept-Language", "fr")
+
+	get(client, "/secret")
+	get(client, "/secret")
+
+	// a disk cache survives between clients, and between runs of the program
+	dir, err := os.MkdirTemp("", "http-cache")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	get(&http.Client{Transport: NewTransport(DiskStorage{dir})}, "/greeting")
+	get(&http.Client{Transport: NewTransport(DiskStorage{dir})}, "/greeting")
+
+	// Running the program shows the first request for each resource as a MISS, then HITs while
+	// it's fresh, REVALIDATED once it goes stale or for no-cache responses, and a separate MISS for
+	// each variant of /greeting
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// get fetches a URL, returning the X-Cache header and the body
+func get(t *testing.T, c *http.Client, url string, header http.Header) (string, string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Header.Get("X-Cache"), string(body)
+}
+
+func TestPartialContent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		if req.URL.Path == "/always-partial" {
+			// a 206 isn't stored even if the request had no Range header
+			w.Header().Set("Content-Range", "bytes 0-2/14")
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, "the")
+			return
+		}
+		// ServeContent answers Range requests with 206 Partial Content
+		http.ServeContent(w, req, "", time.Time{}, strings.NewReader("the whole body"))
+	}))
+	defer srv.Close()
+	c := &http.Client{Transport: NewTransport(NewMemoryStorage())}
+
+	var tests = []struct {
+		name   string
+		path   string
+		header http.Header
+		cache  string
+		body   string
+	}{
+		// neither the range request nor its 206 is stored
+		{"range", "/", http.Header{"Range": {"bytes=0-2"}}, Miss, "the"},
+		{"after a range", "/", nil, Miss, "the whole body"},
+		{"stored", "/", nil, Hit, "the whole body"},
+		{"partial", "/always-partial", nil, Miss, "the"},
+		{"partial again", "/always-partial", nil, Miss, "the"},
+	}
+	for _, tt := range tests {
+		cache, body := get(t, c, srv.URL+tt.path, tt.header)
+		if cache != tt.cache || body != tt.body {
+			t.Errorf("%s: got %s %q, want %s %q", tt.name, cache, body, tt.cache, tt.body)
+		}
+	}
+}
+
+func TestZeroTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "hello")
+	}))
+	defer srv.Close()
+
+	// no Storage, MaxBodySize or clock, as NewTransport would set
+	c := &http.Client{Transport: &Transport{}}
+	for _, want := range []string{Miss, Hit} {
+		if cache, body := get(t, c, srv.URL, nil); cache != want || body != "hello" {
+			t.Errorf("got %s %q, want %s %q", cache, body, want, "hello")
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module example/http-record-replay
+
+go 1.18
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Code built on the http-clients example can only be tested with network access, which makes
+// tests slow, flaky, and dependent on someone else's server
+
+// A common answer is to record real HTTP interactions once into a "cassette" file, and replay them
+// in tests afterwards
+// Here we'll build a RoundTripper that does both:
+//   in record mode it sends requests to the network and saves each request/response pair
+//   in replay mode it answers requests from the cassette without touching the network
+
+// Cassettes are versioned JSON so they can be reviewed in diffs, and sensitive headers such as
+// Authorization are redacted before anything is written to disk
+
+// CassetteVersion is the format version written to new cassettes
+const CassetteVersion = 1
+
+// Cassette is the on-disk collection of recorded interactions
+type Cassette struct {
+	Version      int            `json:"version"`
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request and its response
+type Interaction struct {
+	Request    RecordedRequest  `json:"request"`
+	Response   RecordedResponse `json:"response"`
+	RecordedAt time.Time        `json:"recorded_at"`
+
+	// used is set when the interaction is replayed, so repeated identical requests are answered
+	// by successive recordings in order
+	used bool
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is stored as a plain string when it's valid UTF-8, so cassettes stay readable, and as
+// base64 otherwise
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+
+	var enc struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return err
+	}
+	raw, err := base64.StdEncoding.DecodeString(enc.Base64)
+	*b = raw
+	return err
+}
+
+// LoadCassette reads a cassette file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if c.Version != CassetteVersion {
+		return nil, fmt.Errorf("%s: unsupported cassette version %d (want %d)", path, c.Version, CassetteVersion)
+	}
+	return &c, nil
+}
+
+// Save writes a cassette file, creating its directory if needed
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Matcher decides whether a recorded interaction answers an incoming request
+// body is the incoming request's body, already read
+type Matcher func(req *http.Request, body []byte, rec *RecordedRequest) bool
+
+// MatchMethod compares request methods
+func MatchMethod(req *http.Request, body []byte, rec *RecordedRequest) bool {
+	return req.Method == rec.Method
+}
+
+// MatchURL compares full URLs, including the query string
+func MatchURL(req *http.Request, body []byte, rec *RecordedRequest) bool {
+	return req.URL.String() == rec.URL
+}
+
+// MatchBody compares request bodies byte for byte
+func MatchBody(req *http.Request, body []byte, rec *RecordedRequest) bool {
+	return bytes.Equal(body, rec.Body)
+}
+
+// MatchHeaders returns a Matcher that compares the named headers
+// redacted headers are compared in their redacted form, so they only need to be present
+func (r *Recorder) MatchHeaders(names ...string) Matcher {
+	return func(req *http.Request, body []byte, rec *RecordedRequest) bool {
+		h := r.redact(req.Header)
+		for _, name := range names {
+			if strings.Join(h.Values(name), ",") != strings.Join(rec.Header.Values(name), ",") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAll combines matchers, matching only if every one of them does
+func MatchAll(ms ...Matcher) Matcher {
+	return func(req *http.Request, body []byte, rec *RecordedRequest) bool {
+		for _, m := range ms {
+			if !m(req, body, rec) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Mode selects whether a Recorder records or replays
+type Mode int
+
+const (
+	// ModeReplay answers requests from the cassette
+	ModeReplay Mode = iota
+
+	// ModeRecord sends requests to the network and records them, replacing the cassette
+	ModeRecord
+)
+
+// ErrNoMatch is returned in strict replay mode for a request that isn't in the cassette
+var ErrNoMatch = errors.New("no recorded interaction matches the request")
+
+// Recorder is an http.RoundTripper that records or replays interactions
+type Recorder struct {
+	// Base performs real requests; http.DefaultTransport is used if nil
+	Base http.RoundTripper
+
+	Mode Mode
+	Path string
+
+	// Match selects the recorded interaction for a request; by default method and URL must match
+	Match Matcher
+
+	// Strict makes unmatched requests in replay mode fail with ErrNoMatch
+	// otherwise they're passed through to Base
+	Strict bool
+
+	// Redact lists headers whose values are replaced before being written to the cassette
+	Redact []string
+
+	mu       sync.Mutex
+	cassette *Cassette
+}
+
+// DefaultRedact lists headers that commonly hold credentials
+var DefaultRedact = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Redacted is the placeholder stored instead of a sensitive header value
+const Redacted = "REDACTED"
+
+// New creates a recorder for the cassette at path
+// in replay mode the cassette must already exist
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		Mode:   mode,
+		Path:   path,
+		Match:  MatchAll(MatchMethod, MatchURL),
+		Redact: DefaultRedact,
+	}
+
+	if mode == ModeRecord {
+		r.cassette = &Cassette{Version: CassetteVersion}
+		return r, nil
+	}
+
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	r.cassette = c
+	return r, nil
+}
+
+// redact returns a copy of h with sensitive values replaced
+func (r *Recorder) redact(h http.Header) http.Header {
+	out := h.Clone()
+	if out == nil {
+		out = http.Header{}
+	}
+	for _, name := range r.Redact {
+		if vv := out.Values(name); len(vv) > 0 {
+			redacted := make([]string, len(vv))
+			for i := range redacted {
+				redacted[i] = Redacted
+			}
+			out[http.CanonicalHeaderKey(name)] = redacted
+		}
+	}
+	return out
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the body is needed both for matching or recording, and to send the request onwards
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Mode == ModeReplay {
+		if i := r.find(req, body); i != nil {
+			return i.response(req), nil
+		}
+		if r.Strict {
+			return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, ErrNoMatch)
+		}
+		return r.send(req, body)
+	}
+
+	resp, err := r.send(req, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// read the whole response so it can be saved, then hand the caller a fresh reader over it
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: r.redact(req.Header),
+			Body:   body,
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     r.redact(resp.Header),
+			Body:       respBody,
+		},
+		RecordedAt: time.Now().UTC(),
+	})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// send passes a request on to the base transport with its body restored
+// a RoundTripper mustn't modify the request it's given, so the body goes on a copy
+func (r *Recorder) send(req *http.Request, body []byte) (*http.Response, error) {
+	base := r.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	out := req.Clone(req.Context())
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.ContentLength = int64(len(body))
+	}
+	return base.RoundTrip(out)
+}
+
+// find returns the first matching interaction that hasn't been replayed yet, or if they've all
+// been used, the last matching one, so a request repeated more often than it was recorded still
+// gets an answer
+func (r *Recorder) find(req *http.Request, body []byte) *Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var last *Interaction
+	for _, i := range r.cassette.Interactions {
+		if !r.Match(req, body, &i.Request) {
+			continue
+		}
+		if !i.used {
+			i.used = true
+			return i
+		}
+		last = i
+	}
+	return last
+}
+
+// response builds an *http.Response from a recording
+func (i *Interaction) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+		StatusCode:    i.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        i.Response.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(i.Response.Body)),
+		ContentLength: int64(len(i.Response.Body)),
+		Request:       req,
+	}
+}
+
+// Unused returns the recorded interactions that were never replayed
+// a strict test can check this is empty, to catch requests it expected but didn't make
+func (r *Recorder) Unused() []*Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []*Interaction
+	for _, i := range r.cassette.Interactions {
+		if !i.used {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// Stop saves the cassette when recording; it does nothing in replay mode
+func (r *Recorder) Stop() error {
+	if r.Mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cassette.Save(r.Path)
+}
+
+func main() {
+	// a stand-in for a real API, which checks for a token
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret-token" {
+			http.Error(w, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		fmt.Fprintf(w, "%s %s body=%q\n", req.Method, req.URL.Path, body)
+	}))
+
+	dir, err := os.MkdirTemp("", "cassettes")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "example.json")
+
+	do := func(client *http.Client, method, path, body string) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret-token")
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		fmt.Printf("%d %s", resp.StatusCode, b)
+	}
+
+	// record two interactions against the live server
+	rec, err := New(path, ModeRecord)
+	if err != nil {
+		panic(err)
+	}
+	client := &http.Client{Transport: rec}
+	do(client, http.MethodGet, "/items", "")
+	do(client, http.MethodPost, "/items", `{"name":"plum"}`)
+	if err := rec.Stop(); err != nil {
+		panic(err)
+	}
+
+	// the cassette holds both interactions, with the token redacted
+	data, _ := os.ReadFile(path)
+	fmt.Println(string(data))
+
+	// now take the server away, and replay strictly, matching on the body as well
+	srv.Close()
+
+	play, err := New(path, ModeReplay)
+	if err != nil {
+		panic(err)
+	}
+	play.Strict = true
+	play.Match = MatchAll(MatchMethod, MatchURL, MatchBody, play.MatchHeaders("Authorization"))
+	client = &http.Client{Transport: play}
+
+	do(client, http.MethodGet, "/items", "")
+	do(client, http.MethodPost, "/items", `{"name":"plum"}`)
+
+	// a request that was never recorded fails instead of reaching the network
+	do(client, http.MethodPost, "/items", `{"name":"pear"}`)
+
+	fmt.Println("unused interactions:", len(play.Unused()))
+}
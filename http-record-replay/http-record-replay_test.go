@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// This is also how the recorder is meant to be used: a test replays a cassette, so it runs offline
+
+func TestRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Set-Cookie", "session=abc")
+		body, _ := io.ReadAll(req.Body)
+		w.Write(append([]byte(req.Method+" "), body...))
+	}))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	rec, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(t, rec, srv.URL, "PUT", "one")
+	send(t, rec, srv.URL, "PUT", "two")
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "session=abc") {
+		t.Errorf("cassette contains unredacted credentials:\n%s", data)
+	}
+
+	var tests = []struct {
+		name   string
+		match  func(r *Recorder) Matcher
+		bodies []string
+		want   []string
+	}{
+		// matching on method and URL only, identical requests get the recordings in order
+		{"in order", func(r *Recorder) Matcher { return r.Match }, []string{"x", "y", "z"}, []string{"PUT one", "PUT two", "PUT two"}},
+		// matching on the body as well picks the right recording regardless of order
+		{"by body", func(r *Recorder) Matcher { return MatchAll(r.Match, MatchBody) }, []string{"two", "one"}, []string{"PUT two", "PUT one"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			play, err := New(path, ModeReplay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			play.Strict = true
+			play.Match = tt.match(play)
+
+			for i, body := range tt.bodies {
+				if got := send(t, play, srv.URL, "PUT", body); got != tt.want[i] {
+					t.Errorf("request %d: got %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		play, err := New(path, ModeReplay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		play.Strict = true
+
+		req, _ := http.NewRequest("DELETE", srv.URL, nil)
+		if _, err := play.RoundTrip(req); !errors.Is(err, ErrNoMatch) {
+			t.Errorf("got %v, want ErrNoMatch", err)
+		}
+		if n := len(play.Unused()); n != 2 {
+			t.Errorf("got %d unused interactions, want 2", n)
+		}
+	})
+}
+
+func send(t *testing.T, rt http.RoundTripper, url, method, body string) string {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,3 @@
+module example/http-retries
+
+go 1.18
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The http-clients example panics on any error, but networks are unreliable and servers are
+// sometimes briefly overloaded, so real clients usually try again
+
+// Retrying well takes some care:
+//   only requests that are safe to repeat (idempotent ones) should be retried
+//   waits between attempts should grow exponentially, with random jitter so that many clients
+//   don't all retry in lockstep
+//   a Retry-After header from the server should be respected
+//   a request body has to be rewound before it can be sent again
+//   a host that keeps failing shouldn't be hammered; a circuit breaker stops sending it requests
+//   for a while, then lets a single probe through to see if it has recovered
+
+// Here we'll build all of this as an http.RoundTripper, so it can be plugged into any http.Client
+
+// Attempt describes one try at sending a request, and is passed to the OnAttempt hook
+type Attempt struct {
+	Request  *http.Request
+	Number   int
+	Status   int
+	Err      error
+	Duration time.Duration
+
+	// Retrying is true if another attempt will be made after waiting Delay
+	Retrying bool
+	Delay    time.Duration
+}
+
+// ErrCircuitOpen is returned without contacting the server while a host's circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Transport retries requests and applies per-host circuit breaking
+type Transport struct {
+	// Base performs the actual requests; http.DefaultTransport is used if nil
+	Base http.RoundTripper
+
+	// MaxAttempts is the total number of tries, including the first
+	MaxAttempts int
+
+	// the wait before retry n is a random duration between 0 and min(MaxDelay, BaseDelay*2^n),
+	// which is the "full jitter" strategy
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// MaxRetryAfter caps how long we're prepared to wait when the server sends Retry-After
+	MaxRetryAfter time.Duration
+
+	// a host's breaker opens after FailureThreshold consecutive failures, and stays open for
+	// OpenTimeout before allowing a probe through
+	// a FailureThreshold of 0 means the default of 5, so a zero Transport doesn't open a breaker
+	// on every failure
+	FailureThreshold int
+	OpenTimeout      time.Duration
+
+	// OnAttempt, if set, is called after every attempt
+	OnAttempt func(Attempt)
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	rand     *rand.Rand
+}
+
+// defaultFailureThreshold is used when FailureThreshold isn't set
+const defaultFailureThreshold = 5
+
+// NewTransport returns a Transport with sensible defaults wrapping base
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{
+		Base:             base,
+		MaxAttempts:      4,
+		BaseDelay:        100 * time.Millisecond,
+		MaxDelay:         5 * time.Second,
+		MaxRetryAfter:    30 * time.Second,
+		FailureThreshold: defaultFailureThreshold,
+		OpenTimeout:      10 * time.Second,
+	}
+}
+
+// The states of a circuit breaker
+const (
+	closed = iota
+	open
+	halfOpen
+)
+
+// breaker tracks the health of a single host
+type breaker struct {
+	state    int
+	failures int
+	openedAt time.Time
+
+	// probing is true while the single half-open probe request is in flight
+	probing bool
+}
+
+// allow reports whether a request to the host may be sent now
+// it must be called with t.mu held
+func (t *Transport) allow(b *breaker) bool {
+	switch b.state {
+	case open:
+		if time.Since(b.openedAt) < t.OpenTimeout {
+			return false
+		}
+		b.state = halfOpen
+		fallthrough
+	case halfOpen:
+		// only one probe at a time; everyone else fails fast until it reports back
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record updates a host's breaker with the outcome of a request
+func (t *Transport) record(host string, success bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.breakerFor(host)
+	b.probing = false
+
+	if success {
+		b.state = closed
+		b.failures = 0
+		return
+	}
+
+	threshold := t.FailureThreshold
+	if threshold < 1 {
+		threshold = defaultFailureThreshold
+	}
+	b.failures++
+	if b.state == halfOpen || b.failures >= threshold {
+		b.state = open
+		b.openedAt = time.Now()
+	}
+}
+
+// release lets another half-open probe through without recording an outcome, for requests that
+// ended for reasons unrelated to the host
+func (t *Transport) release(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.breakerFor(host).probing = false
+}
+
+// breakerFor returns the breaker for a host, creating it if necessary
+// it must be called with t.mu held
+func (t *Transport) breakerFor(host string) *breaker {
+	if t.breakers == nil {
+		t.breakers = make(map[string]*breaker)
+	}
+	b, ok := t.breakers[host]
+	if !ok {
+		b = &breaker{}
+		t.breakers[host] = b
+	}
+	return b
+}
+
+// idempotent reports whether a request can safely be sent more than once
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// retryable reports whether a response status indicates a transient problem
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// failure reports whether a response status should count against the host's circuit breaker
+// 429 is excluded: the server is healthy, it's just asking this client to slow down
+func failure(status int) bool {
+	return status >= 500
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(h string, now time.Time) (time.Duration, bool) {
+	h = strings.TrimSpace(h)
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// backoff returns a random delay for the given retry number (starting at 0)
+func (t *Transport) backoff(retry int) time.Duration {
+	ceiling := t.MaxDelay
+	if retry < 32 {
+		if d := t.BaseDelay << uint(retry); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rand == nil {
+		t.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return time.Duration(t.rand.Int63n(int64(ceiling) + 1))
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// a body can only be resent if we have a way to get a fresh copy of it
+	// http.NewRequest sets GetBody automatically for bytes, strings and bytes.Buffer readers
+	attempts := t.MaxAttempts
+	if attempts < 1 || !idempotent(req) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		attempts = 1
+	}
+
+	host := req.URL.Host
+
+	for n := 1; ; n++ {
+		t.mu.Lock()
+		allowed := t.allow(t.breakerFor(host))
+		t.mu.Unlock()
+		if !allowed {
+			t.report(Attempt{Request: req, Number: n, Err: ErrCircuitOpen})
+			return nil, fmt.Errorf("%s: %w", host, ErrCircuitOpen)
+		}
+
+		// every attempt after the first gets its own copy of the request with a fresh body, since
+		// a RoundTripper must not modify the request it was given
+		r := req
+		if n > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		start := time.Now()
+		resp, err := base.RoundTrip(r)
+		a := Attempt{Request: req, Number: n, Err: err, Duration: time.Since(start)}
+
+		// a cancelled request says nothing about the host's health, and shouldn't be retried
+		if err != nil && req.Context().Err() != nil {
+			t.release(host)
+			t.report(a)
+			return nil, err
+		}
+
+		if resp != nil {
+			a.Status = resp.StatusCode
+		}
+		t.record(host, err == nil && !failure(a.Status))
+
+		if n >= attempts || (err == nil && !retryable(resp.StatusCode)) {
+			t.report(a)
+			return resp, err
+		}
+
+		// work out how long to wait, preferring the server's Retry-After if it sent one
+		a.Retrying = true
+		a.Delay = t.backoff(n - 1)
+		if resp != nil {
+			if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if d > t.MaxRetryAfter {
+					// the server wants us to wait longer than we're prepared to, so give up and
+					// hand its response back to the caller
+					a.Retrying = false
+					a.Delay = 0
+					t.report(a)
+					return resp, nil
+				}
+				a.Delay = d
+			}
+
+			// drain the body so the connection can be reused for the next attempt
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		t.report(a)
+
+		timer := time.NewTimer(a.Delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *Transport) report(a Attempt) {
+	if t.OnAttempt != nil {
+		t.OnAttempt(a)
+	}
+}
+
+// flakyServer fails the first few requests in different ways, so we can watch the retries
+func flakyServer() *httptest.Server {
+	var mu sync.Mutex
+	calls := 0
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+
+		body, _ := io.ReadAll(req.Body)
+		switch n {
+		case 1:
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			fmt.Fprintf(w, "hello after %d calls, you sent %q\n", n, body)
+		}
+	}))
+}
+
+func main() {
+	t := NewTransport(nil)
+
+	// the hook is where logging and metrics would go
+	var attempts int
+	t.OnAttempt = func(a Attempt) {
+		attempts++
+		fmt.Printf("attempt %d: %s %s status=%d err=%v took=%v", a.Number, a.Request.Method,
+			a.Request.URL, a.Status, a.Err, a.Duration.Round(time.Millisecond))
+		if a.Retrying {
+			fmt.Printf(" retrying in %v", a.Delay.Round(time.Millisecond))
+		}
+		fmt.Println()
+	}
+
+	// the retrying transport is used by an ordinary http.Client
+	client := &http.Client{Transport: t, Timeout: 30 * time.Second}
+
+	srv := flakyServer()
+	defer srv.Close()
+
+	// a PUT is idempotent, and its strings.Reader body can be rewound, so it's retried until the
+	// server stops failing
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("payload"))
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Println("error:", err)
+	} else {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		fmt.Print("response: ", string(body))
+	}
+
+	// a host that refuses connections trips its breaker after FailureThreshold failures, after
+	// which requests fail fast without touching the network
+	t.FailureThreshold = 3
+	t.MaxAttempts = 2
+	t.BaseDelay = 10 * time.Millisecond
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := client.Get(dead.URL); err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+
+	fmt.Println("total attempts:", attempts)
+
+	// Running the program shows the 503 and 429 being retried (the second after the 1s asked for
+	// by Retry-After), and the breaker for the dead server opening after its third failure
+}
@@ -0,0 +1,3 @@
+module example/json-patch
+
+go 1.18
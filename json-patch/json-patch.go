@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// In the json example, reading or changing a value nested inside a decoded
+// map[string]interface{} takes a chain of type assertions, such as dat["strs"].([]interface{})
+
+// The JSON standards family has better tools for this:
+//   JSON Pointer (RFC 6901) addresses a value in a document with a path such as /strs/0
+//   JSON Patch (RFC 6902) describes a list of edits (add, remove, replace, move, copy and test)
+//   JSON Merge Patch (RFC 7396) describes edits as a partial document to merge in
+// Here we'll implement all three on decoded values, and generate patches by diffing documents
+
+// pointer.go has JSON Pointer, and patch.go has JSON Patch and diffing
+
+// MergePatch applies an RFC 7396 merge patch to target and returns the result
+// members of a patch object replace the target's members, recursively for nested objects, and a
+// null member deletes the target's member; any patch that isn't an object replaces the target
+// the target may be modified in place
+func MergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = MergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// CreateMergePatch generates a merge patch that turns a into b
+// merge patches can't express everything: arrays are always replaced whole, and a null value in b
+// can't be set, since null in a merge patch means delete
+func CreateMergePatch(a, b interface{}) interface{} {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if !aok || !bok {
+		return b
+	}
+
+	patch := make(map[string]interface{})
+	for k := range am {
+		if _, ok := bm[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	for k, bv := range bm {
+		av, ok := am[k]
+		if !ok {
+			patch[k] = bv
+		} else if !Equal(av, bv) {
+			patch[k] = CreateMergePatch(av, bv)
+		}
+	}
+	return patch
+}
+
+// decode is a helper for the examples below
+func decode(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func show(label string, v interface{}) {
+	b, _ := json.Marshal(v)
+	fmt.Printf("%-14s %s\n", label, b)
+}
+
+func main() {
+	// the document from the json example
+	dat := decode(`{"num":6.13,"strs":["a","b"]}`)
+
+	// with a pointer, nested values need no type assertions
+	str1, err := Get(dat, MustParsePointer("/strs/0"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("/strs/0 is", str1)
+
+	// setting and removing return the new document, since the root itself can change
+	dat, _ = Set(dat, MustParsePointer("/strs/-"), "c")
+	dat, _ = Set(dat, MustParsePointer("/a~1b"), true)
+	show("after set:", dat)
+	dat, removed, _ := Remove(dat, MustParsePointer("/num"))
+	show("after remove:", dat)
+	fmt.Println("removed", removed)
+
+	// pointers report precisely where they failed
+	if _, err := Get(dat, MustParsePointer("/strs/7")); err != nil {
+		fmt.Println("error:", err)
+	}
+
+	// a JSON Patch is itself a JSON document
+	patch, err := DecodePatch([]byte(`[
+		{"op": "test", "path": "/strs/0", "value": "a"},
+		{"op": "add", "path": "/strs/1", "value": "inserted"},
+		{"op": "replace", "path": "/a~1b", "value": false},
+		{"op": "copy", "from": "/strs", "path": "/backup"},
+		{"op": "move", "from": "/backup", "path": "/old"}
+	]`))
+	if err != nil {
+		panic(err)
+	}
+	patched, err := patch.Apply(dat)
+	if err != nil {
+		panic(err)
+	}
+	show("patched:", patched)
+
+	// patches are atomic: this one fails at its test operation, after an add has already run,
+	// and the document comes back unchanged
+	bad, _ := DecodePatch([]byte(`[
+		{"op": "add", "path": "/extra", "value": 1},
+		{"op": "test", "path": "/strs/0", "value": "z"}
+	]`))
+	unchanged, err := bad.Apply(patched)
+	fmt.Println("error:", err)
+	show("unchanged:", unchanged)
+
+	// a merge patch is easier to write for simple edits; null deletes a member
+	merged := MergePatch(deepCopy(patched), decode(`{"a/b": null, "old": ["x"], "nested": {"k": 1}}`))
+	show("merged:", merged)
+
+	// both kinds of patch can be generated by comparing two documents, and applying the result
+	// to the first gives the second
+	diff := Diff(patched, merged)
+	b, _ := json.MarshalIndent(diff, "", "  ")
+	fmt.Println("diff:", string(b))
+
+	roundTrip, err := diff.Apply(patched)
+	fmt.Println("diff applies cleanly:", err == nil && Equal(roundTrip, merged))
+
+	show("merge patch:", CreateMergePatch(patched, merged))
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// This file implements RFC 6902 JSON Patch: applying a list of operations to a document, and
+// generating such a list by comparing two documents
+
+// Operation is a single JSON Patch operation
+// Value is kept as raw JSON so that a missing value can be told apart from an explicit null
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch is a list of operations, applied in order
+type Patch []Operation
+
+// DecodePatch parses a JSON Patch document
+func DecodePatch(data []byte) (Patch, error) {
+	var p Patch
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PatchError reports which operation of a patch failed
+type PatchError struct {
+	Index int
+	Op    Operation
+	Err   error
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %v", e.Index, e.Op.Op, e.Op.Path, e.Err)
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// Apply applies the patch to doc and returns the patched document
+// a patch is atomic: the operations are applied to a copy, so if any of them fails (including a
+// failed test) the error is returned and doc is left exactly as it was
+func (p Patch) Apply(doc interface{}) (interface{}, error) {
+	out := deepCopy(doc)
+
+	for i, op := range p {
+		var err error
+		out, err = op.apply(out)
+		if err != nil {
+			return doc, &PatchError{i, op, err}
+		}
+	}
+	return out, nil
+}
+
+func (op Operation) value() (interface{}, error) {
+	if len(op.Value) == 0 {
+		return nil, fmt.Errorf("missing value")
+	}
+	var v interface{}
+	err := json.Unmarshal(op.Value, &v)
+	return v, err
+}
+
+func (op Operation) apply(doc interface{}) (interface{}, error) {
+	path, err := ParsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return insert(doc, path, v)
+
+	case "remove":
+		doc, _, err := Remove(doc, path)
+		return doc, err
+
+	case "replace":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		// unlike add, the target must already exist
+		if _, err := Get(doc, path); err != nil {
+			return nil, err
+		}
+		return Set(doc, path, v)
+
+	case "move", "copy":
+		from, err := ParsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := Get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "move" {
+			if op.From == op.Path {
+				return doc, nil
+			}
+			// a value can't be moved into one of its own children
+			if len(path) > len(from) && path[:len(from)].String() == from.String() {
+				return nil, fmt.Errorf("cannot move %s into itself", op.From)
+			}
+			if doc, _, err = Remove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			// the copy mustn't share maps or slices with the original
+			v = deepCopy(v)
+		}
+		return insert(doc, path, v)
+
+	case "test":
+		want, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := Get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !Equal(got, want) {
+			return nil, fmt.Errorf("test failed: value is %s", mustMarshal(got))
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// deepCopy copies a decoded JSON value, so edits to the copy can't affect the original
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = deepCopy(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = deepCopy(e)
+		}
+		return out
+	}
+	return v
+}
+
+// Equal compares decoded JSON values as the test operation requires: numbers by value, objects
+// regardless of member order, and arrays element by element
+func Equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, av := range a {
+			bv, ok := b[k]
+			if !ok || !Equal(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !Equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		af, _ := a.Float64()
+		switch b := b.(type) {
+		case json.Number:
+			bf, _ := b.Float64()
+			return af == bf
+		case float64:
+			return af == b
+		}
+		return false
+	case float64:
+		if bn, ok := b.(json.Number); ok {
+			return Equal(bn, a)
+		}
+	}
+	return a == b
+}
+
+// Diff generates a patch that turns a into b
+// objects are compared member by member; arrays are compared index by index, with elements
+// removed from or appended to the end when the lengths differ
+func Diff(a, b interface{}) Patch {
+	var p Patch
+	diff(Pointer{}, a, b, &p)
+	return p
+}
+
+func diff(path Pointer, a, b interface{}, p *Patch) {
+	if Equal(a, b) {
+		return
+	}
+
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		// visit members in sorted order so the patch is deterministic
+		for _, k := range sortedKeys(a) {
+			if _, ok := b[k]; !ok {
+				*p = append(*p, Operation{Op: "remove", Path: path.Append(k).String()})
+			}
+		}
+		for _, k := range sortedKeys(b) {
+			if av, ok := a[k]; ok {
+				diff(path.Append(k), av, b[k], p)
+			} else {
+				*p = append(*p, Operation{Op: "add", Path: path.Append(k).String(), Value: mustMarshal(b[k])})
+			}
+		}
+		return
+
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+
+		n := len(a)
+		if len(b) < n {
+			n = len(b)
+		}
+		for i := 0; i < n; i++ {
+			diff(path.Append(strconv.Itoa(i)), a[i], b[i], p)
+		}
+		// remove from the end backwards, so earlier removals don't shift later indexes
+		for i := len(a) - 1; i >= len(b); i-- {
+			*p = append(*p, Operation{Op: "remove", Path: path.Append(strconv.Itoa(i)).String()})
+		}
+		for i := len(a); i < len(b); i++ {
+			*p = append(*p, Operation{Op: "add", Path: path.Append("-").String(), Value: mustMarshal(b[i])})
+		}
+		return
+	}
+
+	*p = append(*p, Operation{Op: "replace", Path: path.String(), Value: mustMarshal(b)})
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mustMarshal encodes a decoded JSON value, which can't fail
+func mustMarshal(v interface{}) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
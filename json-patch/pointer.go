@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file implements RFC 6901 JSON Pointer over values decoded by encoding/json, i.e. trees of
+// map[string]interface{}, []interface{}, float64, string, bool and nil
+
+// Pointer is a parsed JSON Pointer: the reference tokens between the slashes, already unescaped
+// the empty Pointer refers to the whole document
+type Pointer []string
+
+// ParsePointer parses a pointer such as /strs/0
+// in a reference token, ~1 stands for / and ~0 for ~
+func ParsePointer(s string) (Pointer, error) {
+	if s == "" {
+		return Pointer{}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("pointer %q must be empty or start with '/'", s)
+	}
+
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		// the order matters: ~01 must become ~1, not /
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// MustParsePointer is like ParsePointer but panics on error, for pointers written in code
+func MustParsePointer(s string) Pointer {
+	p, err := ParsePointer(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p Pointer) String() string {
+	var b strings.Builder
+	for _, t := range p {
+		b.WriteByte('/')
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(t, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
+// Append returns a new pointer with an extra token, without sharing p's backing array
+func (p Pointer) Append(token string) Pointer {
+	out := make(Pointer, len(p), len(p)+1)
+	copy(out, p)
+	return append(out, token)
+}
+
+// arrayIndex parses an array reference token
+// the RFC only allows plain decimal numbers without leading zeros
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	i, err := strconv.Atoi(token)
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if err != nil || i > max {
+		return 0, fmt.Errorf("array index %s out of range", token)
+	}
+	return i, nil
+}
+
+// Get returns the value a pointer refers to
+func Get(doc interface{}, p Pointer) (interface{}, error) {
+	node := doc
+	for i, token := range p {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			v, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("%s: no such member", p[:i+1])
+			}
+			node = v
+		case []interface{}:
+			idx, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p[:i+1], err)
+			}
+			node = n[idx]
+		default:
+			return nil, fmt.Errorf("%s: cannot descend into %T", p[:i+1], node)
+		}
+	}
+	return node, nil
+}
+
+// editParent finds the container holding the pointer's target and lets edit replace it
+// containers are rebuilt on the way back up, since inserting into or removing from a slice can
+// produce a new slice that has to be stored in its parent
+func editParent(doc interface{}, p Pointer, edit func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(p) == 0 {
+		return nil, fmt.Errorf("the root has no parent")
+	}
+
+	var walk func(node interface{}, depth int) (interface{}, error)
+	walk = func(node interface{}, depth int) (interface{}, error) {
+		if depth == len(p)-1 {
+			return edit(node, p[depth])
+		}
+
+		token := p[depth]
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("%s: no such member", p[:depth+1])
+			}
+			newChild, err := walk(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n[token] = newChild
+			return n, nil
+		case []interface{}:
+			idx, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p[:depth+1], err)
+			}
+			newChild, err := walk(n[idx], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n[idx] = newChild
+			return n, nil
+		}
+		return nil, fmt.Errorf("%s: cannot descend into %T", p[:depth+1], node)
+	}
+
+	return walk(doc, 0)
+}
+
+// Set stores value at the pointer, creating an object member or replacing an array element
+// the token - appends to an array, and an empty pointer replaces the whole document
+// like the other editing functions, it may modify doc in place, and returns the new document
+func Set(doc interface{}, p Pointer, value interface{}) (interface{}, error) {
+	if len(p) == 0 {
+		return value, nil
+	}
+	return editParent(doc, p, func(parent interface{}, token string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			n[token] = value
+			return n, nil
+		case []interface{}:
+			idx, err := arrayIndex(token, len(n), true)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+			if idx == len(n) {
+				return append(n, value), nil
+			}
+			n[idx] = value
+			return n, nil
+		}
+		return nil, fmt.Errorf("%s: cannot set a member of %T", p, parent)
+	})
+}
+
+// insert is the JSON Patch "add" operation: unlike Set, adding to an array shifts the elements
+// at and after the index up by one
+func insert(doc interface{}, p Pointer, value interface{}) (interface{}, error) {
+	if len(p) == 0 {
+		return value, nil
+	}
+	return editParent(doc, p, func(parent interface{}, token string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			n[token] = value
+			return n, nil
+		case []interface{}:
+			idx, err := arrayIndex(token, len(n), true)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+			n = append(n, nil)
+			copy(n[idx+1:], n[idx:])
+			n[idx] = value
+			return n, nil
+		}
+		return nil, fmt.Errorf("%s: cannot add a member to %T", p, parent)
+	})
+}
+
+// Remove deletes the value at the pointer, returning the new document and the removed value
+func Remove(doc interface{}, p Pointer) (interface{}, interface{}, error) {
+	if len(p) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	var removed interface{}
+	doc, err := editParent(doc, p, func(parent interface{}, token string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			v, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("%s: no such member", p)
+			}
+			removed = v
+			delete(n, token)
+			return n, nil
+		case []interface{}:
+			idx, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+			removed = n[idx]
+			return append(n[:idx], n[idx+1:]...), nil
+		}
+		return nil, fmt.Errorf("%s: cannot remove a member of %T", p, parent)
+	})
+	return doc, removed, err
+}
@@ -0,0 +1,3 @@
+module example/json-query
+
+go 1.18
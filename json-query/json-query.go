@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// The json example decodes small JSON literals into map[string]interface{} and digs into them with
+// type assertions such as dat["strs"].([]interface{})
+
+// That needs the whole document in memory, which doesn't work for multi-gigabyte files
+// json.Decoder has a lower-level Token method that returns one token at a time (a delimiter, key
+// or scalar), so we can walk a document of any size and only decode the parts we're interested in
+
+// Here we'll use it to build a small jq-like command line tool
+// Given a query such as .items[] | select(.price > 10) | .name it streams through the input,
+// skipping everything the query doesn't touch, and only materialises each selected element (here
+// each item) to evaluate the rest of the query
+// Inputs with several top-level values, such as NDJSON, are queried one value at a time
+
+// evaluator runs a parsed query against an input and writes the results
+type evaluator struct {
+	dec  *json.Decoder
+	emit func(v interface{}) error
+}
+
+// stream evaluates steps against the next value in the decoder
+// it follows field, index and iterate steps token by token, so the only values decoded in full
+// are the ones the remaining steps need in memory
+func (e *evaluator) stream(steps []step) error {
+	if len(steps) == 0 || steps[0].kind == stepSelect || (steps[0].kind == stepIndex && steps[0].index < 0) {
+		var v interface{}
+		if err := e.dec.Decode(&v); err != nil {
+			return err
+		}
+		return evaluate(v, steps, e.emit)
+	}
+
+	tok, err := e.dec.Token()
+	if err != nil {
+		return err
+	}
+	s, rest := steps[0], steps[1:]
+
+	// indexing into null gives null, as in jq
+	if tok == nil && s.kind != stepIterate {
+		return evaluate(nil, rest, e.emit)
+	}
+
+	switch s.kind {
+	case stepField:
+		if tok != json.Delim('{') {
+			return fmt.Errorf("cannot index %s with %q", describe(tok), s.field)
+		}
+		found := false
+		for e.dec.More() {
+			key, err := e.dec.Token()
+			if err != nil {
+				return err
+			}
+			if key == s.field && !found {
+				found = true
+				if err := e.stream(rest); err != nil {
+					return err
+				}
+			} else if err := e.skip(); err != nil {
+				return err
+			}
+		}
+		if _, err := e.dec.Token(); err != nil {
+			return err
+		}
+		if !found {
+			return evaluate(nil, rest, e.emit)
+		}
+		return nil
+
+	case stepIndex:
+		if tok != json.Delim('[') {
+			return fmt.Errorf("cannot index %s with a number", describe(tok))
+		}
+		found := false
+		for i := 0; e.dec.More(); i++ {
+			if i == s.index {
+				found = true
+				if err := e.stream(rest); err != nil {
+					return err
+				}
+			} else if err := e.skip(); err != nil {
+				return err
+			}
+		}
+		if _, err := e.dec.Token(); err != nil {
+			return err
+		}
+		if !found {
+			return evaluate(nil, rest, e.emit)
+		}
+		return nil
+
+	default: // stepIterate
+		switch tok {
+		case json.Delim('['):
+			for e.dec.More() {
+				if err := e.stream(rest); err != nil {
+					return err
+				}
+			}
+		case json.Delim('{'):
+			for e.dec.More() {
+				if _, err := e.dec.Token(); err != nil {
+					return err
+				}
+				if err := e.stream(rest); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("cannot iterate over %s", describe(tok))
+		}
+		_, err := e.dec.Token()
+		return err
+	}
+}
+
+// skip reads past the next value without keeping any of it
+func (e *evaluator) skip() error {
+	depth := 0
+	for {
+		tok, err := e.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// describe names the JSON type of a token or decoded value, for error messages
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case json.Delim:
+		if v == '[' {
+			return "array"
+		}
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// evaluate runs steps against a value that's already in memory
+func evaluate(v interface{}, steps []step, emit func(interface{}) error) error {
+	if len(steps) == 0 {
+		return emit(v)
+	}
+	s, rest := steps[0], steps[1:]
+
+	switch s.kind {
+	case stepField:
+		switch obj := v.(type) {
+		case nil:
+			return evaluate(nil, rest, emit)
+		case map[string]interface{}:
+			return evaluate(obj[s.field], rest, emit)
+		}
+		return fmt.Errorf("cannot index %s with %q", describe(v), s.field)
+
+	case stepIndex:
+		switch arr := v.(type) {
+		case nil:
+			return evaluate(nil, rest, emit)
+		case []interface{}:
+			i := s.index
+			if i < 0 {
+				i += len(arr)
+			}
+			if i < 0 || i >= len(arr) {
+				return evaluate(nil, rest, emit)
+			}
+			return evaluate(arr[i], rest, emit)
+		}
+		return fmt.Errorf("cannot index %s with a number", describe(v))
+
+	case stepIterate:
+		switch c := v.(type) {
+		case []interface{}:
+			for _, el := range c {
+				if err := evaluate(el, rest, emit); err != nil {
+					return err
+				}
+			}
+			return nil
+		case map[string]interface{}:
+			// decoded maps have lost their key order, so iterate in sorted order for stable output
+			keys := make([]string, 0, len(c))
+			for k := range c {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if err := evaluate(c[k], rest, emit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return fmt.Errorf("cannot iterate over %s", describe(v))
+
+	default: // stepSelect
+		// like jq, select emits its input once for every true output of the condition
+		return evaluate(v, s.cond.path, func(lhs interface{}) error {
+			if !s.cond.test(lhs) {
+				return nil
+			}
+			return evaluate(v, rest, emit)
+		})
+	}
+}
+
+// test applies the condition's comparison to a value
+func (c *condition) test(v interface{}) bool {
+	if c.op == "" {
+		return v != nil && v != false
+	}
+
+	cmp := compare(v, c.literal)
+	switch c.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// typeOrder ranks JSON types the way jq sorts them: null < false < true < numbers < strings <
+// arrays < objects
+func typeOrder(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if v {
+			return 2
+		}
+		return 1
+	case json.Number, float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// compare orders two JSON values, returning -1, 0 or 1
+// values of different types are ordered by type; arrays and objects are compared by their
+// encoding, which is enough to tell equal values apart
+func compare(a, b interface{}) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		return sign(ta - tb)
+	}
+
+	switch a := a.(type) {
+	case json.Number:
+		fa, _ := a.Float64()
+		fb, _ := b.(json.Number).Float64()
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case string:
+		bs := b.(string)
+		switch {
+		case a < bs:
+			return -1
+		case a > bs:
+			return 1
+		}
+		return 0
+	case nil, bool:
+		return 0
+	}
+
+	ea, _ := json.Marshal(a)
+	eb, _ := json.Marshal(b)
+	return compare(string(ea), string(eb))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// query runs steps against each top-level value in r, which handles NDJSON as well as single
+// documents
+func query(r io.Reader, steps []step, emit func(interface{}) error) error {
+	dec := json.NewDecoder(bufio.NewReaderSize(r, 64<<10))
+
+	// UseNumber keeps numbers as their original text, so large integers and exact decimals come
+	// out exactly as they went in
+	dec.UseNumber()
+	e := &evaluator{dec: dec, emit: emit}
+
+	for dec.More() {
+		if err := e.stream(steps); err != nil {
+			return err
+		}
+	}
+
+	// More returns false at the end of the input, but also on a stray closing delimiter
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected trailing input")
+	}
+	return nil
+}
+
+func main() {
+	compact := flag.Bool("c", false, "compact output, one result per line")
+	raw := flag.Bool("r", false, "print string results without quotes")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: json-query [-c] [-r] query [file...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	steps, err := Parse(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	// results are written with an Encoder rather than Marshal so HTML characters aren't escaped
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	if !*compact {
+		enc.SetIndent("", "  ")
+	}
+	emit := func(v interface{}) error {
+		if s, ok := v.(string); ok && *raw {
+			_, err := fmt.Fprintln(out, s)
+			return err
+		}
+		return enc.Encode(v)
+	}
+
+	// query stdin if no files are given
+	var inputs []io.Reader
+	for _, name := range flag.Args()[1:] {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		inputs = append(inputs, f)
+	}
+	if len(inputs) == 0 {
+		inputs = append(inputs, os.Stdin)
+	}
+
+	for _, in := range inputs {
+		if err := query(in, steps, emit); err != nil {
+			out.Flush()
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(5)
+		}
+	}
+
+	// Select the names of the expensive items
+	// >> echo '{"items":[{"name":"apple","price":5},{"name":"melon","price":12}]}' | go run . '.items[] | select(.price > 10) | .name'
+	// "melon"
+
+	// Query NDJSON, one compact result per line
+	// >> printf '{"a":1}\n{"a":2}\n' | go run . -c 'select(.a >= 2)'
+	// {"a":2}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// steps formats parsed steps as their String methods do, for comparing in tests
+func steps(s []step) string {
+	var parts []string
+	for _, st := range s {
+		parts = append(parts, st.String())
+	}
+	return strings.Join(parts, "")
+}
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		query string
+		want  string
+	}{
+		{".", ""},
+		{" . ", ""},
+		{".a", `."a"`},
+		{".a.b", `."a"."b"`},
+		{`."a b".c`, `."a b"."c"`},
+		{`.["a b"]`, `."a b"`},
+		{".a[0]", `."a".[0]`},
+		{".a.[0]", `."a".[0]`},
+		{".[-1]", ".[-1]"},
+		{".[]", ".[]"},
+		{".items[].name", `."items".[]."name"`},
+		{". | .a", `."a"`},
+		{".a | .b", `."a"."b"`},
+		{".items[] | select(.price > 10) | .name", `."items".[]select(."price" > 10)."name"`},
+		{`select(.tags[0] == "x")`, `select(."tags".[0] == "x")`},
+		{"select(.ok)", `select(."ok")`},
+		{"select(. != null)", "select(. != null)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			s, err := Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := steps(s); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []struct {
+		query string
+		err   string
+	}{
+		{"..", "column 2: '..' (recursive descent) isn't supported"},
+		{"..a", "column 2: '..' (recursive descent) isn't supported"},
+		{".a..b", "column 4: '..' (recursive descent) isn't supported"},
+		// a step must follow on from the one before it, without spaces
+		{".a .b", `column 4: unexpected ".b"`},
+		{". .a", `column 3: unexpected ".a"`},
+		{".a [0]", `column 4: unexpected "[0]"`},
+		{".a.", "column 4: expected a field name after '.'"},
+		{"", "column 1: expected a path starting with '.'"},
+		{"a", "column 1: expected a path starting with '.'"},
+		{".[x]", "column 3: expected an index, a quoted key or ']'"},
+		{".[0", `column 4: expected "]"`},
+		{".a |", "column 5: expected a path starting with '.'"},
+		{"select(.a", `column 10: expected ")"`},
+		{"select(.a > )", "column 13: expected a literal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// runQuery queries input and returns the results as compact JSON, separated by spaces
+func runQuery(input, q string) (string, error) {
+	steps, err := Parse(q)
+	if err != nil {
+		return "", err
+	}
+	var results []string
+	err = query(strings.NewReader(input), steps, func(v interface{}) error {
+		b, err := json.Marshal(v)
+		results = append(results, string(b))
+		return err
+	})
+	return strings.Join(results, " "), err
+}
+
+func TestQuery(t *testing.T) {
+	var tests = []struct {
+		name, input, query, want string
+	}{
+		{"identity", `{"a": [1, 2]}`, ".", `{"a":[1,2]}`},
+		{"field", `{"a":1,"b":2}`, ".b", "2"},
+		{"nested field", `{"a":{"b":[1,2]},"c":3}`, ".a.b", "[1,2]"},
+		{"skips nested values", `{"x":{"y":[1,{"z":[]}]},"a":3}`, ".a", "3"},
+		{"first of duplicate keys", `{"a":1,"a":2}`, ".a", "1"},
+		{"missing field", `{"a":1}`, ".b", "null"},
+		{"index", `[10,20,30]`, ".[1]", "20"},
+		{"index past the end", `[10,20,30]`, ".[3]", "null"},
+		{"negative index", `[10,20,30]`, ".[-1]", "30"},
+		{"negative index past the start", `[10,20,30]`, ".[-4]", "null"},
+		{"negative index after a field", `{"a":[1,2,3]}`, ".a[-2]", "2"},
+		{"iterate array", `[1,[2],{"x":3}]`, ".[]", `1 [2] {"x":3}`},
+		// streamed objects are iterated in document order
+		{"iterate object", `{"b":1,"a":2}`, ".[]", "1 2"},
+		// decoded ones have lost their order, so are iterated by key
+		{"iterate decoded object", `{"b":1,"a":2}`, "select(.b) | .[]", "2 1"},
+		{"iterate then field", `{"a":{"n":1},"b":{},"c":null}`, ".[] | .n", "1 null null"},
+		{"iterate empty", `[]`, ".[]", ""},
+		{"null", `null`, ".a.b[0]", "null"},
+		{"null field", `{"a":null}`, ".a[-1].b", "null"},
+		{"select", `[{"ok":true},{"ok":false},{"ok":null},{},{"ok":0}]`, ".[] | select(.ok)", `{"ok":true} {"ok":0}`},
+		{"select number", `[{"p":5},{"p":12.5},{"p":1e1}]`, ".[] | select(.p >= 10) | .p", "12.5 1e1"},
+		{"select string", `["b","a","c"]`, `.[] | select(. < "b")`, `"a"`},
+		// values of different types compare by type: null < false < true < numbers < strings <
+		// arrays < objects
+		{"select mixed greater", `[1,"1",null,true,false,[1],{"a":1},2]`, ".[] | select(. > 1)", `"1" [1] {"a":1} 2`},
+		{"select mixed less", `[1,"1",null,true,false,[1],{"a":1}]`, `.[] | select(. < "1")`, "1 null true false"},
+		{"select mixed equal", `[1,"1",true,null]`, `.[] | select(. == "1")`, `"1"`},
+		{"select not null", `[1,null,false]`, ".[] | select(. != null)", "1 false"},
+		// select emits its input once for each true result of the condition
+		{"select with iteration", `{"t":["a","b","a"]}`, `select(.t[] == "a") | .t[1]`, `"b" "b"`},
+		{"ndjson", "{\"a\":1}\n{\"a\":2}\n{}\n", ".a", "1 2 null"},
+		{"several values", ` 1 "two" [3] `, ".", `1 "two" [3]`},
+		{"empty input", "", ".a", ""},
+		{"exact numbers", `[12345678901234567890, 1.10]`, ".[]", "12345678901234567890 1.10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runQuery(tt.input, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	var tests = []struct {
+		name, input, query, err string
+	}{
+		{"field of a string", `"s"`, ".a", `cannot index string with "a"`},
+		{"field of an array", `[1]`, ".a", `cannot index array with "a"`},
+		{"index of an object", `{"a":1}`, ".[0]", "cannot index object with a number"},
+		{"iterate a number", `5`, ".[]", "cannot iterate over number"},
+		{"iterate null", `{"a":null}`, ".a[]", "cannot iterate over null"},
+		{"decoded field of a string", `{"a":"x"}`, "select(.a) | .a.b", `cannot index string with "b"`},
+		{"decoded index of a number", `[1,2]`, ".[-1].x", `cannot index number with "x"`},
+		{"decoded iterate a boolean", `[true]`, "select(.) | .[0][]", "cannot iterate over boolean"},
+		{"error after results", `[{"a":1},2]`, ".[] | .a", `cannot index number with "a"`},
+		{"truncated", `{"a":[1,`, ".a[1]", "unexpected end of JSON input"},
+		{"invalid", `{"a" 1}`, ".a", "invalid character"},
+		{"trailing delimiter", `[1] ]`, ".", "unexpected trailing input"},
+		{"bad second value", "{}\n{", ".", "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runQuery(tt.input, tt.query)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
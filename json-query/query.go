@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file parses the jq-like query language into a list of steps
+// The supported subset is:
+//   .            the input itself
+//   .a  .a.b     object fields (also ."a b" and .["a b"] for keys that aren't identifiers)
+//   .[]          every element of an array, or every value of an object
+//   .[n]         the nth element of an array; negative n counts from the end
+//   select(f)    keeps the input if f is true, where f is a path, or a path compared with a
+//                literal using ==, !=, <, <=, >, >=
+//   a | b        feeds every output of a into b
+
+// stepKind identifies the operation a step performs
+type stepKind int
+
+const (
+	stepField stepKind = iota
+	stepIndex
+	stepIterate
+	stepSelect
+)
+
+// step is one operation of a query
+type step struct {
+	kind  stepKind
+	field string
+	index int
+	cond  *condition
+}
+
+func (s step) String() string {
+	switch s.kind {
+	case stepField:
+		return fmt.Sprintf(".%q", s.field)
+	case stepIndex:
+		return fmt.Sprintf(".[%d]", s.index)
+	case stepIterate:
+		return ".[]"
+	default:
+		return "select(" + s.cond.String() + ")"
+	}
+}
+
+// condition is the argument to select
+// with an empty op, the path's outputs are tested for truthiness
+type condition struct {
+	path    []step
+	op      string
+	literal interface{}
+}
+
+func (c *condition) String() string {
+	var b strings.Builder
+	for _, s := range c.path {
+		b.WriteString(s.String())
+	}
+	if b.Len() == 0 {
+		b.WriteString(".")
+	}
+	if c.op != "" {
+		lit, _ := json.Marshal(c.literal)
+		fmt.Fprintf(&b, " %s %s", c.op, lit)
+	}
+	return b.String()
+}
+
+// parser is a simple recursive descent parser over the query string
+type parser struct {
+	s   string
+	pos int
+}
+
+// Parse compiles a query into steps
+// pipes don't need their own step, since in this subset a | b is the same as running b's steps
+// after a's
+func Parse(query string) ([]step, error) {
+	p := &parser{s: query}
+
+	steps, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	return steps, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query: column %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) space() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek reports whether the input continues with tok, skipping any spaces first
+func (p *parser) peek(tok string) bool {
+	p.space()
+	return strings.HasPrefix(p.s[p.pos:], tok)
+}
+
+func (p *parser) accept(tok string) bool {
+	if p.peek(tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(tok string) error {
+	if !p.accept(tok) {
+		return p.errorf("expected %q", tok)
+	}
+	return nil
+}
+
+func (p *parser) pipeline() ([]step, error) {
+	var steps []step
+	for {
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, term...)
+
+		if !p.accept("|") {
+			return steps, nil
+		}
+	}
+}
+
+func (p *parser) term() ([]step, error) {
+	if p.accept("select") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return []step{{kind: stepSelect, cond: cond}}, nil
+	}
+	return p.path()
+}
+
+// path parses a sequence of field, index and iterate steps, starting with a dot
+func (p *parser) path() ([]step, error) {
+	if !p.peek(".") {
+		return nil, p.errorf("expected a path starting with '.'")
+	}
+
+	var steps []step
+	for first := true; ; first = false {
+		// only the first dot can have spaces before it, so .a .b isn't read as .a.b
+		accept := p.acceptAdjacent
+		if first {
+			accept = p.accept
+		}
+		switch {
+		case accept(".["), len(steps) > 0 && p.acceptAdjacent("["):
+			s, err := p.bracket()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, s)
+		case accept("."):
+			// a lone dot is the identity, which has no step of its own
+			if name, ok := p.name(); ok {
+				steps = append(steps, step{kind: stepField, field: name})
+			} else if strings.HasPrefix(p.s[p.pos:], ".") {
+				// jq's .. recurses into every value; rather than run it as the identity, say so
+				return nil, p.errorf("'..' (recursive descent) isn't supported")
+			} else if len(steps) > 0 {
+				return nil, p.errorf("expected a field name after '.'")
+			}
+		default:
+			return steps, nil
+		}
+	}
+}
+
+// acceptAdjacent is like accept but doesn't skip spaces, so that .a [0] isn't read as .a[0]
+func (p *parser) acceptAdjacent(tok string) bool {
+	if strings.HasPrefix(p.s[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+// name reads a field name, either an identifier or a quoted string
+func (p *parser) name() (string, bool) {
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		s, err := p.str()
+		return s, err == nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := rune(p.s[p.pos])
+		if c != '_' && !unicode.IsLetter(c) && !(p.pos > start && unicode.IsDigit(c)) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos], p.pos > start
+}
+
+// bracket parses what follows a '[': an iterator, an index or a quoted key
+func (p *parser) bracket() (step, error) {
+	if p.accept("]") {
+		return step{kind: stepIterate}, nil
+	}
+
+	p.space()
+	var s step
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		key, err := p.str()
+		if err != nil {
+			return s, err
+		}
+		s = step{kind: stepField, field: key}
+	} else {
+		start := p.pos
+		if p.pos < len(p.s) && p.s[p.pos] == '-' {
+			p.pos++
+		}
+		for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+			p.pos++
+		}
+		n, err := strconv.Atoi(p.s[start:p.pos])
+		if err != nil {
+			return s, p.errorf("expected an index, a quoted key or ']'")
+		}
+		s = step{kind: stepIndex, index: n}
+	}
+
+	return s, p.expect("]")
+}
+
+// str reads a JSON string literal
+func (p *parser) str() (string, error) {
+	dec := json.NewDecoder(strings.NewReader(p.s[p.pos:]))
+	var s string
+	if err := dec.Decode(&s); err != nil {
+		return "", p.errorf("bad string: %v", err)
+	}
+	p.pos += int(dec.InputOffset())
+	return s, nil
+}
+
+var comparisons = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func (p *parser) condition() (*condition, error) {
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	c := &condition{path: path}
+
+	for _, op := range comparisons {
+		if p.accept(op) {
+			c.op = op
+			break
+		}
+	}
+	if c.op == "" {
+		return c, nil
+	}
+
+	c.literal, err = p.literal()
+	return c, err
+}
+
+// literal reads a JSON scalar: a string, number, true, false or null
+func (p *parser) literal() (interface{}, error) {
+	p.space()
+	rest := p.s[p.pos:]
+	for _, kw := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(rest, kw) {
+			p.pos += len(kw)
+			var v interface{}
+			json.Unmarshal([]byte(kw), &v)
+			return v, nil
+		}
+	}
+	if strings.HasPrefix(rest, `"`) {
+		return p.str()
+	}
+
+	end := strings.IndexFunc(rest, func(r rune) bool {
+		return !(r == '-' || r == '+' || r == '.' || r == 'e' || r == 'E' || unicode.IsDigit(r))
+	})
+	if end < 0 {
+		end = len(rest)
+	}
+	n := json.Number(rest[:end])
+	if _, err := n.Float64(); err != nil || end == 0 {
+		return nil, p.errorf("expected a literal")
+	}
+	p.pos += end
+	return n, nil
+}
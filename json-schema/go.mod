@@ -0,0 +1,3 @@
+module example/json-schema
+
+go 1.18
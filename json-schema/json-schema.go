@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// In the json example, Unmarshal into response2 is forgiving: a page sent as "1" is an error, but
+// unknown fields are silently dropped, missing fields are left at zero, and nothing stops a page
+// of -5 or an empty fruit name
+
+// JSON Schema describes what a valid document looks like, in a JSON document of its own
+// Here we'll implement a practical subset of it:
+//   type, enum, required, properties and additionalProperties
+//   minimum, maximum, exclusiveMinimum and exclusiveMaximum for numbers
+//   minLength, maxLength and pattern for strings
+//   items, minItems and maxItems for arrays
+//   $ref to other parts of the same schema, through $defs or definitions
+// Validation reports every failure with a JSON Pointer to the offending value, so a client can
+// tell exactly which field to fix
+
+// schema.go parses and resolves schemas, and validate.go checks values against them
+
+// response2 is the type from the json example
+type response2 struct {
+	Page   int      `json:"page"`
+	Fruits []string `json:"fruits"`
+}
+
+// response2Schema rules out everything Unmarshal would let through
+const response2Schema = `{
+	"type": "object",
+	"required": ["page", "fruits"],
+	"additionalProperties": false,
+	"properties": {
+		"page": {"type": "integer", "minimum": 1},
+		"fruits": {
+			"type": "array",
+			"minItems": 1,
+			"maxItems": 10,
+			"items": {"$ref": "#/$defs/fruit"}
+		}
+	},
+	"$defs": {
+		"fruit": {"type": "string", "pattern": "^[a-z]+$", "maxLength": 20}
+	}
+}`
+
+// maxBodySize limits how much of a request body ValidateBody will read
+const maxBodySize = 1 << 20
+
+// bodyKey is the context key for the validated body
+type bodyKey struct{}
+
+// Body returns the request body ValidateBody decoded and validated, for handlers that want to
+// work with it as a generic value rather than decoding it again
+func Body(req *http.Request) interface{} {
+	return req.Context().Value(bodyKey{})
+}
+
+// ValidateBody wraps a handler so it only sees requests whose JSON body conforms to the schema
+// rejected requests get an RFC 7807 problem+json response, with an "errors" member listing every
+// failure; accepted requests reach the handler with the body ready to be read again
+func ValidateBody(s *Schema, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt != "application/json" {
+			writeProblem(w, http.StatusUnsupportedMediaType, "the body must be application/json", nil)
+			return
+		}
+
+		// reading one byte past the limit tells a body that's too large from one that's exactly
+		// the limit; a read error, such as a client that went away or a broken chunked encoding,
+		// is a bad request
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize+1))
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		if len(body) > maxBodySize {
+			writeProblem(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("the body must be at most %d bytes", maxBodySize), nil)
+			return
+		}
+
+		v, err := decodeJSON(body)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		var verr ValidationError
+		if err := s.Validate(v); errors.As(err, &verr) {
+			writeProblem(w, http.StatusUnprocessableEntity, "the body doesn't match the schema", verr)
+			return
+		}
+
+		// the handler can decode the body again into its own type, or use the decoded value
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, req.WithContext(context.WithValue(req.Context(), bodyKey{}, v)))
+	}
+}
+
+// decodeJSON decodes exactly one JSON value, keeping numbers exact
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON: unexpected data after the top-level value")
+	}
+	return v, nil
+}
+
+// problem is an RFC 7807 problem details object, as in the rest-api example, with an extension
+// member for validation failures
+type problem struct {
+	Type   string          `json:"type"`
+	Title  string          `json:"title"`
+	Status int             `json:"status"`
+	Detail string          `json:"detail,omitempty"`
+	Errors ValidationError `json:"errors,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, status int, detail string, errs ValidationError) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{"about:blank", http.StatusText(status), status, detail, errs})
+}
+
+// createResponse is an ordinary handler: by the time it runs, Unmarshal can't be given anything
+// the schema doesn't allow
+func createResponse(w http.ResponseWriter, req *http.Request) {
+	var res response2
+	if err := json.NewDecoder(req.Body).Decode(&res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "page %d has %d fruits\n", res.Page, len(res.Fruits))
+}
+
+func main() {
+	schema := MustCompile(response2Schema)
+
+	// first, validate some documents directly
+	docs := []string{
+		`{"page": 1, "fruits": ["apple", "peach"]}`,
+		`{"page": 0, "fruits": ["apple", "Peach", 3], "colour": "red"}`,
+		`{"page": 1.5}`,
+		`[]`,
+	}
+	for _, d := range docs {
+		v, err := decodeJSON([]byte(d))
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(d)
+		if err := schema.Validate(v); err != nil {
+			for _, fe := range err.(ValidationError) {
+				fmt.Printf("  %-18s %s\n", fe.Keyword, fe)
+			}
+		} else {
+			fmt.Println("  valid")
+		}
+	}
+
+	// then use the schema to guard a handler, as in the http-servers example
+	http.HandleFunc("/responses", ValidateBody(schema, createResponse))
+	fmt.Println("listening on :8096")
+	if err := http.ListenAndServe(":8096", nil); err != nil {
+		fmt.Println(err)
+	}
+
+	// Run the server
+	// >> go run . &
+
+	// A valid body reaches the handler
+	// >> curl -H 'Content-Type: application/json' -d '{"page":2,"fruits":["pear"]}' localhost:8096/responses
+	// page 2 has 1 fruits
+
+	// An invalid one gets a 422 listing every problem
+	// >> curl -H 'Content-Type: application/json' -d '{"page":"2","fruits":[]}' localhost:8096/responses
+}
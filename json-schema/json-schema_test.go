@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestValidate(t *testing.T) {
+	schema := MustCompile(`{
+		"type": "object",
+		"required": ["id"],
+		"properties": {
+			"id": {"type": "integer", "exclusiveMinimum": 0},
+			"kind": {"enum": ["a", "b", 1]},
+			"tags": {"type": "array", "items": {"type": "string", "minLength": 1}, "maxItems": 2},
+			"child": {"$ref": "#"},
+			"a/b": {"type": "null"}
+		},
+		"additionalProperties": {"type": ["string", "boolean"]}
+	}`)
+
+	var tests = []struct {
+		doc  string
+		want []string
+	}{
+		{`{"id": 1}`, nil},
+		{`{"id": 1.0, "kind": 1, "extra": true}`, nil},
+		{`{}`, []string{"/id required"}},
+		{`"x"`, []string{" type"}},
+		{`{"id": 0, "kind": "c"}`, []string{"/id exclusiveMinimum", "/kind enum"}},
+		{`{"id": 1, "tags": ["", "x", "y"]}`, []string{"/tags maxItems", "/tags/0 minLength"}},
+		{`{"id": 1, "child": {"child": {"id": -1}}}`, []string{"/child/id required", "/child/child/id exclusiveMinimum"}},
+		{`{"id": 1, "a/b": 3, "extra": 4}`, []string{"/a~1b type", "/extra type"}},
+		{`{"id": 18446744073709551616}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.doc, func(t *testing.T) {
+			v, err := decodeJSON([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			if err := schema.Validate(v); err != nil {
+				for _, fe := range err.(ValidationError) {
+					got = append(got, fe.Path+" "+fe.Keyword)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	var tests = []string{
+		`{"$ref": "#/$defs/missing"}`,
+		`{"$ref": "other.json#/x"}`,
+		`{"pattern": "("}`,
+		`{"type": "float"}`,
+		// $ref cycles that never look into the value
+		`{"$ref": "#"}`,
+		`{"$defs":{"a":{"$ref":"#/$defs/b"},"b":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`,
+		`{"properties":{"x":{"$ref":"#/properties/x"}}}`,
+	}
+
+	for _, s := range tests {
+		if _, err := Compile([]byte(s)); err == nil {
+			t.Errorf("Compile(%s) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	h := ValidateBody(MustCompile(response2Schema), createResponse)
+
+	var tests = []struct {
+		body, contentType string
+		status            int
+	}{
+		{`{"page":2,"fruits":["pear"]}`, "application/json", http.StatusOK},
+		{`{"page":2,"fruits":["pear"]}`, "text/plain", http.StatusUnsupportedMediaType},
+		{`{"page":2,`, "application/json", http.StatusBadRequest},
+		{`{"page":2,"fruits":["pear"]} {}`, "application/json", http.StatusBadRequest},
+		{`{"page":"2","fruits":[]}`, "application/json", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/responses", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: got %d, want %d", tt.body, rec.Code, tt.status)
+		}
+	}
+
+	// only a body over the limit is too large; failing to read one is a bad request
+	for _, tt := range []struct {
+		name   string
+		body   io.Reader
+		status int
+	}{
+		{"too large", strings.NewReader(`"` + strings.Repeat("x", maxBodySize) + `"`), http.StatusRequestEntityTooLarge},
+		{"read error", io.MultiReader(strings.NewReader(`{"page":`), iotest.ErrReader(errors.New("connection reset"))), http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("POST", "/responses", tt.body)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: got %d, want %d", tt.name, rec.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// This file parses schemas and resolves their $refs; validate.go checks values against them
+
+// Schema is a JSON Schema, restricted to the keywords this example supports
+// unsupported keywords are ignored, as the specification asks of unknown keywords
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+	Type                 Types              `json:"type,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+
+	// a schema can also be just true, which accepts anything, or false, which accepts nothing
+	boolean *bool
+
+	ref     *Schema
+	pattern *regexp.Regexp
+}
+
+// Types is the type keyword, which is either a single type name or a list of them
+type Types []string
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = Types{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = many
+	return nil
+}
+
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*s = Schema{boolean: &b}
+		return nil
+	}
+
+	// a defined type without the UnmarshalJSON method, to avoid recursing into this one
+	type plain Schema
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode((*plain)(s))
+}
+
+// Compile parses a schema document, compiles its patterns and resolves its references
+// only references within the same document are supported, such as #/$defs/name
+func Compile(data []byte) (*Schema, error) {
+	var root Schema
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("schema: %v", err)
+	}
+	if err := root.compile(&root, "#"); err != nil {
+		return nil, err
+	}
+	// only once every reference is resolved can the chains of them be followed
+	if err := root.checkRefs("#"); err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+// MustCompile is like Compile but panics on error, for schemas written in code
+func MustCompile(data string) *Schema {
+	s, err := Compile([]byte(data))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// compile prepares a schema and everything nested inside it
+// it walks the schema's own structure rather than following references, so recursive schemas
+// don't send it round in circles
+func (s *Schema) compile(root *Schema, at string) error {
+	if s.Pattern != "" {
+		// JSON Schema patterns are ECMAScript regular expressions, which RE2 mostly agrees with for
+		// the patterns schemas use in practice
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("schema %s: pattern: %v", at, err)
+		}
+		s.pattern = re
+	}
+	if s.Ref != "" {
+		ref, err := resolve(root, s.Ref)
+		if err != nil {
+			return fmt.Errorf("schema %s: %v", at, err)
+		}
+		s.ref = ref
+	}
+	for _, t := range s.Type {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("schema %s: unknown type %q", at, t)
+		}
+	}
+
+	for path, c := range s.children() {
+		if err := c.compile(root, at+"/"+path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// children returns the schemas nested directly inside s, by their path from it
+func (s *Schema) children() map[string]*Schema {
+	children := make(map[string]*Schema)
+	if s.Items != nil {
+		children["items"] = s.Items
+	}
+	if s.AdditionalProperties != nil {
+		children["additionalProperties"] = s.AdditionalProperties
+	}
+	for name, c := range s.Properties {
+		children["properties/"+escape(name)] = c
+	}
+	for name, c := range s.Defs {
+		children["$defs/"+escape(name)] = c
+	}
+	for name, c := range s.Definitions {
+		children["definitions/"+escape(name)] = c
+	}
+	return children
+}
+
+// checkRefs rejects a $ref that leads, through other $refs alone, back to itself, such as
+// {"$ref": "#"}
+// validation follows a $ref without moving into the value, so such a cycle would never end;
+// a recursive schema is fine as long as each time round goes through properties or items
+func (s *Schema) checkRefs(at string) error {
+	seen := make(map[*Schema]bool)
+	for r := s; r != nil; r = r.ref {
+		if seen[r] {
+			return fmt.Errorf("schema %s: $ref %q leads back to itself without checking anything", at, s.Ref)
+		}
+		seen[r] = true
+	}
+	for path, c := range s.children() {
+		if err := c.checkRefs(at + "/" + path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve finds the schema a reference such as #/$defs/address points to
+// the fragment is a JSON Pointer, URL-encoded, into the schema document
+func resolve(root *Schema, ref string) (*Schema, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("$ref %q: only references within the document are supported", ref)
+	}
+	fragment, err := url.PathUnescape(ref[1:])
+	if err != nil {
+		return nil, fmt.Errorf("$ref %q: %v", ref, err)
+	}
+	if fragment == "" {
+		return root, nil
+	}
+	if !strings.HasPrefix(fragment, "/") {
+		return nil, fmt.Errorf("$ref %q: only JSON Pointer fragments are supported", ref)
+	}
+
+	tokens := strings.Split(fragment[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+
+	s := root
+	for i := 0; i < len(tokens) && s != nil; i++ {
+		switch tokens[i] {
+		case "items":
+			s = s.Items
+		case "additionalProperties":
+			s = s.AdditionalProperties
+		case "properties", "$defs", "definitions":
+			if i+1 == len(tokens) {
+				return nil, fmt.Errorf("$ref %q doesn't point to a schema", ref)
+			}
+			m := map[string]map[string]*Schema{"properties": s.Properties, "$defs": s.Defs, "definitions": s.Definitions}[tokens[i]]
+			i++
+			s = m[tokens[i]]
+		default:
+			return nil, fmt.Errorf("$ref %q: can't follow %q", ref, tokens[i])
+		}
+	}
+	if s == nil {
+		return nil, fmt.Errorf("$ref %q doesn't point to a schema", ref)
+	}
+	return s, nil
+}
+
+// escape escapes a JSON Pointer reference token
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError is a single validation failure
+// Path is a JSON Pointer to the offending value, such as /fruits/2, and Keyword is the schema
+// keyword it failed
+type FieldError struct {
+	Path    string `json:"path"`
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "(root)"
+	}
+	return fmt.Sprintf("%s: %s", path, e.Message)
+}
+
+// ValidationError lists every failure found in a value, not just the first
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks a decoded JSON value against the schema, returning a ValidationError if it
+// doesn't conform
+// values can be decoded with UseNumber or as float64; either way, minimum, maximum and enum
+// compare numbers as float64, so they aren't exact beyond 2^53
+func (s *Schema) Validate(v interface{}) error {
+	var errs ValidationError
+	s.validate(v, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (s *Schema) validate(v interface{}, path string, errs *ValidationError) {
+	fail := func(keyword, format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{path, keyword, fmt.Sprintf(format, args...)})
+	}
+
+	if s.boolean != nil {
+		if !*s.boolean {
+			fail("false", "no value is allowed here")
+		}
+		return
+	}
+
+	// as in newer drafts of the specification, keywords next to a $ref apply as well
+	if s.ref != nil {
+		s.ref.validate(v, path, errs)
+	}
+
+	if len(s.Type) > 0 {
+		ok := false
+		for _, t := range s.Type {
+			if hasType(v, t) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			// the other keywords would only add noise about a value of the wrong type
+			fail("type", "expected %s, got %s", strings.Join(s.Type, " or "), typeName(v))
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		ok := false
+		for _, e := range s.Enum {
+			if equal(v, e) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			allowed, _ := json.Marshal(s.Enum)
+			fail("enum", "must be one of %s", allowed)
+		}
+	}
+
+	switch v := v.(type) {
+	case json.Number, float64:
+		f := toFloat(v)
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("minimum", "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("maximum", "must be at most %v", *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+			fail("exclusiveMinimum", "must be greater than %v", *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+			fail("exclusiveMaximum", "must be less than %v", *s.ExclusiveMaximum)
+		}
+
+	case string:
+		// lengths are counted in characters, not bytes
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("minLength", "must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("maxLength", "must be at most %d characters long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("pattern", "must match %s", s.Pattern)
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("minItems", "must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("maxItems", "must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, el := range v {
+				s.Items.validate(el, fmt.Sprintf("%s/%d", path, i), errs)
+			}
+		}
+
+	case map[string]interface{}:
+		// members are reported in a stable order, required ones first
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, FieldError{path + "/" + escape(name), "required", "is required"})
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			p := path + "/" + escape(name)
+			if prop, ok := s.Properties[name]; ok {
+				prop.validate(v[name], p, errs)
+			} else if ap := s.AdditionalProperties; ap != nil {
+				if ap.boolean != nil && !*ap.boolean {
+					*errs = append(*errs, FieldError{p, "additionalProperties", "is not allowed"})
+				} else {
+					ap.validate(v[name], p, errs)
+				}
+			}
+		}
+	}
+}
+
+// hasType reports whether a decoded value is of a JSON Schema type
+func hasType(v interface{}, t string) bool {
+	switch t {
+	case "integer":
+		switch n := v.(type) {
+		case json.Number:
+			if _, err := n.Int64(); err == nil {
+				return true
+			}
+			// 1.0 and 1e3 are integers too
+			f := toFloat(n)
+			return f == math.Trunc(f) && !math.IsInf(f, 0)
+		case float64:
+			return n == math.Trunc(n) && !math.IsInf(n, 0)
+		}
+		return false
+	}
+	return typeName(v) == t
+}
+
+// typeName names the JSON type of a decoded value
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	case float64:
+		return n
+	}
+	return math.NaN()
+}
+
+// equal compares decoded JSON values for enum: numbers by value, whichever way they were decoded
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number, float64:
+		if typeName(b) != "number" {
+			return false
+		}
+		return toFloat(a) == toFloat(b)
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, av := range a {
+			if bv, ok := b[k]; !ok || !equal(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Namespace modes, for Conventions.Namespaces
+const (
+	// NamespacePrefix keeps names exactly as written, such as "g:plant", and keeps the xmlns
+	// declarations as attributes
+	NamespacePrefix = "prefix"
+
+	// NamespaceURI writes names qualified with their namespace URI in Clark notation, such as
+	// "{http://example.com/garden}plant", so they don't depend on the prefixes a document chose;
+	// xmlns declarations are dropped, and generated again when converting back
+	NamespaceURI = "uri"
+)
+
+// Conventions control how XML maps onto JSON
+// An element becomes a member named after it, whose value is:
+//
+//	a string, for an element with only text and no attributes
+//	an object otherwise, with AttrPrefix+name for each attribute, TextKey for its text and a member
+//	for each child element
+//	an array, for an element that's repeated or listed in Arrays
+//
+// If an element's children can't be grouped by name without losing their order (mixed text and
+// elements, or <a/><b/><a/>), its children go in order in a ContentKey array instead, with text
+// as strings and each element as an object with one member
+type Conventions struct {
+	AttrPrefix string
+	TextKey    string
+	ContentKey string
+	Namespaces string
+
+	// Arrays lists element names that are always arrays, even when they only appear once, so the
+	// JSON has the same shape whatever the count
+	Arrays []string
+
+	// InferTypes turns text that's a JSON number or true or false into a number or boolean,
+	// instead of a string
+	// the text is kept exactly as written, so it converts back to the same XML
+	InferTypes bool
+
+	// Indent indents XML output; whitespace is only added between elements that have no text of
+	// their own, so it never changes the content
+	Indent string
+}
+
+// DefaultConventions are the conventions most converters use
+var DefaultConventions = Conventions{
+	AttrPrefix: "@",
+	TextKey:    "#text",
+	ContentKey: "#content",
+	Namespaces: NamespacePrefix,
+	Indent:     "  ",
+}
+
+func (c *Conventions) isArray(name string) bool {
+	for _, a := range c.Arrays {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// XMLToJSON converts an XML document to a JSON value with a single member, named after the root
+// element
+// whitespace between elements, comments, processing instructions and the XML declaration are
+// formatting rather than data, and aren't kept
+func (c *Conventions) XMLToJSON(r io.Reader) (interface{}, error) {
+	dec := xml.NewDecoder(r)
+	var root object
+	for {
+		tok, err := c.token(dec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil {
+				return nil, errors.New("more than one root element")
+			}
+			v, err := c.element(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			root = object{{c.name(t.Name), v}}
+		case xml.CharData:
+			if strings.TrimSpace(string(t)) != "" {
+				return nil, errors.New("text outside the root element")
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+// token reads the next token, resolving namespaces only if names are to be written as URIs
+// RawToken leaves prefixes and xmlns attributes as they were written, but doesn't check that
+// start and end elements match, so element does that itself
+func (c *Conventions) token(dec *xml.Decoder) (xml.Token, error) {
+	if c.Namespaces == NamespaceURI {
+		return dec.Token()
+	}
+	return dec.RawToken()
+}
+
+// name formats an element or attribute name according to the namespace mode
+func (c *Conventions) name(n xml.Name) string {
+	switch {
+	case n.Space == "":
+		return n.Local
+	case c.Namespaces == NamespaceURI:
+		return "{" + n.Space + "}" + n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// node is an element or a run of text, in document order
+type node struct {
+	name  string // "" for text
+	value interface{}
+}
+
+// element converts an element whose start tag has just been read
+func (c *Conventions) element(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	var attrs object
+	for _, a := range start.Attr {
+		if c.Namespaces == NamespaceURI && (a.Name.Space == "xmlns" || a.Name.Local == "xmlns" && a.Name.Space == "") {
+			continue
+		}
+		attrs = append(attrs, member{c.AttrPrefix + c.name(a.Name), c.scalar(a.Value)})
+	}
+
+	var nodes []node
+	var text strings.Builder
+	hasChildren := false
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, node{"", text.String()})
+		}
+		text.Reset()
+	}
+
+	for {
+		tok, err := c.token(dec)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			flush()
+			v, err := c.element(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node{c.name(t.Name), v})
+			hasChildren = true
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if t.Name != start.Name {
+				return nil, fmt.Errorf("element <%s> closed by </%s>", c.name(start.Name), c.name(t.Name))
+			}
+			flush()
+			if hasChildren {
+				nodes = dropIndentation(nodes)
+			}
+			return c.build(attrs, nodes), nil
+		}
+	}
+}
+
+// dropIndentation removes whitespace-only text from between child elements, where it's
+// indentation; in an element without children, such as <n> </n>, it's the element's value
+func dropIndentation(nodes []node) []node {
+	kept := nodes[:0]
+	for _, n := range nodes {
+		if n.name == "" && strings.TrimSpace(n.value.(string)) == "" {
+			continue
+		}
+		kept = append(kept, n)
+	}
+	return kept
+}
+
+// build chooses the JSON form of an element from its attributes and content
+func (c *Conventions) build(attrs object, nodes []node) interface{} {
+	// an element with just text, or nothing, is a plain value
+	if len(attrs) == 0 && len(nodes) <= 1 && (len(nodes) == 0 || nodes[0].name == "") {
+		if len(nodes) == 0 {
+			return ""
+		}
+		return c.scalar(nodes[0].value.(string))
+	}
+
+	obj := attrs
+	if !grouped(nodes) {
+		content := make([]interface{}, len(nodes))
+		for i, n := range nodes {
+			if n.name == "" {
+				content[i] = n.value
+			} else {
+				content[i] = object{{n.name, n.value}}
+			}
+		}
+		return append(obj, member{c.ContentKey, content})
+	}
+
+	for _, n := range nodes {
+		switch {
+		case n.name == "":
+			obj = append(obj, member{c.TextKey, c.scalar(n.value.(string))})
+		case c.isArray(n.name) || count(nodes, n.name) > 1:
+			if i := obj.index(n.name); i >= 0 {
+				obj[i].Value = append(obj[i].Value.([]interface{}), n.value)
+			} else {
+				obj = append(obj, member{n.name, []interface{}{n.value}})
+			}
+		default:
+			obj = append(obj, member{n.name, n.value})
+		}
+	}
+	return obj
+}
+
+// grouped reports whether content can be written as text followed by runs of same-named
+// elements, which is the order an object's members convert back in
+func grouped(nodes []node) bool {
+	seen := make(map[string]bool)
+	for i, n := range nodes {
+		if n.name == "" {
+			if i > 0 {
+				return false
+			}
+			continue
+		}
+		if seen[n.name] && nodes[i-1].name != n.name {
+			return false
+		}
+		seen[n.name] = true
+	}
+	return true
+}
+
+func count(nodes []node, name string) int {
+	n := 0
+	for _, nd := range nodes {
+		if nd.name == name {
+			n++
+		}
+	}
+	return n
+}
+
+// scalar converts text to a JSON value
+func (c *Conventions) scalar(s string) interface{} {
+	if !c.InferTypes {
+		return s
+	}
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	// only text that is exactly a JSON number is converted, so 027 or +1 stay strings and the
+	// number's text converts back unchanged
+	if s != "" && (s[0] == '-' || s[0] >= '0' && s[0] <= '9') && json.Valid([]byte(s)) {
+		return json.Number(s)
+	}
+	return s
+}
+
+// JSONToXML writes a JSON value, in the form XMLToJSON produces, as XML
+func (c *Conventions) JSONToXML(v interface{}, enc *xml.Encoder) error {
+	root, ok := v.(object)
+	if !ok || len(root) != 1 {
+		return errors.New("the document must be an object with a single member, for the root element")
+	}
+	if _, ok := root[0].Value.([]interface{}); ok {
+		return errors.New("the root element can't be an array")
+	}
+	if err := c.writeElement(enc, root[0].Name, root[0].Value, 0, true); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func (c *Conventions) xmlName(name string) (xml.Name, error) {
+	if c.Namespaces == NamespaceURI && strings.HasPrefix(name, "{") {
+		i := strings.IndexByte(name, '}')
+		if i < 0 {
+			return xml.Name{}, fmt.Errorf("name %q: unterminated namespace", name)
+		}
+		return xml.Name{Space: name[1:i], Local: name[i+1:]}, nil
+	}
+	if name == "" {
+		return xml.Name{}, errors.New("empty element name")
+	}
+	return xml.Name{Local: name}, nil
+}
+
+// pad writes a newline and indentation for a tag at depth
+func (c *Conventions) pad(enc *xml.Encoder, depth int) error {
+	if c.Indent == "" {
+		return nil
+	}
+	return enc.EncodeToken(xml.CharData("\n" + strings.Repeat(c.Indent, depth)))
+}
+
+// writeElement writes an element, and indents it if its parent has no text of its own
+func (c *Conventions) writeElement(enc *xml.Encoder, name string, v interface{}, depth int, indent bool) error {
+	// an array is the same element repeated
+	if arr, ok := v.([]interface{}); ok {
+		for _, el := range arr {
+			if _, nested := el.([]interface{}); nested {
+				return fmt.Errorf("%s: arrays of arrays have no XML form", name)
+			}
+			if err := c.writeElement(enc, name, el, depth, indent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the root's start tag begins the document, so it has nothing to be indented from
+	if indent && depth > 0 {
+		if err := c.pad(enc, depth); err != nil {
+			return err
+		}
+	}
+
+	n, err := c.xmlName(name)
+	if err != nil {
+		return err
+	}
+	start := xml.StartElement{Name: n}
+
+	obj, ok := v.(object)
+	if !ok {
+		// a plain value is the element's text
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if v != nil {
+			text, err := c.text(name, v)
+			if err != nil {
+				return err
+			}
+			if err := enc.EncodeToken(xml.CharData(text)); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	}
+
+	// attributes have to go in the start tag, wherever they are in the object
+	for _, m := range obj {
+		if c.AttrPrefix == "" || !strings.HasPrefix(m.Name, c.AttrPrefix) {
+			continue
+		}
+		an, err := c.xmlName(strings.TrimPrefix(m.Name, c.AttrPrefix))
+		if err != nil {
+			return err
+		}
+		text, err := c.text(m.Name, m.Value)
+		if err != nil {
+			return err
+		}
+		start.Attr = append(start.Attr, xml.Attr{Name: an, Value: text})
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	// whitespace can only be added between children when there's no text it would change
+	elementsOnly := obj.index(c.TextKey) < 0 && obj.index(c.ContentKey) < 0
+	children := false
+
+	for _, m := range obj {
+		switch {
+		case c.AttrPrefix != "" && strings.HasPrefix(m.Name, c.AttrPrefix):
+		case m.Name == c.TextKey:
+			text, err := c.text(m.Name, m.Value)
+			if err != nil {
+				return err
+			}
+			if err := enc.EncodeToken(xml.CharData(text)); err != nil {
+				return err
+			}
+		case m.Name == c.ContentKey:
+			if err := c.writeContent(enc, name, m.Value, depth); err != nil {
+				return err
+			}
+		default:
+			children = true
+			if err := c.writeElement(enc, m.Name, m.Value, depth+1, elementsOnly); err != nil {
+				return err
+			}
+		}
+	}
+	if elementsOnly && children {
+		if err := c.pad(enc, depth); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// writeContent writes an ordered content array: strings are text and objects are elements
+func (c *Conventions) writeContent(enc *xml.Encoder, name string, v interface{}, depth int) error {
+	content, ok := v.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s: %s must be an array", name, c.ContentKey)
+	}
+	for _, item := range content {
+		switch item := item.(type) {
+		case string:
+			if err := enc.EncodeToken(xml.CharData(item)); err != nil {
+				return err
+			}
+		case object:
+			for _, m := range item {
+				if err := c.writeElement(enc, m.Name, m.Value, depth+1, false); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("%s: %s can only hold strings and objects", name, c.ContentKey)
+		}
+	}
+	return nil
+}
+
+// text formats a scalar JSON value as XML text
+func (c *Conventions) text(name string, v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("%s: only a string, number or boolean can be text", name)
+}
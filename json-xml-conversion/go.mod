@@ -0,0 +1,3 @@
+module example/json-xml-conversion
+
+go 1.18
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The json and xml examples show the same kind of record, a Plant, in each format
+// When some partners send XML and others JSON, it helps to convert between them mechanically,
+// without a Go type per record
+
+// XML has things JSON doesn't (attributes, namespaces, text mixed with elements, repeated elements)
+// so a converter needs conventions for them; convert.go implements the common ones, each
+// configurable:
+//   attributes become members with a prefix, such as "@id"
+//   text next to attributes or elements becomes a "#text" member
+//   repeated elements become arrays, and named elements can be made arrays even when alone
+//   namespace prefixes are kept as written, or names are qualified with their namespace URI
+// Converting XML to JSON and back gives the same XML, apart from indentation and comments
+// ordered.go keeps JSON members in order, since XML element order matters
+
+// nesting is the document the xml example produces from its Nesting type, with a namespace added
+const nesting = `<?xml version="1.0" encoding="UTF-8"?>
+<nesting xmlns:g="http://example.com/garden">
+	<parent>
+		<child>
+			<plant id="27" g:verified="true">
+				<name>Coffee</name>
+				<origin>Ethiopia</origin>
+				<origin>Brazil</origin>
+			</plant>
+			<plant id="81">
+				<name>Tomato</name>
+				<origin>Mexico</origin>
+				<g:note>Grows in <em>warm</em> climates</g:note>
+			</plant>
+		</child>
+	</parent>
+</nesting>`
+
+// convert reads XML or JSON, telling which from the first character, and writes the other
+func convert(c *Conventions, r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(b)) == "" {
+			br.ReadByte()
+			continue
+		}
+		if b[0] == '<' {
+			v, err := c.XMLToJSON(br)
+			if err != nil {
+				return err
+			}
+			return encodeJSON(w, v)
+		}
+		break
+	}
+
+	v, err := decodeJSON(br)
+	if err != nil {
+		return err
+	}
+	if err := c.JSONToXML(v, xml.NewEncoder(w)); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+func main() {
+	c := DefaultConventions
+	flag.StringVar(&c.AttrPrefix, "attr", c.AttrPrefix, "prefix for attribute members")
+	flag.StringVar(&c.TextKey, "text", c.TextKey, "member name for text next to attributes or elements")
+	flag.StringVar(&c.ContentKey, "content", c.ContentKey, "member name for content whose order can't be kept otherwise")
+	flag.StringVar(&c.Namespaces, "ns", c.Namespaces, "namespace names: prefix, as written, or uri, as {uri}name")
+	arrays := flag.String("arrays", "", "comma-separated element names that are always arrays")
+	flag.BoolVar(&c.InferTypes, "types", false, "convert numeric and boolean text to JSON numbers and booleans")
+	flag.StringVar(&c.Indent, "indent", c.Indent, "indentation for XML output")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: json-xml-conversion [flags] [file]")
+		fmt.Fprintln(os.Stderr, "converts XML to JSON or JSON to XML; - reads stdin, and no file runs a demo")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if c.Namespaces != NamespacePrefix && c.Namespaces != NamespaceURI {
+		fmt.Fprintf(os.Stderr, "unknown namespace mode %q\n", c.Namespaces)
+		os.Exit(2)
+	}
+	if *arrays != "" {
+		c.Arrays = strings.Split(*arrays, ",")
+	}
+
+	if flag.NArg() > 0 {
+		in := os.Stdin
+		if name := flag.Arg(0); name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			in = f
+		}
+		if err := convert(&c, in, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// the demo converts the xml example's nesting to JSON and back
+	// origin is always an array, so plants with one origin have the same shape as the others
+	c.Arrays = append(c.Arrays, "plant", "origin")
+	c.InferTypes = true
+
+	var js bytes.Buffer
+	if err := convert(&c, strings.NewReader(nesting), &js); err != nil {
+		panic(err)
+	}
+	fmt.Print(js.String())
+
+	var x bytes.Buffer
+	if err := convert(&c, bytes.NewReader(js.Bytes()), &x); err != nil {
+		panic(err)
+	}
+	fmt.Print(x.String())
+
+	// converting the XML again gives exactly the same JSON: nothing was lost on the way
+	var again bytes.Buffer
+	if err := convert(&c, bytes.NewReader(x.Bytes()), &again); err != nil {
+		panic(err)
+	}
+	fmt.Println("round trip lossless:", again.String() == js.String())
+
+	// with URI names, documents using different prefixes for the same namespace convert alike
+	c.Namespaces = NamespaceURI
+	for _, doc := range []string{
+		`<g:plant xmlns:g="http://example.com/garden" g:id="27"/>`,
+		`<plant xmlns="http://example.com/garden" xmlns:x="http://example.com/garden" x:id="27"></plant>`,
+	} {
+		v, err := c.XMLToJSON(strings.NewReader(doc))
+		if err != nil {
+			panic(err)
+		}
+		var b bytes.Buffer
+		encodeJSON(&b, v)
+		fmt.Print(strings.Join(strings.Fields(b.String()), " "), "\n")
+	}
+
+	// Run the demo
+	// >> go run .
+
+	// Or convert a file, in whichever direction applies
+	// >> go run . -arrays origin plants.xml > plants.json
+	// >> go run . plants.json
+}
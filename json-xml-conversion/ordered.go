@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XML elements are ordered, but decoding a JSON object into a map[string]interface{} loses the
+// order of its members
+// So the converter works on its own representation of JSON, where objects keep their order:
+// object for objects, []interface{} for arrays, json.Number for numbers, and string, bool and nil
+
+// member is a single name and value in an object
+type member struct {
+	Name  string
+	Value interface{}
+}
+
+// object is a JSON object that remembers the order of its members
+type object []member
+
+// index returns the position of the named member, or -1
+func (o object) index(name string) int {
+	for i, m := range o {
+		if m.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodeJSON reads one JSON value, keeping object members in order and numbers as written
+func decodeJSON(r io.Reader) (interface{}, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the top-level value")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := object{}
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{name.(string), v})
+		}
+		_, err := dec.Token()
+		return obj, err
+
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// encodeJSON writes a value as indented JSON, members in order
+func encodeJSON(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	if err := writeValue(&buf, v, 0); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeValue(buf *bytes.Buffer, v interface{}, depth int) error {
+	indent := func(d int) {
+		buf.WriteByte('\n')
+		buf.WriteString(strings.Repeat("  ", d))
+	}
+
+	switch v := v.(type) {
+	case object:
+		if len(v) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteByte('{')
+		for i, m := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			indent(depth + 1)
+			writeString(buf, m.Name)
+			buf.WriteString(": ")
+			if err := writeValue(buf, m.Value, depth+1); err != nil {
+				return err
+			}
+		}
+		indent(depth)
+		buf.WriteByte('}')
+
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteByte('[')
+		for i, el := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			indent(depth + 1)
+			if err := writeValue(buf, el, depth+1); err != nil {
+				return err
+			}
+		}
+		indent(depth)
+		buf.WriteByte(']')
+
+	case string:
+		writeString(buf, v)
+	case json.Number:
+		buf.WriteString(v.String())
+	case bool:
+		fmt.Fprint(buf, v)
+	case nil:
+		buf.WriteString("null")
+	default:
+		return fmt.Errorf("can't encode %T as JSON", v)
+	}
+	return nil
+}
+
+// writeString writes a JSON string without the HTML escaping json.Marshal does, which would make
+// text containing < and & hard to read
+func writeString(buf *bytes.Buffer, s string) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	buf.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+}
@@ -0,0 +1,3 @@
+module example/recursive-grep
+
+go 1.18
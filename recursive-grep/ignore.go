@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Most of what's in a source tree that isn't source is listed in .gitignore files: build output,
+// dependencies, logs. Searching them is slow and the matches are noise, so they're skipped
+// This is the part of the .gitignore format that covers nearly every real file:
+//   blank lines and lines starting with # are ignored
+//   a pattern matches a file or directory name at any depth, e.g. *.log or node_modules
+//   a pattern with a / at the start or in the middle is relative to the .gitignore's directory
+//   a / at the end only matches directories
+//   ** matches any number of directories, e.g. docs/**/*.tmp
+//   ! re-includes what an earlier pattern excluded; the last pattern to match wins
+// A .gitignore applies to its own directory and everything under it
+
+// ignoreRule is one line of a .gitignore
+type ignoreRule struct {
+	// base is the directory of the .gitignore, relative to the search root, "" for the root
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Ignorer holds the rules from the .gitignore files found so far
+type Ignorer struct {
+	rules []ignoreRule
+}
+
+// Load reads the .gitignore in a directory, if there is one; dir is relative to root
+func (ig *Ignorer) Load(root, dir string) error {
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(dir), ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		ig.Add(dir, scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// Add adds one .gitignore line, from the .gitignore in dir
+func (ig *Ignorer) Add(dir, line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	r := ignoreRule{base: dir}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	}
+	// \# and \! escape a literal leading # or !
+	line = strings.TrimPrefix(line, `\`)
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return
+	}
+	r.segments = strings.Split(line, "/")
+	ig.rules = append(ig.rules, r)
+}
+
+// Ignored reports whether a path, relative to the search root with / separators, is ignored
+func (ig *Ignorer) Ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range ig.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		p := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			p = rel[len(r.base)+1:]
+		}
+		parts := strings.Split(p, "/")
+		var match bool
+		if r.anchored {
+			match = matchSegments(r.segments, parts)
+		} else {
+			// an unanchored pattern is a single name, matched against the last part
+			match = matchSegments(r.segments, parts[len(parts)-1:])
+		}
+		if match {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches a pattern's path segments against a path's, with ** matching any number
+// of segments, including none
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
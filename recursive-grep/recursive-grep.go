@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The regex example runs each Regexp method on a literal string
+// Here they're put to work on a tree of files, as grep -rn does, but skipping what grep -r
+// doesn't: binary files, hidden files and directories such as .git, and whatever .gitignore
+// files list (ignore.go)
+// Matches are printed with context lines and highlighted, or with -json written one object per
+// match, with the pattern's named groups, (?P<name>...), as members, ready for jq or another
+// program (search.go)
+
+// Like grep, the exit status is 0 if a line matched, 1 if none did, and 2 if there was an error
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+
+	// Search the current directory, with two lines of context around each match
+	// >> go run . -C 2 'func (\w+)' .
+
+	// Emit named groups as JSON, here the message of every WARN or ERROR log line
+	// >> go run . -json '(?P<level>WARN|ERROR) (?P<msg>.*)' logs | jq -r '.groups.msg'
+
+	// Colour is used when writing to a terminal; -colour always keeps it through a pager
+	// >> go run . -colour always TODO . | less -R
+}
+
+// run is the whole command, with its output passed in so it can be tested
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("recursive-grep", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	icase := flags.Bool("i", false, "ignore case")
+	after := flags.Int("A", 0, "print `n` lines of context after each match")
+	before := flags.Int("B", 0, "print `n` lines of context before each match")
+	context := flags.Int("C", 0, "print `n` lines of context before and after each match")
+	colour := flags.String("colour", "auto", "highlight matches: auto (when writing to a terminal), always or never")
+	asJSON := flags.Bool("json", false, "print each match as a JSON object, with named groups")
+	hidden := flags.Bool("hidden", false, "search hidden files and directories, whose names start with .")
+	noIgnore := flags.Bool("no-ignore", false, "search files that .gitignore files exclude")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: recursive-grep [flags] pattern [path...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	pattern := flags.Arg(0)
+	if *icase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fmt.Fprintln(stderr, "recursive-grep:", err)
+		return 2
+	}
+
+	s := &Searcher{Re: re, Before: *before, After: *after}
+	if *context > 0 {
+		if s.Before == 0 {
+			s.Before = *context
+		}
+		if s.After == 0 {
+			s.After = *context
+		}
+	}
+
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+	var p Printer
+	if *asJSON {
+		p = &JSONPrinter{Enc: json.NewEncoder(w), Re: re}
+	} else {
+		switch *colour {
+		case "always", "never", "auto":
+		default:
+			fmt.Fprintf(stderr, "recursive-grep: -colour must be auto, always or never, not %q\n", *colour)
+			return 2
+		}
+		p = &TextPrinter{W: w, Colour: *colour == "always" || *colour == "auto" && isTerminal(stdout)}
+	}
+
+	g := &grepper{searcher: s, printer: p, stderr: stderr, hidden: *hidden, noIgnore: *noIgnore}
+	roots := flags.Args()[1:]
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	for _, root := range roots {
+		g.walk(root)
+	}
+
+	switch {
+	case g.failed:
+		return 2
+	case g.matched:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// isTerminal reports whether w is a terminal, which is when colour is wanted by default
+// NO_COLOR (https://no-color.org) turns it off
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// grepper walks the paths to search
+type grepper struct {
+	searcher *Searcher
+	printer  Printer
+	stderr   io.Writer
+
+	hidden, noIgnore bool
+	matched, failed  bool
+}
+
+func (g *grepper) fail(err error) {
+	fmt.Fprintln(g.stderr, "recursive-grep:", err)
+	g.failed = true
+}
+
+// walk searches a file, or every file under a directory
+// a path named on the command line is always searched, even if it's hidden or ignored, and is
+// followed if it's a symbolic link, as grep -r does
+func (g *grepper) walk(root string) {
+	info, err := os.Stat(root)
+	if err != nil {
+		g.fail(err)
+		return
+	}
+	if !info.IsDir() {
+		g.search(root)
+		return
+	}
+	// WalkDir doesn't follow a link it's given, so it's given where the link leads
+	dir, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		g.fail(err)
+		return
+	}
+
+	ig := &Ignorer{}
+	err = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// an unreadable directory is reported, and the walk goes on
+			g.fail(err)
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, name)
+		// files are named under the path as it was given
+		name = filepath.Join(root, rel)
+		rel = filepath.ToSlash(rel)
+		isRoot := rel == "."
+
+		if d.IsDir() {
+			if !isRoot && g.skip(ig, d.Name(), rel, true) {
+				return filepath.SkipDir
+			}
+			if !g.noIgnore {
+				if isRoot {
+					rel = ""
+				}
+				if err := ig.Load(dir, rel); err != nil {
+					g.fail(err)
+				}
+			}
+			return nil
+		}
+
+		// symbolic links, devices and the like below the root aren't followed, as grep -r doesn't
+		if !d.Type().IsRegular() || g.skip(ig, d.Name(), rel, false) {
+			return nil
+		}
+		g.search(name)
+		return nil
+	})
+	if err != nil {
+		g.fail(err)
+	}
+}
+
+// skip reports whether a file or directory below a search root is left out
+func (g *grepper) skip(ig *Ignorer, base, rel string, isDir bool) bool {
+	if base == ".git" {
+		return true
+	}
+	if !g.hidden && strings.HasPrefix(base, ".") {
+		return true
+	}
+	return !g.noIgnore && ig.Ignored(rel, isDir)
+}
+
+// search searches one file, unless it's binary
+func (g *grepper) search(name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		g.fail(err)
+		return
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, binaryCheckSize)
+	// Peek returns a short read with io.EOF for files smaller than binaryCheckSize
+	head, err := r.Peek(binaryCheckSize)
+	if err != nil && err != io.EOF {
+		g.fail(fmt.Errorf("%s: %v", name, err))
+		return
+	}
+	if isBinary(head) {
+		return
+	}
+
+	n, err := g.searcher.Search(name, r, g.printer)
+	if err != nil {
+		g.fail(err)
+	}
+	if n > 0 {
+		g.matched = true
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	ig := &Ignorer{}
+	for _, line := range []string{
+		"# build output",
+		"*.log",
+		"!keep.log",
+		"bin/",
+		"/vendor",
+		"docs/**/*.tmp",
+		"",
+		`\#notes`,
+	} {
+		ig.Add("", line)
+	}
+	ig.Add("web", "dist")
+	ig.Add("web", "/local.txt")
+
+	var tests = []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"app.log", false, true},
+		{"logs/deep/app.log", false, true},
+		{"keep.log", false, false},
+		{"bin", true, true},
+		{"cmd/bin", true, true},
+		// bin/ only matches directories
+		{"bin", false, false},
+		{"vendor", true, true},
+		// /vendor is anchored to the root
+		{"lib/vendor", true, false},
+		{"docs/a.tmp", false, true},
+		{"docs/x/y/a.tmp", false, true},
+		{"src/a.tmp", false, false},
+		{"#notes", false, true},
+		{"web/dist", true, true},
+		{"web/app/dist", true, true},
+		// a .gitignore only applies under its own directory
+		{"dist", true, false},
+		{"web/local.txt", false, true},
+		{"web/app/local.txt", false, false},
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := ig.Ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%s (dir %v): got %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+// tree creates files under a temporary directory, and changes to it
+func tree(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestRun(t *testing.T) {
+	tree(t, map[string]string{
+		".gitignore":        "*.log\nbuild/\n",
+		"main.go":           "package main\n\nfunc main() {\n\tgreet()\n}\n\nfunc greet() {}\n",
+		"notes.txt":         "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\n",
+		"app.log":           "func ignored\n",
+		"build/out.go":      "func ignored\n",
+		".hidden/secret.go": "func hidden\n",
+		".git/config":       "func git\n",
+		"image.bin":         "func\x00binary\n",
+		"sub/.gitignore":    "skip.go\n",
+		"sub/skip.go":       "func skipped\n",
+		"sub/lib.go":        "package sub\nfunc Lib() {}\n",
+	})
+
+	var tests = []struct {
+		name   string
+		args   []string
+		want   string
+		status int
+	}{
+		{"skips ignored, hidden and binary", []string{"^func"}, `main.go:3:func main() {
+main.go:7:func greet() {}
+sub/lib.go:2:func Lib() {}
+`, 0},
+		{"hidden and not ignored", []string{"-hidden", "-no-ignore", "^func (ig|hid|sk)"}, `.hidden/secret.go:1:func hidden
+app.log:1:func ignored
+build/out.go:1:func ignored
+sub/skip.go:1:func skipped
+`, 0},
+		{"named paths are always searched", []string{"func", "app.log", "sub"}, `app.log:1:func ignored
+sub/lib.go:2:func Lib() {}
+`, 0},
+		{"after", []string{"-A", "1", "^(two|six)$", "notes.txt"}, `notes.txt:2:two
+notes.txt-3-three
+--
+notes.txt:6:six
+notes.txt-7-seven
+`, 0},
+		{"before", []string{"-B", "2", "^(three|four)$", "notes.txt"}, `notes.txt-1-one
+notes.txt-2-two
+notes.txt:3:three
+notes.txt:4:four
+`, 0},
+		{"context joins groups", []string{"-C", "1", "^(two|four)$", "notes.txt"}, `notes.txt-1-one
+notes.txt:2:two
+notes.txt-3-three
+notes.txt:4:four
+notes.txt-5-five
+`, 0},
+		{"context separates files", []string{"-A", "1", "^(eight|package main)$", "notes.txt", "main.go"}, `notes.txt:8:eight
+--
+main.go:1:package main
+main.go-2-
+`, 0},
+		{"ignore case", []string{"-i", "GREET\\(\\)$", "main.go"}, "main.go:4:\tgreet()\n", 0},
+		{"colour", []string{"-colour", "always", "gr..t", "main.go"},
+			"\x1b[35mmain.go\x1b[0m\x1b[36m:\x1b[0m\x1b[32m4\x1b[0m\x1b[36m:\x1b[0m\t\x1b[1;31mgreet\x1b[0m()\n" +
+				"\x1b[35mmain.go\x1b[0m\x1b[36m:\x1b[0m\x1b[32m7\x1b[0m\x1b[36m:\x1b[0mfunc \x1b[1;31mgreet\x1b[0m() {}\n", 0},
+		{"json", []string{"-json", "-C", "3", `func (?P<name>\w+)\((?P<args>x)?`, "main.go"},
+			`{"path":"main.go","line":3,"column":1,"text":"func main() {","match":"func main(","groups":{"args":null,"name":"main"}}
+{"path":"main.go","line":7,"column":1,"text":"func greet() {}","match":"func greet(","groups":{"args":null,"name":"greet"}}
+`, 0},
+		{"json, a match each", []string{"-json", "e", "notes.txt"}, `{"path":"notes.txt","line":1,"column":3,"text":"one","match":"e"}
+{"path":"notes.txt","line":3,"column":4,"text":"three","match":"e"}
+{"path":"notes.txt","line":3,"column":5,"text":"three","match":"e"}
+{"path":"notes.txt","line":5,"column":4,"text":"five","match":"e"}
+{"path":"notes.txt","line":7,"column":2,"text":"seven","match":"e"}
+{"path":"notes.txt","line":7,"column":4,"text":"seven","match":"e"}
+{"path":"notes.txt","line":8,"column":1,"text":"eight","match":"e"}
+`, 0},
+		{"no match", []string{"nothing like this"}, "", 1},
+		{"missing file", []string{"x", "missing.go"}, "", 2},
+		{"bad pattern", []string{"("}, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errs bytes.Buffer
+			status := run(tt.args, &out, &errs)
+			if got := out.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if status != tt.status {
+				t.Errorf("status: got %d, want %d (%s)", status, tt.status, strings.TrimSpace(errs.String()))
+			}
+		})
+	}
+}
+
+func TestSymlinkedRoots(t *testing.T) {
+	tree(t, map[string]string{
+		"src/main.go":  "func main() {}\n",
+		"docs/todo.md": "func docs\n",
+	})
+	// links named on the command line are followed, but src/link.go, found in the walk, isn't
+	for _, l := range [][2]string{{"src", "code"}, {"src/main.go", "main.go"}, {"../docs", "src/link.go"}} {
+		if err := os.Symlink(l[0], l[1]); err != nil {
+			t.Skip("symbolic links aren't supported here:", err)
+		}
+	}
+
+	var out, errs bytes.Buffer
+	status := run([]string{"^func", "code", "main.go"}, &out, &errs)
+	want := "code/main.go:1:func main() {}\nmain.go:1:func main() {}\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if status != 0 {
+		t.Errorf("status: got %d, want 0 (%s)", status, strings.TrimSpace(errs.String()))
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// This file searches one file's lines, with context, and prints what it finds as grep does, or
+// as JSON
+
+// binaryCheckSize is how much of a file is looked at to decide whether it's binary; like git and
+// grep, a file is binary if that much has a NUL byte in it
+const binaryCheckSize = 8000
+
+// isBinary reports whether the start of a file looks binary
+func isBinary(head []byte) bool {
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// Line is a line of a file, with the matches in it as indexes, as FindAllStringSubmatchIndex
+// returns them; context lines have no matches
+type Line struct {
+	Number  int
+	Text    string
+	Matches [][]int
+}
+
+// Printer writes the lines a search finds
+// Break is called between groups of lines that aren't next to each other
+type Printer interface {
+	Match(name string, l Line) error
+	Context(name string, l Line) error
+	Break() error
+}
+
+// Searcher searches files for a regular expression, with before and after lines of context
+type Searcher struct {
+	Re            *regexp.Regexp
+	Before, After int
+
+	// printed is set once any line has been printed, from any file
+	printed bool
+}
+
+// Search searches one file's lines, returning the number of lines that match
+func (s *Searcher) Search(name string, r io.Reader, p Printer) (int, error) {
+	scanner := bufio.NewScanner(r)
+	// lines are as long as they are; minified files can have megabytes on one line
+	scanner.Buffer(make([]byte, 64*1024), math.MaxInt)
+
+	var (
+		before   []Line // up to Before lines before the next match
+		after    int    // lines of context still to print after the last match
+		last     int    // the number of the last line printed, to know when to call Break
+		matching int
+	)
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		matches := s.Re.FindAllStringSubmatchIndex(text, -1)
+		if matches == nil {
+			switch {
+			case after > 0:
+				after--
+				if err := p.Context(name, Line{Number: n, Text: text}); err != nil {
+					return matching, err
+				}
+				last = n
+			case s.Before > 0:
+				if len(before) == s.Before {
+					before = before[1:]
+				}
+				before = append(before, Line{Number: n, Text: text})
+			}
+			continue
+		}
+
+		matching++
+		first := n
+		if len(before) > 0 {
+			first = before[0].Number
+		}
+		// with context, groups are separated within a file and between files, as grep does
+		gap := last > 0 && first > last+1 || last == 0 && s.printed
+		if gap && (s.Before > 0 || s.After > 0) {
+			if err := p.Break(); err != nil {
+				return matching, err
+			}
+		}
+		for _, l := range before {
+			if err := p.Context(name, l); err != nil {
+				return matching, err
+			}
+		}
+		before = before[:0]
+		if err := p.Match(name, Line{n, text, matches}); err != nil {
+			return matching, err
+		}
+		last, after = n, s.After
+		s.printed = true
+	}
+	if err := scanner.Err(); err != nil {
+		return matching, fmt.Errorf("%s: %v", name, err)
+	}
+	return matching, nil
+}
+
+// ANSI escape codes, in the colours GNU grep uses by default
+const (
+	colourFile  = "\x1b[35m"
+	colourLine  = "\x1b[32m"
+	colourMatch = "\x1b[1;31m"
+	colourSep   = "\x1b[36m"
+	colourReset = "\x1b[0m"
+)
+
+// TextPrinter prints as grep -n does: name:line:text for matches, name-line-text for context, and
+// -- between groups
+type TextPrinter struct {
+	W      *bufio.Writer
+	Colour bool
+}
+
+func (t *TextPrinter) paint(colour, s string) string {
+	if !t.Colour {
+		return s
+	}
+	return colour + s + colourReset
+}
+
+func (t *TextPrinter) line(name string, l Line, sep string) error {
+	t.W.WriteString(t.paint(colourFile, name) + t.paint(colourSep, sep) +
+		t.paint(colourLine, strconv.Itoa(l.Number)) + t.paint(colourSep, sep))
+
+	// matches are highlighted; empty matches, which a pattern such as a* finds everywhere, would
+	// only add escape codes
+	pos := 0
+	for _, m := range l.Matches {
+		if m[1] == m[0] {
+			continue
+		}
+		t.W.WriteString(l.Text[pos:m[0]])
+		t.W.WriteString(t.paint(colourMatch, l.Text[m[0]:m[1]]))
+		pos = m[1]
+	}
+	t.W.WriteString(l.Text[pos:])
+	return t.W.WriteByte('\n')
+}
+
+func (t *TextPrinter) Match(name string, l Line) error   { return t.line(name, l, ":") }
+func (t *TextPrinter) Context(name string, l Line) error { return t.line(name, l, "-") }
+
+func (t *TextPrinter) Break() error {
+	_, err := t.W.WriteString(t.paint(colourSep, "--") + "\n")
+	return err
+}
+
+// JSONPrinter prints one JSON object per match, for other programs to read; a line with two
+// matches gives two objects
+// groups holds the pattern's named capture groups, with null for a group that didn't take part
+// in the match; context lines aren't printed
+type JSONPrinter struct {
+	Enc *json.Encoder
+	Re  *regexp.Regexp
+}
+
+// Match is one match, as JSONPrinter prints it
+// Column counts bytes from 1, as grep --column does
+type Match struct {
+	Path   string             `json:"path"`
+	Line   int                `json:"line"`
+	Column int                `json:"column"`
+	Text   string             `json:"text"`
+	Match  string             `json:"match"`
+	Groups map[string]*string `json:"groups,omitempty"`
+}
+
+func (j *JSONPrinter) Match(name string, l Line) error {
+	names := j.Re.SubexpNames()
+	for _, m := range l.Matches {
+		out := Match{Path: name, Line: l.Number, Column: m[0] + 1, Text: l.Text, Match: l.Text[m[0]:m[1]]}
+		for i, group := range names {
+			if group == "" {
+				continue
+			}
+			if out.Groups == nil {
+				out.Groups = make(map[string]*string)
+			}
+			if m[2*i] < 0 {
+				out.Groups[group] = nil
+				continue
+			}
+			v := l.Text[m[2*i]:m[2*i+1]]
+			out.Groups[group] = &v
+		}
+		if err := j.Enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *JSONPrinter) Context(string, Line) error { return nil }
+func (j *JSONPrinter) Break() error               { return nil }
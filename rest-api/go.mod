@@ -0,0 +1,3 @@
+module example/rest-api
+
+go 1.18
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// In the mutexes example, a Container of named counters could only be changed from inside main
+
+// Here we'll expose the same kind of container over HTTP as a small JSON REST API:
+//   GET    /counters                  list every counter
+//   GET    /counters/{name}           read one counter
+//   POST   /counters/{name}/increment increment a counter by n, creating it if needed
+//   POST   /counters/{name}/reset     set a counter back to 0
+//   DELETE /counters/{name}           remove a counter
+
+// Errors are reported as RFC 7807 "problem details" documents, and every counter carries a version
+// number so clients can make conditional updates with If-Match
+
+// counter is a single named value
+// version is bumped on every change, and is exposed to clients as the ETag
+type counter struct {
+	value   int
+	version int
+}
+
+// Container holds a map of counters, guarded by a mutex exactly as in the mutexes example
+type Container struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+}
+
+// Counter is the JSON representation of a counter
+type Counter struct {
+	Name    string `json:"name"`
+	Value   int    `json:"value"`
+	Version int    `json:"version"`
+}
+
+// These errors are returned by the Container methods, and mapped onto HTTP statuses by the handlers
+var (
+	errNotFound           = errors.New("counter not found")
+	errPreconditionFailed = errors.New("version does not match")
+)
+
+// precondition is the parsed value of an If-Match header
+// a nil precondition means the request is unconditional
+type precondition struct {
+	any     bool
+	version int
+}
+
+// check reports whether a counter (nil if it doesn't exist) satisfies the precondition
+func (p *precondition) check(c *counter) error {
+	if p == nil {
+		return nil
+	}
+	if c == nil || (!p.any && c.version != p.version) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+func (c *Container) list() []Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]Counter, 0, len(c.counters))
+	for name, ctr := range c.counters {
+		out = append(out, Counter{name, ctr.value, ctr.version})
+	}
+
+	// map iteration order is random, so sort for a stable response
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (c *Container) get(name string) (Counter, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctr, ok := c.counters[name]
+	if !ok {
+		return Counter{}, errNotFound
+	}
+	return Counter{name, ctr.value, ctr.version}, nil
+}
+
+// inc increments a counter by n, creating it if it doesn't exist yet
+// the precondition is checked under the same lock as the update, so two clients that read the same
+// version can't both succeed
+func (c *Container) inc(name string, n int, p *precondition) (Counter, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctr := c.counters[name]
+	if err := p.check(ctr); err != nil {
+		return Counter{}, err
+	}
+	if ctr == nil {
+		ctr = &counter{}
+		c.counters[name] = ctr
+	}
+
+	ctr.value += n
+	ctr.version++
+	return Counter{name, ctr.value, ctr.version}, nil
+}
+
+func (c *Container) reset(name string, p *precondition) (Counter, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctr, ok := c.counters[name]
+	if !ok {
+		return Counter{}, errNotFound
+	}
+	if err := p.check(ctr); err != nil {
+		return Counter{}, err
+	}
+
+	ctr.value = 0
+	ctr.version++
+	return Counter{name, ctr.value, ctr.version}, nil
+}
+
+func (c *Container) delete(name string, p *precondition) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctr, ok := c.counters[name]
+	if !ok {
+		return errNotFound
+	}
+	if err := p.check(ctr); err != nil {
+		return err
+	}
+
+	delete(c.counters, name)
+	return nil
+}
+
+// Problem is an RFC 7807 problem details object
+// InvalidParams is an extension member listing validation failures, as in the RFC's own example
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// InvalidParam describes why one input was rejected
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// writeProblem sends a problem+json response
+// "about:blank" is the RFC's type for problems that need no further explanation than the status
+func writeProblem(w http.ResponseWriter, req *http.Request, status int, detail string, params ...InvalidParam) {
+	p := Problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        detail,
+		Instance:      req.URL.Path,
+		InvalidParams: params,
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError maps the Container's errors onto problem responses
+func writeError(w http.ResponseWriter, req *http.Request, err error) {
+	switch err {
+	case errNotFound:
+		writeProblem(w, req, http.StatusNotFound, err.Error())
+	case errPreconditionFailed:
+		writeProblem(w, req, http.StatusPreconditionFailed,
+			"the counter has been modified since it was read; fetch it again and retry")
+	default:
+		writeProblem(w, req, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// writeJSON sends v with the given status
+// a Counter also gets an ETag holding its version, ready to be sent back in If-Match
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if c, ok := v.(Counter); ok {
+		w.Header().Set("ETag", etag(c.Version))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseIfMatch reads the If-Match header
+// only a single strong entity tag or * is accepted, since each counter has exactly one version
+func parseIfMatch(req *http.Request) (*precondition, error) {
+	h := strings.TrimSpace(req.Header.Get("If-Match"))
+	if h == "" {
+		return nil, nil
+	}
+	if h == "*" {
+		return &precondition{any: true}, nil
+	}
+
+	if len(h) < 2 || h[0] != '"' || h[len(h)-1] != '"' {
+		return nil, errors.New(`If-Match must be a quoted version such as "3", or *`)
+	}
+	v, err := strconv.Atoi(h[1 : len(h)-1])
+	if err != nil || v < 0 {
+		return nil, errors.New("If-Match must hold a non-negative version number")
+	}
+
+	return &precondition{version: v}, nil
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// maxIncrement bounds a single increment, so a client can't overflow a counter in one request
+const maxIncrement = 1_000_000
+
+// incrementRequest is the body of POST /counters/{name}/increment
+// N is a pointer so that a missing field can be told apart from an explicit 0
+type incrementRequest struct {
+	N *int `json:"n"`
+}
+
+// decodeIncrement reads and validates an increment request body
+// it returns the HTTP status and the problems found if the body is unacceptable
+func decodeIncrement(w http.ResponseWriter, req *http.Request) (int, int, string, []InvalidParam) {
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || mt != "application/json" {
+			return 0, http.StatusUnsupportedMediaType, "request body must be application/json", nil
+		}
+	}
+
+	// cap the body size, and reject fields we don't know about rather than silently ignoring them
+	req.Body = http.MaxBytesReader(w, req.Body, 1<<10)
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+
+	var body incrementRequest
+	if err := dec.Decode(&body); err != nil {
+		if err == io.EOF {
+			return 0, http.StatusBadRequest, "request body is empty", nil
+		}
+		return 0, http.StatusBadRequest, "malformed JSON: " + err.Error(), nil
+	}
+	if dec.More() {
+		return 0, http.StatusBadRequest, "request body must hold a single JSON object", nil
+	}
+
+	switch {
+	case body.N == nil:
+		return 0, http.StatusUnprocessableEntity, "", []InvalidParam{{"n", "is required"}}
+	case *body.N < 1 || *body.N > maxIncrement:
+		return 0, http.StatusUnprocessableEntity, "",
+			[]InvalidParam{{"n", fmt.Sprintf("must be between 1 and %d", maxIncrement)}}
+	}
+
+	return *body.N, 0, "", nil
+}
+
+// API serves the counters in a Container
+type API struct {
+	c *Container
+}
+
+// ServeHTTP routes /counters and /counters/... requests
+// we split the path by hand, since the standard ServeMux only matches on prefixes
+func (a *API) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rest := strings.TrimPrefix(req.URL.Path, "/counters")
+	rest = strings.Trim(rest, "/")
+
+	if rest == "" {
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeProblem(w, req, http.StatusMethodNotAllowed, "")
+			return
+		}
+		writeJSON(w, http.StatusOK, a.c.list())
+		return
+	}
+
+	parts := strings.Split(rest, "/")
+	name := parts[0]
+	if !validName.MatchString(name) {
+		writeProblem(w, req, http.StatusBadRequest, "", InvalidParam{"name",
+			"must be 1 to 64 lowercase letters, digits, underscores or hyphens"})
+		return
+	}
+
+	p, err := parseIfMatch(req)
+	if err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		a.counter(w, req, name, p)
+	case len(parts) == 2 && parts[1] == "increment":
+		a.increment(w, req, name, p)
+	case len(parts) == 2 && parts[1] == "reset":
+		a.reset(w, req, name, p)
+	default:
+		writeProblem(w, req, http.StatusNotFound, "no such resource")
+	}
+}
+
+func (a *API) counter(w http.ResponseWriter, req *http.Request, name string, p *precondition) {
+	switch req.Method {
+	case http.MethodGet:
+		c, err := a.c.get(name)
+		if err != nil {
+			writeError(w, req, err)
+			return
+		}
+
+		// let clients poll cheaply with If-None-Match
+		if req.Header.Get("If-None-Match") == etag(c.Version) {
+			w.Header().Set("ETag", etag(c.Version))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	case http.MethodDelete:
+		if err := a.c.delete(name, p); err != nil {
+			writeError(w, req, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeProblem(w, req, http.StatusMethodNotAllowed, "")
+	}
+}
+
+func (a *API) increment(w http.ResponseWriter, req *http.Request, name string, p *precondition) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeProblem(w, req, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	n, status, detail, params := decodeIncrement(w, req)
+	if status != 0 {
+		writeProblem(w, req, status, detail, params...)
+		return
+	}
+
+	c, err := a.c.inc(name, n, p)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (a *API) reset(w http.ResponseWriter, req *http.Request, name string, p *precondition) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeProblem(w, req, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	c, err := a.c.reset(name, p)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func main() {
+	// start with the same counters as the mutexes example
+	c := &Container{
+		counters: map[string]*counter{"a": {}, "b": {}},
+	}
+
+	api := &API{c}
+	http.Handle("/counters", api)
+	http.Handle("/counters/", api)
+
+	if err := http.ListenAndServe(":8094", nil); err != nil {
+		panic(err)
+	}
+
+	// Run the server
+	// >> go run . &
+
+	// List the counters, then increment one
+	// >> curl localhost:8094/counters
+	// >> curl -i -H 'Content-Type: application/json' -d '{"n": 5}' localhost:8094/counters/a/increment
+
+	// The response carries ETag: "1"; an update conditional on that version succeeds once
+	// >> curl -i -H 'Content-Type: application/json' -H 'If-Match: "1"' -d '{"n": 1}' localhost:8094/counters/a/increment
+
+	// and fails with 412 Precondition Failed if repeated, since the version is now 2
+	// >> curl -i -H 'Content-Type: application/json' -H 'If-Match: "1"' -d '{"n": 1}' localhost:8094/counters/a/increment
+
+	// Invalid input is rejected with a problem+json body
+	// >> curl -i -H 'Content-Type: application/json' -d '{"n": -1}' localhost:8094/counters/a/increment
+
+	// Reset and delete
+	// >> curl -X POST localhost:8094/counters/a/reset
+	// >> curl -i -X DELETE localhost:8094/counters/a
+}
@@ -0,0 +1,3 @@
+module example/resumable-downloads
+
+go 1.18
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The http-clients example only prints the first few lines of a response, but a common job for an
+// HTTP client is downloading a large file
+
+// Large downloads get interrupted, so here we'll build a downloader that:
+//   streams the body straight to disk, showing progress with the rate and estimated time left
+//   keeps a partial download around when interrupted, and resumes it with a Range request
+//   checks the finished file against an expected SHA256 digest, like the one computed in the
+//   sha256-hashing example
+
+// The partial file is kept next to the destination with a .part suffix, and a small .part.json
+// file remembers the validator (ETag or Last-Modified) of the resource it came from
+// On resume, the validator goes in an If-Range header, so if the resource has changed in the
+// meantime the server sends the whole new file instead of a mismatched remainder
+
+// partState is stored alongside a partial download
+type partState struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validator returns the value to send in If-Range
+// weak ETags can't be used with If-Range, so we fall back to Last-Modified for those
+func (s partState) validator() string {
+	if s.ETag != "" && !strings.HasPrefix(s.ETag, "W/") {
+		return s.ETag
+	}
+	return s.LastModified
+}
+
+func loadState(file string) (partState, bool) {
+	var s partState
+	b, err := os.ReadFile(file)
+	if err != nil || json.Unmarshal(b, &s) != nil {
+		return partState{}, false
+	}
+	return s, true
+}
+
+func saveState(file string, s partState) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+// Progress counts bytes as they're written and renders a progress bar
+type Progress struct {
+	mu      sync.Mutex
+	total   int64 // -1 if unknown
+	done    int64
+	resumed int64
+	start   time.Time
+}
+
+func (p *Progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.done += int64(len(b))
+	p.mu.Unlock()
+	return len(b), nil
+}
+
+// humanBytes formats a byte count with binary units
+func humanBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// String renders the bar, for example:
+// [=========>          ]  48%  12.0 MiB / 25.0 MiB  3.1 MiB/s  ETA 4s
+func (p *Progress) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the rate only counts bytes fetched in this session, not those resumed from disk
+	elapsed := time.Since(p.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(p.done-p.resumed) / elapsed
+	}
+
+	if p.total <= 0 {
+		return fmt.Sprintf("%s  %s/s", humanBytes(float64(p.done)), humanBytes(rate))
+	}
+
+	const width = 20
+	frac := float64(p.done) / float64(p.total)
+	if frac > 1 {
+		frac = 1
+	}
+	filled := int(frac * width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+
+	eta := "--"
+	if rate > 0 {
+		left := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+		eta = left.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("[%s] %3.0f%%  %s / %s  %s/s  ETA %s", bar, frac*100,
+		humanBytes(float64(p.done)), humanBytes(float64(p.total)), humanBytes(rate), eta)
+}
+
+// render redraws the progress bar on stderr until ctx is done
+// \r returns to the start of the line, and \033[K clears whatever was left of the previous bar
+func (p *Progress) render(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(os.Stderr, "\r%s\033[K\n", p)
+			return
+		case <-ticker.C:
+			fmt.Fprintf(os.Stderr, "\r%s\033[K", p)
+		}
+	}
+}
+
+// Downloader fetches URLs to files, resuming partial downloads
+type Downloader struct {
+	Client *http.Client
+
+	// Quiet turns off the progress bar
+	Quiet bool
+}
+
+// errChecksum is returned when the finished file doesn't match the expected digest
+var errChecksum = errors.New("sha256 mismatch")
+
+// errRestart means the partial file can't be resumed and the download should start over
+var errRestart = errors.New("restart download")
+
+// Download fetches url into dest, and if wantSum is non-empty verifies the SHA256 of the result
+func (d *Downloader) Download(ctx context.Context, url, dest, wantSum string) error {
+	part := dest + ".part"
+	statePath := part + ".json"
+
+	for {
+		err := d.attempt(ctx, url, part, statePath)
+		if err == errRestart {
+			os.Remove(part)
+			os.Remove(statePath)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+
+	if wantSum != "" {
+		got, err := fileSum(part)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(got, wantSum) {
+			// a corrupt file can't be fixed by resuming, so throw it away
+			os.Remove(part)
+			os.Remove(statePath)
+			return fmt.Errorf("%w: got %s, want %s", errChecksum, got, wantSum)
+		}
+	}
+
+	os.Remove(statePath)
+	return os.Rename(part, dest)
+}
+
+// attempt makes one request, appending to the partial file if the server supports it
+func (d *Downloader) attempt(ctx context.Context, url, part, statePath string) error {
+	var offset int64
+	state, haveState := loadState(statePath)
+	if fi, err := os.Stat(part); err == nil && haveState && state.URL == url && state.validator() != "" {
+		offset = fi.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", state.validator())
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		// make sure the server is sending the range we asked for
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			return errRestart
+		}
+		resp.ContentLength = total - offset
+		flags |= os.O_APPEND
+		fmt.Fprintf(os.Stderr, "resuming at %s\n", humanBytes(float64(offset)))
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// we already have every byte; the server says so by rejecting a range past the end
+		if _, total, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && total == offset {
+			return nil
+		}
+		return errRestart
+	case resp.StatusCode == http.StatusOK:
+		// a full response, either because this is a fresh download or because the resource
+		// changed and If-Range didn't match
+		offset = 0
+		flags |= os.O_TRUNC
+	default:
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	// remember where this data came from before writing any of it
+	newState := partState{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	if resp.StatusCode == http.StatusPartialContent {
+		newState = state
+	}
+	if err := saveState(statePath, newState); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	p := &Progress{total: total, done: offset, resumed: offset, start: time.Now()}
+
+	// the progress counter is always updated, since it's also how we spot a truncated body
+	w := io.MultiWriter(f, p)
+	if !d.Quiet {
+		rctx, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			p.render(rctx, 200*time.Millisecond)
+			close(done)
+		}()
+		defer func() {
+			stop()
+			<-done
+		}()
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+	if total >= 0 && p.done != total {
+		return fmt.Errorf("download ended early at %d of %d bytes", p.done, total)
+	}
+
+	return f.Close()
+}
+
+// parseContentRange reads the start and complete length from "bytes 100-199/200" or "bytes */200"
+func parseContentRange(h string) (start, total int64, err error) {
+	h = strings.TrimPrefix(h, "bytes ")
+	rng, size, ok := strings.Cut(h, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed Content-Range %q", h)
+	}
+	if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("Content-Range %q has no complete length", h)
+	}
+	if rng == "*" {
+		return -1, total, nil
+	}
+	first, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed Content-Range %q", h)
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	return start, total, err
+}
+
+// fileSum computes the SHA256 of a file, as in the sha256-hashing example but streaming the file
+// through the hash rather than hashing a single string
+func fileSum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func main() {
+	out := flag.String("o", "", "output file (default: the last element of the URL path)")
+	sum := flag.String("sha256", "", "expected SHA256 digest of the file, in hex")
+	quiet := flag.Bool("q", false, "don't show a progress bar")
+	retries := flag.Int("retries", 3, "how many times to resume after a network error")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: resumable-downloads [-o file] [-sha256 digest] URL")
+		os.Exit(2)
+	}
+	url := flag.Arg(0)
+
+	dest := *out
+	if dest == "" {
+		dest = path.Base(strings.SplitN(url, "?", 2)[0])
+		if dest == "/" || dest == "." || dest == "" {
+			dest = "index.html"
+		}
+	}
+	want := strings.TrimPrefix(strings.TrimSpace(*sum), "sha256:")
+
+	// Ctrl+C cancels the request, leaving the partial file in place to resume from later
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	d := &Downloader{Client: &http.Client{}, Quiet: *quiet}
+
+	var err error
+	for attempt := 0; attempt <= *retries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintln(os.Stderr, "retrying:", err)
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		err = d.Download(ctx, url, dest, want)
+		if err == nil || ctx.Err() != nil || errors.Is(err, errChecksum) {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "interrupted; run the same command again to resume")
+		os.Exit(130)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	fmt.Println("saved", dest)
+
+	// Download a file, checking its digest
+	// >> go run . -sha256 <digest> https://go.dev/dl/go1.18.linux-amd64.tar.gz
+
+	// Hit Ctrl+C part way through, then run the same command again; the download picks up where
+	// it left off
+}
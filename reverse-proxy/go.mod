@@ -0,0 +1,3 @@
+module example/reverse-proxy
+
+go 1.18
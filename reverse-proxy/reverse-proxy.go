@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// When several copies of a service such as the http-servers example are running, something has to
+// sit in front of them and decide which copy handles each request
+
+// Here we'll build a small reverse proxy that:
+//   balances requests with round-robin, least-connections or consistent hashing
+//   actively health-checks every backend, ejecting it when it fails and reinstating it when it
+//   recovers
+//   retries idempotent requests on another backend when one fails
+//   rewrites the X-Forwarded-* headers so backends can see who the real client was
+
+// Backend is one upstream server
+type Backend struct {
+	URL *url.URL
+
+	// healthy is 1 while the backend is in rotation
+	healthy int32
+
+	// active counts in-flight requests, for the least-connections strategy
+	active int64
+
+	// consecutive health check results, only touched by the health checking goroutine
+	fails, passes int
+}
+
+// Healthy reports whether the backend is currently in rotation
+func (b *Backend) Healthy() bool {
+	return atomic.LoadInt32(&b.healthy) == 1
+}
+
+// Strategy picks a backend for a request from those that are eligible
+// candidates is never empty
+type Strategy interface {
+	Pick(req *http.Request, candidates []*Backend) *Backend
+}
+
+// RoundRobin hands requests to each backend in turn
+type RoundRobin struct {
+	next uint64
+}
+
+func (rr *RoundRobin) Pick(req *http.Request, candidates []*Backend) *Backend {
+	n := atomic.AddUint64(&rr.next, 1)
+	return candidates[(n-1)%uint64(len(candidates))]
+}
+
+// LeastConnections picks the backend with the fewest in-flight requests, which copes better than
+// round-robin when some requests are much slower than others
+type LeastConnections struct{}
+
+func (LeastConnections) Pick(req *http.Request, candidates []*Backend) *Backend {
+	best := candidates[0]
+	for _, b := range candidates[1:] {
+		if atomic.LoadInt64(&b.active) < atomic.LoadInt64(&best.active) {
+			best = b
+		}
+	}
+	return best
+}
+
+// ConsistentHash sends requests with the same key to the same backend, and when a backend leaves
+// the rotation only the keys that were on it move elsewhere
+// each backend is placed on a hash ring many times ("virtual nodes") to spread keys evenly
+type ConsistentHash struct {
+	// Key extracts the hashing key from a request, such as a client address or session header
+	Key func(req *http.Request) string
+
+	ring   []uint32
+	owners map[uint32]*Backend
+}
+
+// NewConsistentHash builds a ring over all of the backends, healthy or not
+// unhealthy backends are skipped at lookup time, so the ring never needs rebuilding
+func NewConsistentHash(backends []*Backend, replicas int, key func(*http.Request) string) *ConsistentHash {
+	ch := &ConsistentHash{Key: key, owners: make(map[uint32]*Backend)}
+
+	for _, b := range backends {
+		for i := 0; i < replicas; i++ {
+			h := hash32(b.URL.Host + "#" + strconv.Itoa(i))
+			ch.ring = append(ch.ring, h)
+			ch.owners[h] = b
+		}
+	}
+	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i] < ch.ring[j] })
+
+	return ch
+}
+
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func (ch *ConsistentHash) Pick(req *http.Request, candidates []*Backend) *Backend {
+	eligible := make(map[*Backend]bool, len(candidates))
+	for _, b := range candidates {
+		eligible[b] = true
+	}
+
+	// find the first point on the ring at or after the key's hash, then walk clockwise until we
+	// reach a backend that's eligible
+	h := hash32(ch.Key(req))
+	start := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i] >= h })
+	for i := 0; i < len(ch.ring); i++ {
+		b := ch.owners[ch.ring[(start+i)%len(ch.ring)]]
+		if eligible[b] {
+			return b
+		}
+	}
+
+	return candidates[0]
+}
+
+// clientIP is the default consistent hashing key
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// HealthCheck configures active health checking
+type HealthCheck struct {
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// a backend is ejected after Unhealthy consecutive failures, and reinstated after Healthy
+	// consecutive successes
+	Unhealthy int
+	Healthy   int
+}
+
+// Proxy is a load-balancing reverse proxy
+type Proxy struct {
+	Backends []*Backend
+	Strategy Strategy
+	Health   HealthCheck
+
+	// MaxRetries is how many other backends an idempotent request may be retried on
+	MaxRetries int
+
+	// TrustForwarded keeps X-Forwarded-For values set by a previous proxy, instead of replacing
+	// them; only enable it when the proxy itself sits behind another trusted proxy
+	TrustForwarded bool
+
+	Transport http.RoundTripper
+}
+
+// NewProxy creates a proxy over the given backend URLs
+// backends start out healthy, so traffic flows before the first round of checks completes
+func NewProxy(urls []string, strategy Strategy) (*Proxy, error) {
+	p := &Proxy{
+		Strategy: strategy,
+		Health: HealthCheck{
+			Path:      "/healthz",
+			Interval:  2 * time.Second,
+			Timeout:   time.Second,
+			Unhealthy: 2,
+			Healthy:   2,
+		},
+		MaxRetries: 2,
+		Transport: &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: 2 * time.Second}).DialContext,
+			MaxIdleConnsPerHost: 32,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("backend %q: scheme must be http or https", raw)
+		}
+		p.Backends = append(p.Backends, &Backend{URL: u, healthy: 1})
+	}
+	if len(p.Backends) == 0 {
+		return nil, errors.New("no backends")
+	}
+
+	return p, nil
+}
+
+// RunHealthChecks probes every backend until ctx is done
+func (p *Proxy) RunHealthChecks(ctx context.Context) {
+	client := &http.Client{Transport: p.Transport, Timeout: p.Health.Timeout}
+	ticker := time.NewTicker(p.Health.Interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, b := range p.Backends {
+			wg.Add(1)
+			go func(b *Backend) {
+				defer wg.Done()
+				p.check(ctx, client, b)
+			}(b)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check probes one backend and updates its state
+func (p *Proxy) check(ctx context.Context, client *http.Client, b *Backend) {
+	// the health path is under the backend's own path, as proxied requests are
+	u := *b.URL
+	u.Path = singleJoiningSlash(b.URL.Path, p.Health.Path)
+	u.RawPath = ""
+
+	ok := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			ok = resp.StatusCode >= 200 && resp.StatusCode < 300
+			if !ok {
+				err = errors.New(resp.Status)
+			}
+		}
+	}
+
+	if ok {
+		b.fails = 0
+		b.passes++
+		if !b.Healthy() && b.passes >= p.Health.Healthy {
+			atomic.StoreInt32(&b.healthy, 1)
+			fmt.Println("proxy: reinstated", b.URL)
+		}
+		return
+	}
+
+	b.passes = 0
+	b.fails++
+	if b.Healthy() && b.fails >= p.Health.Unhealthy {
+		atomic.StoreInt32(&b.healthy, 0)
+		fmt.Println("proxy: ejected", b.URL, err)
+	}
+}
+
+// candidates returns the healthy backends that haven't been tried yet for this request
+func (p *Proxy) candidates(tried map[*Backend]bool) []*Backend {
+	var out []*Backend
+	for _, b := range p.Backends {
+		if b.Healthy() && !tried[b] {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// idempotent reports whether a request can safely be sent more than once
+// a POST carrying an Idempotency-Key is treated as idempotent, since the backend promises to
+// deduplicate it
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// Hop-by-hop headers apply to a single connection and must not be forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	// the Connection header can name further headers that are hop-by-hop for this connection
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// maxRetryBody is the largest request body we'll buffer in order to be able to replay it
+const maxRetryBody = 1 << 20
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	retries := 0
+	if idempotent(req) {
+		retries = p.MaxRetries
+	}
+
+	// to send a request body more than once we need a copy of it
+	// large or unknown-length bodies are streamed through once instead
+	var body []byte
+	if retries > 0 && req.Body != nil && req.Body != http.NoBody {
+		if req.ContentLength < 0 || req.ContentLength > maxRetryBody {
+			retries = 0
+		} else {
+			var err error
+			body, err = io.ReadAll(req.Body)
+			if err != nil {
+				http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	tried := make(map[*Backend]bool)
+	var lastErr error
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		candidates := p.candidates(tried)
+		if len(candidates) == 0 {
+			break
+		}
+		b := p.Strategy.Pick(req, candidates)
+		tried[b] = true
+
+		out := p.outgoing(req, b)
+		if body != nil {
+			out.Body = io.NopCloser(bytes.NewReader(body))
+			out.ContentLength = int64(len(body))
+		}
+
+		atomic.AddInt64(&b.active, 1)
+		resp, err := p.Transport.RoundTrip(out)
+		if err == nil && retries > attempt && retryableStatus(resp.StatusCode) {
+			// the backend answered, but says it can't serve the request right now
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			err = fmt.Errorf("%s responded %s", b.URL.Host, resp.Status)
+			resp = nil
+		}
+		if err != nil {
+			atomic.AddInt64(&b.active, -1)
+			lastErr = err
+
+			// a cancelled client isn't the backend's fault, and there's nobody to retry for
+			if req.Context().Err() != nil {
+				return
+			}
+			fmt.Println("proxy: attempt", attempt+1, "failed:", err)
+			continue
+		}
+
+		copyResponse(w, resp)
+		resp.Body.Close()
+		atomic.AddInt64(&b.active, -1)
+		return
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no healthy backends")
+	}
+	http.Error(w, "bad gateway: "+lastErr.Error(), http.StatusBadGateway)
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
+// outgoing builds the request sent to a backend
+func (p *Proxy) outgoing(req *http.Request, b *Backend) *http.Request {
+	out := req.Clone(req.Context())
+	out.RequestURI = ""
+	out.URL.Scheme = b.URL.Scheme
+	out.URL.Host = b.URL.Host
+	out.URL.Path = singleJoiningSlash(b.URL.Path, req.URL.Path)
+	out.Host = b.URL.Host
+	out.Close = false
+
+	removeHopHeaders(out.Header)
+
+	// X-Forwarded-For lists the client and each proxy in turn
+	// unless a previous hop is trusted, any value supplied by the client could be forged, so
+	// it's replaced rather than appended to
+	ip := clientIP(req)
+	if prior := req.Header.Values("X-Forwarded-For"); p.TrustForwarded && len(prior) > 0 {
+		ip = strings.Join(prior, ", ") + ", " + ip
+	}
+	out.Header.Set("X-Forwarded-For", ip)
+
+	// X-Forwarded-Host and -Proto describe the request as the client made it
+	if !p.TrustForwarded || out.Header.Get("X-Forwarded-Host") == "" {
+		out.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	if !p.TrustForwarded || out.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		out.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	return out
+}
+
+func singleJoiningSlash(a, b string) string {
+	switch {
+	case strings.HasSuffix(a, "/") && strings.HasPrefix(b, "/"):
+		return a + b[1:]
+	case !strings.HasSuffix(a, "/") && !strings.HasPrefix(b, "/"):
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// copyResponse streams a backend response to the client, flushing as data arrives so streamed
+// responses such as server-sent events aren't held back
+func copyResponse(w http.ResponseWriter, resp *http.Response) {
+	removeHopHeaders(resp.Header)
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// startBackend runs a copy of the http-servers example on addr, so there's something to balance
+// across; it also answers health checks, and can be told to start failing them
+func startBackend(addr string) {
+	var failing int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "hello from %s (forwarded for %s)\n", addr, req.Header.Get("X-Forwarded-For"))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/toggle", func(w http.ResponseWriter, req *http.Request) {
+		for {
+			old := atomic.LoadInt32(&failing)
+			if atomic.CompareAndSwapInt32(&failing, old, 1-old) {
+				fmt.Fprintln(w, "failing:", old == 0)
+				return
+			}
+		}
+	})
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintln(os.Stderr, "backend:", err)
+		}
+	}()
+}
+
+func main() {
+	strategy := flag.String("strategy", "round-robin", "round-robin, least-connections or consistent-hash")
+	backends := flag.String("backends", "", "comma-separated backend URLs; starts three local demo backends if empty")
+	flag.Parse()
+
+	urls := strings.Split(*backends, ",")
+	if *backends == "" {
+		urls = nil
+		for _, addr := range []string{"127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003"} {
+			startBackend(addr)
+			urls = append(urls, "http://"+addr)
+		}
+	}
+
+	proxy, err := NewProxy(urls, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch *strategy {
+	case "round-robin":
+		proxy.Strategy = &RoundRobin{}
+	case "least-connections":
+		proxy.Strategy = LeastConnections{}
+	case "consistent-hash":
+		proxy.Strategy = NewConsistentHash(proxy.Backends, 100, clientIP)
+	default:
+		fmt.Fprintln(os.Stderr, "unknown strategy", *strategy)
+		os.Exit(1)
+	}
+
+	go proxy.RunHealthChecks(context.Background())
+
+	if err := http.ListenAndServe(":8095", proxy); err != nil {
+		panic(err)
+	}
+
+	// Run the proxy with its demo backends
+	// >> go run . &
+
+	// Requests are spread across the three backends
+	// >> for i in 1 2 3 4; do curl localhost:8095/hello; done
+
+	// Make one backend fail its health checks; after two failed checks it's ejected, and after
+	// toggling it back it's reinstated
+	// >> curl 127.0.0.1:9002/toggle
+
+	// With consistent hashing, a client keeps hitting the same backend
+	// >> go run . -strategy consistent-hash &
+}
@@ -0,0 +1,3 @@
+module example/server-sent-events
+
+go 1.18
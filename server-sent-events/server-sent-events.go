@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server-Sent Events (SSE) let a server push a stream of text events to a browser over a single,
+// long-lived HTTP response
+
+// Browsers consume them with the EventSource API, which reconnects automatically and sends the id of
+// the last event it saw in the Last-Event-ID header so the server can replay anything missed
+
+// Here we'll build an SSE broker that keeps a bounded history of events, and feed it from a ticker
+
+// Event is a single server-sent event
+// ID is assigned by the broker when the event is published
+type Event struct {
+	ID    uint64
+	Type  string
+	Data  string
+	Retry time.Duration
+}
+
+// write serialises an event in the text/event-stream wire format
+// multi-line data must be split across several data: fields
+func (e Event) write(w http.ResponseWriter) error {
+	var b strings.Builder
+
+	if e.ID != 0 {
+		fmt.Fprintf(&b, "id: %d\n", e.ID)
+	}
+	if e.Type != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Type)
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
+
+// Broker fans published events out to every connected client
+// It keeps the most recent events in a ring buffer so reconnecting clients can catch up
+type Broker struct {
+	// Retry is the reconnection delay hint sent to each client when it connects
+	Retry time.Duration
+
+	// Heartbeat is how often a comment line is written to idle connections to keep proxies from
+	// closing them; zero disables heartbeats
+	Heartbeat time.Duration
+
+	mu      sync.Mutex
+	nextID  uint64
+	history []Event
+	start   int
+	size    int
+	clients map[chan Event]struct{}
+}
+
+// NewBroker creates a broker that remembers the last historySize events
+func NewBroker(historySize int) *Broker {
+	if historySize < 1 {
+		historySize = 1
+	}
+
+	return &Broker{
+		Retry:     3 * time.Second,
+		Heartbeat: 15 * time.Second,
+		nextID:    1,
+		history:   make([]Event, historySize),
+		clients:   make(map[chan Event]struct{}),
+	}
+}
+
+// Publish assigns the next id to an event, records it in the history and sends it to every client
+// A client whose buffer is full is disconnected rather than blocking the publisher; its browser will
+// reconnect with Last-Event-ID and replay what it missed from the history
+func (b *Broker) Publish(eventType, data string) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e := Event{ID: b.nextID, Type: eventType, Data: data}
+	b.nextID++
+
+	// overwrite the oldest entry once the ring buffer is full
+	if b.size < len(b.history) {
+		b.history[(b.start+b.size)%len(b.history)] = e
+		b.size++
+	} else {
+		b.history[b.start] = e
+		b.start = (b.start + 1) % len(b.history)
+	}
+
+	for ch := range b.clients {
+		select {
+		case ch <- e:
+		default:
+			delete(b.clients, ch)
+			close(ch)
+		}
+	}
+
+	return e
+}
+
+// subscribe registers a new client and returns the events it should replay, i.e. everything in the
+// history after lastID
+// registering and snapshotting the history under the same lock means no event can fall between the
+// replay and the live stream
+func (b *Broker) subscribe(lastID uint64) (chan Event, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	if lastID != 0 {
+		for i := 0; i < b.size; i++ {
+			e := b.history[(b.start+i)%len(b.history)]
+			if e.ID > lastID {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	ch := make(chan Event, 16)
+	b.clients[ch] = struct{}{}
+
+	return ch, replay
+}
+
+// unsubscribe removes a client, unless Publish already dropped it
+func (b *Broker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.clients[ch]; ok {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// ServeHTTP makes the broker usable as a handler
+func (b *Broker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// streaming relies on flushing each event as soon as it's written
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// EventSource sends the Last-Event-ID header when it reconnects
+	// we also accept a query parameter so a fresh page load can resume where it left off
+	lastID := req.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = req.URL.Query().Get("lastEventId")
+	}
+	var since uint64
+	if lastID != "" {
+		var err error
+		since, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ch, replay := b.subscribe(since)
+	defer b.unsubscribe(ch)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// the retry hint tells the browser how long to wait before reconnecting
+	if b.Retry > 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", b.Retry.Milliseconds())
+	}
+	for _, e := range replay {
+		if err := e.write(w); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	var heartbeat <-chan time.Time
+	if b.Heartbeat > 0 {
+		t := time.NewTicker(b.Heartbeat)
+		defer t.Stop()
+		heartbeat = t.C
+	}
+
+	// stream until the client goes away, or until the broker drops us for being too slow
+	ctx := req.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := e.write(w); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat:
+			// lines starting with a colon are comments and are ignored by EventSource
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// A tiny page that subscribes to /ticks, so the stream can be watched in a browser
+const page = `<!DOCTYPE html>
+<html>
+<body>
+<ul id="ticks"></ul>
+<script>
+const source = new EventSource("/ticks");
+source.addEventListener("tick", (e) => {
+	const li = document.createElement("li");
+	li.textContent = e.lastEventId + ": " + e.data;
+	document.getElementById("ticks").appendChild(li);
+});
+</script>
+</body>
+</html>
+`
+
+func main() {
+	// the broker remembers the last 100 ticks for clients that reconnect
+	broker := NewBroker(100)
+
+	// as in the tickers example, a ticker delivers the current time on its channel at regular
+	// intervals; here every tick is published to all connected browsers
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	go func() {
+		for t := range ticker.C {
+			broker.Publish("tick", t.Format(time.RFC3339Nano))
+		}
+	}()
+
+	http.Handle("/ticks", broker)
+	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	})
+
+	if err := http.ListenAndServe(":8092", nil); err != nil {
+		panic(err)
+	}
+
+	// Run the server
+	// >> go run . &
+
+	// Stream the ticks; -N turns off curl's buffering so events show up as they arrive
+	// >> curl -N localhost:8092/ticks
+
+	// Resume after event 5, replaying everything since from the history
+	// >> curl -N -H "Last-Event-ID: 5" localhost:8092/ticks
+
+	// Or open http://localhost:8092/ in any number of browsers
+}
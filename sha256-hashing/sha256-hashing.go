@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 )
 
@@ -35,4 +36,36 @@ func main() {
 
 	// You can compute other hashes using a similar pattern to the one shown above
 	// For example, to compute SHA512 hashes, we import crypto/sha512 and use sha512.New()
+
+	// Hashing JSON needs more care: two documents that mean the same thing can be written with
+	// different whitespace, member order, escapes and number spellings, and each spelling hashes
+	// differently
+	a := []byte(`{"id": 7, "items": ["apple", "pear"], "total": 10.50}`)
+	b := []byte(`{"total":1.05e1,"items":["\u0061pple","pear"],"id":7.0}`)
+	fmt.Printf("%x\n%x\n", sha256.Sum256(a), sha256.Sum256(b))
+
+	// so hash a canonical form of the document instead
+	// the canonical-json example implements RFC 8785 for that, but it's a separate main module
+	// and can't be imported here: copy its Canonicalize (or move it into a library package) and
+	// hash what it returns
+	// decoding and re-encoding with encoding/json is a rough stand-in that's enough for these two
+	// documents: map keys come out sorted, and numbers and strings get one spelling each
+	// unlike Canonicalize, it doesn't reject duplicate members or invalid strings, and it escapes
+	// <, > and &, so it isn't RFC 8785
+	for _, doc := range [][]byte{a, b} {
+		c, err := normalize(doc)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s %x\n", c, sha256.Sum256(c))
+	}
+}
+
+// normalize decodes a JSON document and encodes it again
+func normalize(doc []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
 }
@@ -0,0 +1,3 @@
+module example/struct-generation
+
+go 1.18
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// This file infers a shape from sample documents
+// every sample is observed into the same shape, so the shape describes everything seen in any of
+// them: a field missing from some objects is optional, and the elements of an array are merged
+// into a single element shape
+
+// kind is a bit set of the scalar types seen at one place in the samples
+type kind uint8
+
+const (
+	kindNull kind = 1 << iota
+	kindBool
+	kindInt
+	kindFloat
+	kindString
+)
+
+// shape is everything observed at one place in the samples
+type shape struct {
+	kinds kind
+	obj   *object
+	elem  *shape // set once an array has been seen
+	count int    // how many values were observed
+}
+
+// object holds the members seen in the objects (or XML elements) at one place, in the order they
+// were first seen
+type object struct {
+	fields []*field
+	byKey  map[string]*field
+	count  int // how many objects were merged
+
+	// only used for XML: the element name, and the element's text content
+	name xml.Name
+	text *shape
+}
+
+// field is one member of an object: a JSON member, an XML child element or an XML attribute
+type field struct {
+	key      string
+	attr     bool
+	shape    *shape
+	present  int  // how many of the objects had this field
+	repeated bool // an XML element that appeared more than once in one parent
+}
+
+func (o *object) field(key string, attr bool) *field {
+	id := key
+	if attr {
+		id = "@" + key
+	}
+	if f, ok := o.byKey[id]; ok {
+		return f
+	}
+	f := &field{key: key, attr: attr, shape: &shape{}}
+	o.byKey[id] = f
+	o.fields = append(o.fields, f)
+	return f
+}
+
+func (s *shape) object() *object {
+	if s.obj == nil {
+		s.obj = &object{byKey: make(map[string]*field)}
+	}
+	return s.obj
+}
+
+// optional reports whether a field was missing or null in some of the objects
+func (f *field) optional(parent *object) bool {
+	return f.present < parent.count || f.shape.kinds&kindNull != 0
+}
+
+// observeJSON reads the next value from dec and merges it into s
+func observeJSON(dec *json.Decoder, s *shape) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	s.count++
+
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			if s.elem == nil {
+				s.elem = &shape{}
+			}
+			for dec.More() {
+				if err := observeJSON(dec, s.elem); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token()
+			return err
+		}
+
+		obj := s.object()
+		obj.count++
+		seen := make(map[string]bool)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := tok.(string)
+			f := obj.field(key, false)
+			if !seen[key] {
+				seen[key] = true
+				f.present++
+			}
+			if err := observeJSON(dec, f.shape); err != nil {
+				return err
+			}
+		}
+		_, err := dec.Token()
+		return err
+
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			s.kinds |= kindInt
+		} else {
+			s.kinds |= kindFloat
+		}
+	case string:
+		s.kinds |= kindString
+	case bool:
+		s.kinds |= kindBool
+	case nil:
+		s.kinds |= kindNull
+	}
+	return nil
+}
+
+// InferJSON infers the shape of the top-level values in a JSON input
+// several values in one input, such as NDJSON, are treated as several samples
+func InferJSON(r io.Reader, s *shape) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	for dec.More() {
+		if err := observeJSON(dec, s); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the top-level value")
+	}
+	return nil
+}
+
+// InferXML infers the shape of an XML document's root element
+func InferXML(r io.Reader, s *shape) error {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return fmt.Errorf("no root element")
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if s.obj != nil && s.obj.name.Local != start.Name.Local {
+				return fmt.Errorf("root element <%s> doesn't match <%s> from an earlier sample", start.Name.Local, s.obj.name.Local)
+			}
+			return observeXML(dec, start, s)
+		}
+	}
+}
+
+// observeXML merges an element, whose start tag has just been read, into s
+func observeXML(dec *xml.Decoder, start xml.StartElement, s *shape) error {
+	s.count++
+	obj := s.object()
+	obj.count++
+	obj.name = start.Name
+
+	for _, a := range start.Attr {
+		// namespace declarations are part of the document's syntax rather than its data
+		if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
+			continue
+		}
+		f := obj.field(a.Name.Local, true)
+		f.present++
+		f.shape.count++
+		f.shape.kinds |= scalarKind(a.Value)
+	}
+
+	children := make(map[string]int)
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			f := obj.field(t.Name.Local, false)
+			children[t.Name.Local]++
+			switch children[t.Name.Local] {
+			case 1:
+				f.present++
+			case 2:
+				f.repeated = true
+			}
+			if err := observeXML(dec, t, f.shape); err != nil {
+				return err
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if s := strings.TrimSpace(text.String()); s != "" {
+				if obj.text == nil {
+					obj.text = &shape{}
+				}
+				obj.text.count++
+				obj.text.kinds |= scalarKind(s)
+			}
+			return nil
+		}
+	}
+}
+
+// scalarKind infers the type of XML text, where everything is a string to begin with
+func scalarKind(s string) kind {
+	s = strings.TrimSpace(s)
+	if strings.IndexAny(s, "0123456789") < 0 {
+		// ParseFloat also accepts words such as NaN and Inf
+		if s == "true" || s == "false" {
+			return kindBool
+		}
+		return kindString
+	}
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return kindInt
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return kindFloat
+	}
+	return kindString
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// The json and xml examples declare their types by hand: response1 and response2 mirror a small
+// JSON document, and Plant mirrors a <plant> element
+// For a real API with dozens of nested fields, writing those structs is slow and easy to get wrong
+
+// Here we'll generate them instead, from one or more sample documents
+// infer.go merges the samples into one shape, and this file turns that shape into Go types:
+//   a member missing or null in some samples is optional
+//   nested objects become named struct types, with the members of every sample merged
+//   array elements are merged too, so an array of objects with different members becomes a slice
+//   of one struct; arrays mixing objects, arrays and scalars fall back to interface{}
+//   repeated XML elements become slices, attributes get ,attr tags and text gets ,chardata
+
+// Omitempty policies, chosen with the -omitempty flag
+const (
+	omitNever    = "never"
+	omitOptional = "optional"
+	omitAlways   = "always"
+)
+
+// Generator turns an inferred shape into Go source
+type Generator struct {
+	Package   string
+	Format    string // json or xml
+	Omitempty string
+
+	buf   bytes.Buffer
+	names map[string]bool
+	queue []pending
+}
+
+// pending is a struct type that has been named and still has to be written
+type pending struct {
+	name string
+	obj  *object
+	root bool
+}
+
+// Generate returns the formatted source for a root shape
+func (g *Generator) Generate(root *shape, name string) ([]byte, error) {
+	g.names = make(map[string]bool)
+	g.buf.Reset()
+
+	fmt.Fprintf(&g.buf, "// Code generated by struct-generation; DO NOT EDIT.\n\npackage %s\n\n", g.Package)
+	if g.Format == "xml" {
+		g.buf.WriteString("import \"encoding/xml\"\n\n")
+	}
+
+	switch {
+	case root.obj != nil && root.elem == nil && root.kinds&^kindNull == 0:
+		g.queue = append(g.queue, pending{g.unique(name), root.obj, true})
+	case root.elem != nil && root.elem.obj != nil && root.obj == nil:
+		// a top-level array describes its elements; decode it into a slice of them
+		n := g.unique(name)
+		fmt.Fprintf(&g.buf, "// %s is an element of the top-level array; decode the document into []%s\n", n, n)
+		g.queue = append(g.queue, pending{n, root.elem.obj, false})
+	default:
+		return nil, fmt.Errorf("the samples must be objects, or arrays of objects")
+	}
+
+	for len(g.queue) > 0 {
+		p := g.queue[0]
+		g.queue = g.queue[1:]
+		g.writeStruct(p)
+	}
+
+	// go/format gives us gofmt's output, including the alignment of field tags
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return g.buf.Bytes(), fmt.Errorf("formatting generated code: %v", err)
+	}
+	return src, nil
+}
+
+func (g *Generator) writeStruct(p pending) {
+	fmt.Fprintf(&g.buf, "type %s struct {\n", p.name)
+
+	// field names are unique within a struct, even when two keys map to the same Go name
+	used := make(map[string]bool)
+	if p.root && g.Format == "xml" {
+		fmt.Fprintf(&g.buf, "XMLName xml.Name `xml:%q`\n", p.obj.name.Local)
+		used["XMLName"] = true
+	}
+
+	for _, f := range p.obj.fields {
+		tagName, s, repeated := f.key, f.shape, f.repeated
+		if g.Format == "xml" && !f.attr && !repeated {
+			tagName, s, repeated = collapse(tagName, s)
+		}
+
+		fieldName := uniqueIn(used, goName(lastKey(tagName)))
+		typ := g.goType(s, fieldName, repeated)
+
+		optional := f.optional(p.obj)
+		if g.Format == "json" && optional && s.obj != nil && s.elem == nil && !strings.HasPrefix(typ, "*") {
+			// omitempty never omits a struct value, and a pointer also tells a missing object
+			// from an empty one
+			typ = "*" + typ
+		}
+
+		tag := tagName
+		if f.attr {
+			tag += ",attr"
+		}
+		if g.Omitempty == omitAlways || (g.Omitempty == omitOptional && optional) {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&g.buf, "%s %s `%s:%q`\n", fieldName, typ, g.Format, tag)
+	}
+
+	if g.Format == "xml" && p.obj.text != nil {
+		fmt.Fprintf(&g.buf, "%s %s `xml:\",chardata\"`\n", uniqueIn(used, "Text"), xmlScalar(p.obj.text.kinds))
+	}
+
+	g.buf.WriteString("}\n\n")
+}
+
+// collapse follows chains of XML elements that only wrap a single child element, so that
+// <parent><child><plant> becomes one field tagged parent>child>plant, as in the xml example
+func collapse(path string, s *shape) (string, *shape, bool) {
+	for {
+		o := s.obj
+		if o == nil || o.text != nil || len(o.fields) != 1 || o.fields[0].attr {
+			return path, s, false
+		}
+		child := o.fields[0]
+		path += ">" + child.key
+		s = child.shape
+		if child.repeated {
+			return path, s, true
+		}
+	}
+}
+
+// lastKey gives the element a field is named after, which for a collapsed path is its last part
+func lastKey(path string) string {
+	if i := strings.LastIndexByte(path, '>'); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+// goType picks the Go type for a shape, naming and queueing any struct types it needs
+func (g *Generator) goType(s *shape, name string, repeated bool) string {
+	if repeated {
+		return "[]" + g.goType(s, name, false)
+	}
+	if g.Format == "xml" {
+		if s.obj == nil {
+			// attributes
+			return xmlScalar(s.kinds)
+		}
+		if len(s.obj.fields) == 0 {
+			// an element with only text content is a scalar
+			if s.obj.text == nil {
+				return "string"
+			}
+			return xmlScalar(s.obj.text.kinds)
+		}
+		return g.structType(s.obj, name)
+	}
+
+	scalars := s.kinds &^ kindNull
+	nullable := s.kinds&kindNull != 0
+
+	// a value that was an object in one sample and an array or a string in another can only be
+	// decoded into an interface{}
+	categories := 0
+	for _, seen := range []bool{scalars != 0, s.obj != nil, s.elem != nil} {
+		if seen {
+			categories++
+		}
+	}
+	if categories != 1 {
+		return "interface{}"
+	}
+
+	var typ string
+	switch {
+	case s.obj != nil:
+		typ = g.structType(s.obj, name)
+	case s.elem != nil:
+		if s.elem.count == 0 {
+			// only empty arrays were seen, so there's nothing to say about the elements
+			return "[]interface{}"
+		}
+		return "[]" + g.goType(s.elem, singular(name), false)
+	case scalars == kindBool:
+		typ = "bool"
+	case scalars == kindInt:
+		typ = "int"
+	case scalars&^(kindInt|kindFloat) == 0:
+		typ = "float64"
+	case scalars == kindString:
+		typ = "string"
+	default:
+		return "interface{}"
+	}
+
+	// a pointer keeps null apart from the zero value
+	if nullable {
+		typ = "*" + typ
+	}
+	return typ
+}
+
+// xmlScalar picks a type for XML text; text that doesn't always parse as one type is a string
+func xmlScalar(k kind) string {
+	switch {
+	case k == kindBool:
+		return "bool"
+	case k == kindInt:
+		return "int"
+	case k != 0 && k&^(kindInt|kindFloat) == 0:
+		return "float64"
+	}
+	return "string"
+}
+
+func (g *Generator) structType(obj *object, name string) string {
+	n := g.unique(name)
+	g.queue = append(g.queue, pending{n, obj, false})
+	return n
+}
+
+// unique returns name, or name with a number appended if a type of that name already exists
+func (g *Generator) unique(name string) string {
+	return uniqueIn(g.names, name)
+}
+
+func uniqueIn(used map[string]bool, name string) string {
+	n := name
+	for i := 2; used[n]; i++ {
+		n = fmt.Sprintf("%s%d", name, i)
+	}
+	used[n] = true
+	return n
+}
+
+// initialisms are written in capitals, as the Go style guide asks
+var initialisms = map[string]bool{
+	"API": true, "CPU": true, "CSS": true, "DNS": true, "HTML": true, "HTTP": true, "HTTPS": true,
+	"ID": true, "IP": true, "JSON": true, "SQL": true, "TCP": true, "TLS": true, "TTL": true,
+	"UDP": true, "UI": true, "URI": true, "URL": true, "UTF8": true, "UUID": true, "XML": true,
+}
+
+// goName turns a JSON key or XML name, such as user_id, userId or user-id, into an exported Go
+// identifier such as UserID
+func goName(key string) string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+
+	runes := []rune(key)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		// a capital starts a new word after a lower case letter (userId), or before one at the
+		// end of an acronym (HTTPServer)
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) ||
+			(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			flush()
+		}
+		word = append(word, r)
+	}
+	flush()
+
+	var b strings.Builder
+	for _, w := range words {
+		if up := strings.ToUpper(w); initialisms[up] {
+			b.WriteString(up)
+			continue
+		}
+		rs := []rune(strings.ToLower(w))
+		rs[0] = unicode.ToUpper(rs[0])
+		b.WriteString(string(rs))
+	}
+
+	name := b.String()
+	switch {
+	case name == "":
+		return "Field"
+	case unicode.IsDigit([]rune(name)[0]):
+		return "N" + name
+	}
+	return name
+}
+
+// singular names the element type of a slice after the slice's field: Fruits holds Fruit
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "ss"):
+		return name + "Item"
+	case strings.HasSuffix(name, "s") && len(name) > 1:
+		return name[:len(name)-1]
+	}
+	return name + "Item"
+}
+
+func main() {
+	name := flag.String("name", "Response", "name of the top-level type")
+	pkg := flag.String("package", "main", "package name for the generated file")
+	formatFlag := flag.String("format", "", "json or xml (default: from the file extension)")
+	omit := flag.String("omitempty", omitOptional, "when to add omitempty: never, optional or always")
+	out := flag.String("o", "", "write to this file instead of stdout")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: struct-generation [flags] sample...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *omit != omitNever && *omit != omitOptional && *omit != omitAlways {
+		fmt.Fprintf(os.Stderr, "unknown omitempty policy %q\n", *omit)
+		os.Exit(2)
+	}
+
+	g := &Generator{Package: *pkg, Format: *formatFlag, Omitempty: *omit}
+	if g.Format == "" {
+		g.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(flag.Arg(0))), ".")
+	}
+	if g.Format != "json" && g.Format != "xml" {
+		fmt.Fprintln(os.Stderr, "can't tell the format of the samples; use -format json or -format xml")
+		os.Exit(2)
+	}
+
+	// every sample is merged into the same shape
+	root := &shape{}
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if g.Format == "json" {
+			err = InferJSON(f, root)
+		} else {
+			err = InferXML(f, root)
+		}
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+
+	src, err := g.Generate(root, *name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Generate response2 from the json example, merging two samples
+	// >> echo '{"page": 1, "fruits": ["apple", "peach"]}' > a.json
+	// >> echo '{"page": 2, "fruits": [], "next": {"page": 3}}' > b.json
+	// >> go run . -name Response2 a.json b.json
+
+	// Generate Plant and Nesting from the xml example
+	// >> echo '<plant id="27"><name>Coffee</name><origin>Ethiopia</origin><origin>Brazil</origin></plant>' > plant.xml
+	// >> go run . -name Plant plant.xml
+}
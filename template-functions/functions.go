@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FuncMap returns the function library, ready for Template.Funcs
+// text/template.FuncMap and html/template.FuncMap are both defined as map[string]any, so the
+// same map works with either package
+// a new map is returned each time, so callers can add to it or override entries safely
+//
+// Functions that take a value to work on take it last, so they read naturally in pipelines:
+// {{.Name | trim | upper}} or {{.Tags | sort | join ", "}}
+// Functions that can fail return an error, which stops the template with a message, rather than
+// panicking or producing a wrong result
+func FuncMap() map[string]interface{} {
+	return map[string]interface{}{
+		// strings
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": trimPrefix,
+		"trimSuffix": trimSuffix,
+		"replace":    replace,
+		"contains":   contains,
+		"wrap":       wrap,
+
+		// numbers and dates
+		"formatNumber": formatNumber,
+		"formatDate":   formatDate,
+
+		// defaults
+		"default":  defaultValue,
+		"coalesce": coalesce,
+		"empty":    empty,
+
+		// encoding
+		"toJSON": toJSON,
+
+		// lists
+		"list":  list,
+		"join":  join,
+		"first": first,
+		"last":  last,
+		"sort":  sortList,
+
+		// arithmetic
+		"add": add,
+		"sub": sub,
+		"mul": mul,
+		"div": div,
+		"mod": mod,
+	}
+}
+
+// trimPrefix removes prefix from the start of s, if it's there
+// e.g. {{"v1.2" | trimPrefix "v"}} gives 1.2
+func trimPrefix(prefix, s string) string {
+	return strings.TrimPrefix(s, prefix)
+}
+
+// trimSuffix removes suffix from the end of s, if it's there
+// e.g. {{"report.txt" | trimSuffix ".txt"}} gives report
+func trimSuffix(suffix, s string) string {
+	return strings.TrimSuffix(s, suffix)
+}
+
+// replace replaces every occurrence of old in s with new
+// e.g. {{"a-b-c" | replace "-" " "}} gives a b c
+func replace(old, new, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+// contains reports whether substr is within s
+// e.g. {{if .Email | contains "@"}}
+func contains(substr, s string) bool {
+	return strings.Contains(s, substr)
+}
+
+// wrap breaks s into lines of at most width characters, at spaces
+// existing line breaks are kept, and a word longer than width gets a line of its own
+// e.g. {{.Description | wrap 72}}
+func wrap(width int, s string) (string, error) {
+	if width < 1 {
+		return "", fmt.Errorf("wrap: width must be positive, got %d", width)
+	}
+
+	var b strings.Builder
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		n := 0
+		for _, word := range strings.Fields(line) {
+			w := utf8.RuneCountInString(word)
+			switch {
+			case n == 0:
+			case n+1+w > width:
+				b.WriteByte('\n')
+				n = 0
+			default:
+				b.WriteByte(' ')
+				n++
+			}
+			b.WriteString(word)
+			n += w
+		}
+	}
+	return b.String(), nil
+}
+
+// formatNumber formats a number with a fixed number of decimals and commas between thousands
+// e.g. {{1234567.891 | formatNumber 2}} gives 1,234,567.89
+func formatNumber(decimals int, v interface{}) (string, error) {
+	if decimals < 0 {
+		return "", fmt.Errorf("formatNumber: decimals must not be negative, got %d", decimals)
+	}
+	i, f, isInt, err := number(v)
+	if err != nil {
+		return "", fmt.Errorf("formatNumber: %v", err)
+	}
+
+	var s string
+	if isInt {
+		s = strconv.FormatInt(i, 10)
+		if decimals > 0 {
+			s += "." + strings.Repeat("0", decimals)
+		}
+	} else {
+		s = strconv.FormatFloat(f, 'f', decimals, 64)
+	}
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+
+	var b strings.Builder
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	if frac != "" {
+		b.WriteString("." + frac)
+	}
+	return sign + b.String(), nil
+}
+
+// formatDate formats a time with a Go layout, as in the time-formatting-parsing example
+// t can be a time.Time, a Unix time in seconds, or a string in RFC 3339 format
+// e.g. {{.Created | formatDate "2 Jan 2006"}} gives 14 Mar 2024
+// e.g. {{.Created | formatDate "2006-01-02T15:04:05Z07:00"}}
+func formatDate(layout string, t interface{}) (string, error) {
+	switch t := t.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case *time.Time:
+		if t == nil {
+			return "", errors.New("formatDate: nil time")
+		}
+		return t.Format(layout), nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			return "", fmt.Errorf("formatDate: %v", err)
+		}
+		return parsed.Format(layout), nil
+	}
+
+	secs, _, isInt, err := number(t)
+	if err != nil || !isInt {
+		return "", fmt.Errorf("formatDate: can't format %T as a date", t)
+	}
+	return time.Unix(secs, 0).UTC().Format(layout), nil
+}
+
+// empty reports whether a value is empty: nil, false, zero, an empty string, slice or map, or a
+// zero struct
+// e.g. {{if empty .Tags}}no tags{{end}}
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String, reflect.Chan:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return rv.IsZero()
+}
+
+// defaultValue returns v, or def if v is empty
+// e.g. {{.Nickname | default "friend"}}
+func defaultValue(def, v interface{}) interface{} {
+	if empty(v) {
+		return def
+	}
+	return v
+}
+
+// coalesce returns the first of its arguments that isn't empty, or nil if they all are
+// e.g. {{coalesce .DisplayName .Username "anonymous"}}
+func coalesce(values ...interface{}) interface{} {
+	for _, v := range values {
+		if !empty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// toJSON encodes a value as JSON
+// the result is typed as JavaScript, so html/template inserts it into a <script> block as it is,
+// rather than quoting it as a string; that's safe because json.Marshal escapes <, > and &
+// elsewhere in HTML, and in text/template, it's just a string
+// e.g. <script>const data = {{toJSON .}};</script>
+func toJSON(v interface{}) (htmltemplate.JS, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("toJSON: %v", err)
+	}
+	return htmltemplate.JS(b), nil
+}
+
+// list makes a slice from its arguments, for templates that need a literal list
+// e.g. {{range list "a" "b" "c"}}
+func list(values ...interface{}) []interface{} {
+	return values
+}
+
+// items returns the elements of a slice or array
+func items(name string, v interface{}) ([]reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%s: expected a list, got %T", name, v)
+	}
+	out := make([]reflect.Value, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i)
+	}
+	return out, nil
+}
+
+// join joins the elements of a list with sep, formatting each as {{.}} would
+// e.g. {{.Tags | join ", "}} gives go, templates
+func join(sep string, v interface{}) (string, error) {
+	els, err := items("join", v)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(els))
+	for i, el := range els {
+		parts[i] = fmt.Sprint(el.Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// first returns the first element of a list, or nil if it's empty
+// e.g. {{first .Names}}
+func first(v interface{}) (interface{}, error) {
+	els, err := items("first", v)
+	if err != nil || len(els) == 0 {
+		return nil, err
+	}
+	return els[0].Interface(), nil
+}
+
+// last returns the last element of a list, or nil if it's empty
+// e.g. {{last .Names}}
+func last(v interface{}) (interface{}, error) {
+	els, err := items("last", v)
+	if err != nil || len(els) == 0 {
+		return nil, err
+	}
+	return els[len(els)-1].Interface(), nil
+}
+
+// sortList returns a sorted copy of a list of strings or numbers
+// e.g. {{.Tags | sort | join ", "}}
+func sortList(v interface{}) ([]interface{}, error) {
+	els, err := items("sort", v)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(els))
+	for i, el := range els {
+		out[i] = el.Interface()
+	}
+
+	var cmpErr error
+	sort.SliceStable(out, func(i, j int) bool {
+		if a, ok := out[i].(string); ok {
+			b, ok := out[j].(string)
+			if !ok {
+				cmpErr = fmt.Errorf("sort: can't compare %T with %T", out[i], out[j])
+			}
+			return a < b
+		}
+		_, a, _, err1 := number(out[i])
+		_, b, _, err2 := number(out[j])
+		if err1 != nil || err2 != nil {
+			cmpErr = fmt.Errorf("sort: can't compare %T with %T", out[i], out[j])
+		}
+		return a < b
+	})
+	return out, cmpErr
+}
+
+// number converts any Go number to an int64 if it's an integer type, or a float64 otherwise
+// f is set in both cases
+func number(v interface{}) (i int64, f float64, isInt bool, err error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i = rv.Int()
+		return i, float64(i), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return 0, 0, false, fmt.Errorf("%d is too large", u)
+		}
+		return int64(u), float64(u), true, nil
+	case reflect.Float32, reflect.Float64:
+		return 0, rv.Float(), false, nil
+	}
+	return 0, 0, false, fmt.Errorf("expected a number, got %T", v)
+}
+
+// arith applies an operation to two numbers: to integers if both are integers, failing rather
+// than overflowing, and to floats otherwise
+func arith(name string, a, b interface{}, ints func(x, y int64) (int64, bool), floats func(x, y float64) float64) (interface{}, error) {
+	ai, af, aInt, err := number(a)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	bi, bf, bInt, err := number(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	if aInt && bInt {
+		r, ok := ints(ai, bi)
+		if !ok {
+			return nil, fmt.Errorf("%s: %d and %d overflows", name, ai, bi)
+		}
+		return r, nil
+	}
+	return floats(af, bf), nil
+}
+
+// add returns a + b
+// e.g. {{add .Count 1}}
+func add(a, b interface{}) (interface{}, error) {
+	return arith("add", a, b,
+		func(x, y int64) (int64, bool) {
+			r := x + y
+			return r, (r > x) == (y > 0)
+		},
+		func(x, y float64) float64 { return x + y })
+}
+
+// sub returns a - b
+// e.g. {{sub .Total .Used}}
+func sub(a, b interface{}) (interface{}, error) {
+	return arith("sub", a, b,
+		func(x, y int64) (int64, bool) {
+			r := x - y
+			return r, (r < x) == (y > 0)
+		},
+		func(x, y float64) float64 { return x - y })
+}
+
+// mul returns a * b
+// e.g. {{mul .Price .Quantity}}
+func mul(a, b interface{}) (interface{}, error) {
+	return arith("mul", a, b,
+		func(x, y int64) (int64, bool) {
+			if x == 0 || y == 0 {
+				return 0, true
+			}
+			r := x * y
+			return r, r/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64)
+		},
+		func(x, y float64) float64 { return x * y })
+}
+
+// div returns a / b, failing if b is zero; integers divide as in Go, discarding the remainder
+// e.g. {{div .Total .Count}}
+func div(a, b interface{}) (interface{}, error) {
+	if _, f, _, err := number(b); err == nil && f == 0 {
+		return nil, errors.New("div: division by zero")
+	}
+	return arith("div", a, b,
+		func(x, y int64) (int64, bool) {
+			return x / y, !(x == math.MinInt64 && y == -1)
+		},
+		func(x, y float64) float64 { return x / y })
+}
+
+// mod returns the remainder of a / b, failing if b is zero
+// e.g. {{if eq (mod $i 2) 0}}even{{end}}
+func mod(a, b interface{}) (interface{}, error) {
+	if _, f, _, err := number(b); err == nil && f == 0 {
+		return nil, errors.New("mod: division by zero")
+	}
+	return arith("mod", a, b,
+		func(x, y int64) (int64, bool) {
+			if y == -1 {
+				return 0, true
+			}
+			return x % y, true
+		},
+		math.Mod)
+}
@@ -0,0 +1,3 @@
+module example/template-functions
+
+go 1.18
@@ -0,0 +1,74 @@
+package main
+
+import (
+	htmltemplate "html/template"
+	"os"
+	"text/template"
+	"time"
+)
+
+// The text-templates example only uses the built-in actions: {{.}}, {{if}} and {{range}}
+// Templates get much more useful with functions: Template.Funcs adds named functions that
+// actions can call, like the built-in len, index and printf
+
+// functions.go defines a small standard library of them, in the same spirit as the helpers that
+// web frameworks ship with: strings, numbers and dates, defaults, JSON, lists and arithmetic
+
+// Driver is some data for the templates below
+type Driver struct {
+	Name     string
+	Nickname string
+	Team     string
+	Points   float64
+	Wins     int
+	Since    time.Time
+	Circuits []string
+}
+
+func main() {
+	drivers := []Driver{
+		{"George Russell", "", "Mercedes", 1234.5, 3, time.Date(2019, 3, 17, 0, 0, 0, 0, time.UTC), []string{"Silverstone", "Monza", "Interlagos"}},
+		{"Lewis Hamilton", "Sir Lewis", "Ferrari", 4867, 105, time.Date(2007, 3, 18, 0, 0, 0, 0, time.UTC), nil},
+	}
+
+	// functions have to be added before the template that uses them is parsed
+	// Create is the helper from the text-templates example, with the functions added
+	Create := func(name, t string) *template.Template {
+		return template.Must(template.New(name).Funcs(FuncMap()).Parse(t))
+	}
+
+	// string helpers and pipelines: the value being piped in is always the last argument
+	t1 := Create("t1", "{{.Name | upper}} ({{.Nickname | default \"no nickname\"}}) drives for {{.Team | lower | replace \"e\" \"3\"}}\n")
+
+	// numbers, dates and arithmetic
+	t2 := Create("t2", "{{.Points | formatNumber 1}} points, {{.Wins}} wins, {{div .Points (add .Wins 1) | formatNumber 2}} points per win+1, racing since {{.Since | formatDate \"January 2006\"}}\n")
+
+	// lists
+	t3 := Create("t3", "{{if empty .Circuits}}no favourite circuits{{else}}favourites: {{.Circuits | sort | join \", \"}}, best is {{first .Circuits}}{{end}}\n")
+
+	for _, d := range drivers {
+		for _, t := range []*template.Template{t1, t2, t3} {
+			if err := t.Execute(os.Stdout, d); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	// wrap and coalesce
+	t4 := Create("t4", "{{wrap 30 .}}\n{{coalesce \"\" 0 \"first non-empty\"}}\n")
+	t4.Execute(os.Stdout, "Templates get much more useful with functions, which actions can call like the built-in len and printf")
+
+	// errors stop the template rather than producing a wrong result
+	t5 := Create("t5", "{{div 1 0}}\n")
+	if err := t5.Execute(os.Stdout, nil); err != nil {
+		os.Stdout.WriteString("error: " + err.Error() + "\n")
+	}
+
+	// the same functions work with html/template, whose escaping applies to their results: here
+	// toJSON's output is escaped for a <script> block, and upper's for HTML text
+	h := htmltemplate.Must(htmltemplate.New("h").Funcs(FuncMap()).Parse(
+		"<p>{{.Name | upper}}</p>\n<script>const driver = {{toJSON .}};</script>\n"))
+	if err := h.Execute(os.Stdout, Driver{Name: "<b>Kimi</b>", Team: "Mercedes"}); err != nil {
+		panic(err)
+	}
+}
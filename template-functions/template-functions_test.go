@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"math"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// render executes a template with the function library using text/template
+func render(t *testing.T, tmpl string, data interface{}) (string, error) {
+	t.Helper()
+	tt, err := template.New("test").Funcs(FuncMap()).Parse(tmpl)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", tmpl, err)
+	}
+	var b bytes.Buffer
+	err = tt.Execute(&b, data)
+	return b.String(), err
+}
+
+func TestFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"Name":   "  Lewis Hamilton ",
+		"Empty":  "",
+		"Zero":   0,
+		"Tags":   []string{"pear", "apple", "fig"},
+		"Nums":   []int{3, -1, 2},
+		"None":   []string{},
+		"Big":    int64(math.MaxInt64),
+		"Date":   time.Date(2024, 3, 14, 15, 9, 26, 0, time.UTC),
+		"Struct": struct{ A int }{},
+	}
+
+	var tests = []struct {
+		tmpl, want string
+	}{
+		{`{{.Name | trim | upper}}`, "LEWIS HAMILTON"},
+		{`{{"ABC" | lower}}`, "abc"},
+		{`{{"v1.2" | trimPrefix "v"}}`, "1.2"},
+		{`{{"report.txt" | trimSuffix ".txt"}}`, "report"},
+		{`{{"a-b-c" | replace "-" " "}}`, "a b c"},
+		{`{{if .Name | contains "Lewis"}}yes{{end}}`, "yes"},
+		{`{{wrap 10 "the quick brown fox jumps"}}`, "the quick\nbrown fox\njumps"},
+		{`{{wrap 3 "a extraordinary b"}}`, "a\nextraordinary\nb"},
+		{`{{wrap 5 "ab cd\nef"}}`, "ab cd\nef"},
+		{`{{1234567.891 | formatNumber 2}}`, "1,234,567.89"},
+		{`{{-1234 | formatNumber 0}}`, "-1,234"},
+		{`{{999 | formatNumber 1}}`, "999.0"},
+		{`{{.Big | formatNumber 0}}`, "9,223,372,036,854,775,807"},
+		{`{{.Date | formatDate "2 Jan 2006 15:04"}}`, "14 Mar 2024 15:09"},
+		{`{{"2024-03-14T15:09:26Z" | formatDate "2006-01-02"}}`, "2024-03-14"},
+		{`{{0 | formatDate "2006"}}`, "1970"},
+		{`{{.Empty | default "friend"}}`, "friend"},
+		{`{{.Zero | default 7}}`, "7"},
+		{`{{.Name | default "friend" | trim}}`, "Lewis Hamilton"},
+		{`{{coalesce .Empty .Zero .None "x" "y"}}`, "x"},
+		{`{{coalesce .Empty}}`, "<no value>"},
+		{`{{empty .Struct}} {{empty .None}} {{empty .Tags}} {{empty .Missing}}`, "true true false true"},
+		{`{{toJSON .Tags}}`, `["pear","apple","fig"]`},
+		{`{{toJSON (list 1 "a<b" true)}}`, `[1,"a\u003cb",true]`},
+		{`{{.Tags | join ", "}}`, "pear, apple, fig"},
+		{`{{.Tags | sort | join ","}}`, "apple,fig,pear"},
+		{`{{.Nums | sort | join ","}}`, "-1,2,3"},
+		{`{{.Tags | join ","}}`, "pear,apple,fig"}, // sort doesn't change its input
+		{`{{first .Tags}} {{last .Tags}}`, "pear fig"},
+		{`{{first .None}}`, "<no value>"},
+		{`{{add 2 3}} {{sub 2 3}} {{mul 4 5}} {{div 7 2}} {{mod 7 2}}`, "5 -1 20 3 1"},
+		{`{{add 1.5 1}} {{div 7.0 2}} {{mod 7.5 2}}`, "2.5 3.5 1.5"},
+		{`{{if eq (mod 4 2) 0}}even{{end}}`, "even"},
+		{`{{range $i, $t := .Tags}}{{add $i 1}}.{{$t}} {{end}}`, "1.pear 2.apple 3.fig "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			got, err := render(t, tt.tmpl, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFunctionErrors(t *testing.T) {
+	data := map[string]interface{}{
+		"Big":   int64(math.MaxInt64),
+		"Small": int64(math.MinInt64),
+		"Huge":  uint64(math.MaxUint64),
+		"Mixed": []interface{}{"a", 1},
+	}
+
+	var tests = []struct {
+		tmpl, want string
+	}{
+		{`{{div 1 0}}`, "division by zero"},
+		{`{{mod 1 0.0}}`, "division by zero"},
+		{`{{add .Big 1}}`, "overflows"},
+		{`{{sub .Small 1}}`, "overflows"},
+		{`{{mul .Big 2}}`, "overflows"},
+		{`{{mul .Small -1}}`, "overflows"},
+		{`{{div .Small -1}}`, "overflows"},
+		{`{{add .Huge 1}}`, "too large"},
+		{`{{add "1" 1}}`, "expected a number"},
+		{`{{join "," "abc"}}`, "expected a list"},
+		{`{{sort .Mixed}}`, "can't compare"},
+		{`{{wrap 0 "x"}}`, "width must be positive"},
+		{`{{formatDate "2006" "yesterday"}}`, "formatDate"},
+		{`{{formatDate "2006" 1.5}}`, "can't format"},
+		{`{{formatNumber -1 1}}`, "must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			_, err := render(t, tt.tmpl, data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLTemplate(t *testing.T) {
+	tmpl := htmltemplate.Must(htmltemplate.New("test").Funcs(FuncMap()).Parse(
+		`<p title="{{.Name | upper}}">{{.Name | default "none"}}</p><script>var d = {{toJSON .}};</script>{{toJSON .}}`))
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, map[string]string{"Name": `<i>"x"</i>`}); err != nil {
+		t.Fatal(err)
+	}
+
+	// function results are escaped for their context: toJSON is inserted as it is in the script,
+	// but escaped as text outside it
+	want := `<p title="&lt;I&gt;&#34;X&#34;&lt;/I&gt;">&lt;i&gt;&#34;x&#34;&lt;/i&gt;</p>` +
+		`<script>var d = {"Name":"\u003ci\u003e\"x\"\u003c/i\u003e"};</script>` +
+		`{&#34;Name&#34;:&#34;\u003ci\u003e\&#34;x\&#34;\u003c/i\u003e&#34;}`
+	if got := b.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
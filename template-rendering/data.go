@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Data for the templates is one JSON-like map, built up from several sources, later ones winning:
+//   JSON files, merged in the order given
+//   environment variables, under .Env
+//   key=value arguments, where dotted keys such as site.title set nested values
+// Everything ends up as maps, which is what lets strict mode catch missing keys: a missing struct
+// field is always an error, but a missing map key only is with missingkey=error
+
+// Data is the template data
+type Data map[string]interface{}
+
+// LoadJSON merges a JSON file, which must hold an object, into d
+func (d Data) LoadJSON(name string) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	merge(d, v)
+	return nil
+}
+
+// LoadEnv sets .Env to the environment variables whose names start with prefix, with the prefix
+// removed; an empty prefix takes them all
+func (d Data) LoadEnv(prefix string) {
+	env, _ := d["Env"].(map[string]interface{})
+	if env == nil {
+		env = make(map[string]interface{})
+	}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(k, prefix) && len(k) > len(prefix) {
+			env[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+	d["Env"] = env
+}
+
+// Set sets a value from a key=value argument
+func (d Data) Set(arg string) error {
+	key, value, ok := strings.Cut(arg, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("%q isn't key=value", arg)
+	}
+
+	m := map[string]interface{}(d)
+	parts := strings.Split(key, ".")
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := m[p].(map[string]interface{})
+		if !ok {
+			// anything that isn't a map is replaced, as a later source would replace it
+			next = make(map[string]interface{})
+			m[p] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+	return nil
+}
+
+// merge copies src into dst, merging maps that are in both rather than replacing them
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sm, ok := v.(map[string]interface{})
+		dm, ok2 := dst[k].(map[string]interface{})
+		if ok && ok2 {
+			merge(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
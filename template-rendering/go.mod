@@ -0,0 +1,3 @@
+module example/template-rendering
+
+go 1.18
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// This file loads a directory of templates, laid out as:
+//   layouts/   the outer page structure, with {{block}}s for pages to fill in
+//   partials/  fragments shared between layouts and pages, used with {{template "nav" .}}
+//   pages/     one file per output, each {{define}}ing the blocks it fills in
+// Layouts and partials are named by their file name without the extension, so partials/nav.tmpl
+// is "nav"
+// Every page fills in the same blocks, and a template set can only hold one definition of each
+// name, so each page is parsed into its own clone of the layouts and partials
+// Pages whose names end in .html are parsed with html/template, which escapes data to suit where
+// it appears in the page, so a value containing <script> can't inject markup; other pages, like
+// robots.txt, are plain text and use text/template, which writes data as it is
+// The layouts and partials are parsed with both, so either kind of page can use them
+
+// layoutComment lets a page choose its layout, e.g. {{/* layout: plain */}}
+var layoutComment = regexp.MustCompile(`{{-?\s*/\*\s*layout:\s*(\S+)\s*\*/\s*-?}}`)
+
+// noLayout is the layout name for pages that are rendered on their own
+const noLayout = "none"
+
+// Loader reads templates from a file system, which can be a directory on disk, with os.DirFS, or
+// files built into the binary, with embed.FS
+type Loader struct {
+	FS fs.FS
+
+	// Layout is used for pages that don't choose one
+	Layout string
+
+	// Strict makes it an error to use a map key that isn't in the data, instead of printing
+	// "<no value>"
+	Strict bool
+
+	Funcs template.FuncMap
+}
+
+// Set is a loaded set of pages
+type Set struct {
+	pages map[string]*page
+}
+
+type page struct {
+	// a *template.Template or an *htmltemplate.Template
+	t interface {
+		ExecuteTemplate(w io.Writer, name string, data interface{}) error
+	}
+	entry string
+}
+
+// source is a layout or partial file
+type source struct {
+	path, name string
+	text       string
+}
+
+// Load parses every layout, partial and page
+func (l *Loader) Load() (*Set, error) {
+	text := template.New("").Funcs(l.Funcs)
+	html := htmltemplate.New("").Funcs(htmltemplate.FuncMap(l.Funcs))
+	if l.Strict {
+		text.Option("missingkey=error")
+		html.Option("missingkey=error")
+	}
+
+	layouts, err := l.readDir("layouts")
+	if err != nil {
+		return nil, err
+	}
+	partials, err := l.readDir("partials")
+	if err != nil {
+		return nil, err
+	}
+	isLayout := make(map[string]bool)
+	for _, src := range layouts {
+		isLayout[src.name] = true
+	}
+	for _, src := range append(layouts, partials...) {
+		if text.Lookup(src.name) != nil {
+			return nil, fmt.Errorf("%s: %q is already defined", src.path, src.name)
+		}
+		if _, err := text.New(src.name).Parse(src.text); err != nil {
+			return nil, err
+		}
+		if _, err := html.New(src.name).Parse(src.text); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Set{pages: make(map[string]*page)}
+	err = fs.WalkDir(l.FS, "pages", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		src, err := fs.ReadFile(l.FS, p)
+		if err != nil {
+			return err
+		}
+
+		layout := l.Layout
+		if m := layoutComment.FindSubmatch(src); m != nil {
+			layout = string(m[1])
+		}
+		if layout == "" {
+			layout = noLayout
+		}
+		if layout != noLayout && !isLayout[layout] {
+			return fmt.Errorf("%s: no layout named %q", p, layout)
+		}
+
+		// pages are named by their path without the .tmpl extension, e.g. blog/first-post.html
+		name := strings.TrimSuffix(strings.TrimPrefix(p, "pages/"), path.Ext(p))
+		entry := layout
+		if layout == noLayout {
+			entry = name
+		}
+
+		if path.Ext(name) == ".html" {
+			t, err := html.Clone()
+			if err != nil {
+				return err
+			}
+			if _, err := t.New(name).Parse(string(src)); err != nil {
+				return err
+			}
+			s.pages[name] = &page{t: t, entry: entry}
+			return nil
+		}
+		t, err := text.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := t.New(name).Parse(string(src)); err != nil {
+			return err
+		}
+		s.pages[name] = &page{t: t, entry: entry}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(s.pages) == 0 {
+		return nil, errors.New("no pages found")
+	}
+	return s, nil
+}
+
+// readDir reads each file in a directory, naming it by its file name without the extension
+// a missing directory is the same as an empty one
+func (l *Loader) readDir(dir string) ([]source, error) {
+	entries, err := fs.ReadDir(l.FS, dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var srcs []source
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		p := path.Join(dir, e.Name())
+		text, err := fs.ReadFile(l.FS, p)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(e.Name(), path.Ext(e.Name()))
+		srcs = append(srcs, source{path: p, name: name, text: string(text)})
+	}
+	return srcs, nil
+}
+
+// Pages returns the page names, in order
+func (s *Set) Pages() []string {
+	names := make([]string, 0, len(s.pages))
+	for name := range s.pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render executes a page with data
+// the output is buffered, so nothing is written if the template fails part way through
+func (s *Set) Render(w io.Writer, name string, data interface{}) error {
+	p, ok := s.pages[name]
+	if !ok {
+		return fmt.Errorf("no page named %q", name)
+	}
+	var b bytes.Buffer
+	if err := p.t.ExecuteTemplate(&b, p.entry, data); err != nil {
+		return err
+	}
+	_, err := b.WriteTo(w)
+	return err
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The text-templates example builds each template inline with its Create helper
+// Real sites and generated config files keep their templates in files instead, with layouts
+// that pages fill in and partials they share; this command loads a directory like that (load.go),
+// renders it with data from JSON files, the environment and the command line (data.go), and can
+// watch the files and render again whenever they change
+
+// the templates directory is built into the binary, so the command works without -dir
+//
+//go:embed templates
+var embedded embed.FS
+
+// funcs is a few of the functions from the template-functions example; modules can't share code,
+// so they're copied
+var funcs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (s *stringList) String() string     { return strings.Join(*s, ",") }
+func (s *stringList) Set(v string) error { *s = append(*s, v); return nil }
+
+// config is everything a render needs, so watch mode can repeat it
+type config struct {
+	loader    *Loader
+	dataFiles []string
+	env       bool
+	envPrefix string
+	sets      []string
+	pages     []string
+	out       string
+}
+
+func main() {
+	var c config
+	dir := flag.String("dir", "", "template directory, with layouts/, partials/ and pages/ (default: the built-in templates)")
+	layout := flag.String("layout", "base", "layout for pages that don't choose one, or none")
+	strict := flag.Bool("strict", false, "fail on map keys missing from the data, instead of printing <no value>")
+	flag.Var((*stringList)(&c.dataFiles), "data", "JSON `file` to merge into the data; can be repeated")
+	flag.BoolVar(&c.env, "env", false, "put environment variables in .Env")
+	flag.StringVar(&c.envPrefix, "env-prefix", "", "only use environment variables with this prefix, which is removed")
+	flag.StringVar(&c.out, "o", "", "output directory (default: stdout)")
+	watch := flag.Bool("watch", false, "render again whenever a template or data file changes")
+	interval := flag.Duration("interval", 500*time.Millisecond, "how often -watch checks for changes")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: template-rendering [flags] [page...] [key=value...]")
+		fmt.Fprintln(os.Stderr, "renders the named pages, or every page; key=value sets data, with dotted keys for nested values")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	for _, arg := range flag.Args() {
+		if strings.Contains(arg, "=") {
+			c.sets = append(c.sets, arg)
+		} else {
+			c.pages = append(c.pages, arg)
+		}
+	}
+
+	var fsys fs.FS
+	if *dir != "" {
+		fsys = os.DirFS(*dir)
+	} else {
+		if *watch {
+			fmt.Fprintln(os.Stderr, "-watch needs -dir: the built-in templates can't change")
+			os.Exit(2)
+		}
+		fsys, _ = fs.Sub(embedded, "templates")
+	}
+	c.loader = &Loader{FS: fsys, Layout: *layout, Strict: *strict, Funcs: funcs}
+
+	if err := c.render(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		if !*watch {
+			os.Exit(1)
+		}
+	}
+	if *watch {
+		watchFiles(append([]string{*dir}, c.dataFiles...), *interval, func() {
+			if err := c.render(); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				return
+			}
+			fmt.Fprintln(os.Stderr, "rendered at", time.Now().Format("15:04:05"))
+		})
+	}
+
+	// Render the built-in templates to stdout; anything missing from the data prints <no value>
+	// >> go run . -data data/site.json Greeting=hello
+	// >> go run . -data data/site.json robots.txt Site.URL=https://gobyexample.com
+
+	// Data in .html pages is escaped for HTML, so this prints <p>&lt;b&gt;hi&lt;/b&gt;</p>;
+	// robots.txt is plain text and gets data as it is
+	// >> go run . -data data/site.json index.html 'Greeting=<b>hi</b>'
+
+	// With -env, templates can use environment variables, e.g. {{.Env.USER}}, or with
+	// -env-prefix SITE_, {{.Env.TITLE}} for $SITE_TITLE
+
+	// In strict mode, missing data is an error instead
+	// >> go run . -strict -data data/site.json
+	// error: template: index.html:4:5: executing "content" at <.Greeting>: map has no entry for key "Greeting"
+
+	// Render a directory of templates to files, and again whenever they change
+	// >> go run . -dir templates -data data/site.json -o public -watch
+}
+
+// render loads the templates and data afresh, and renders the pages
+func (c *config) render() error {
+	set, err := c.loader.Load()
+	if err != nil {
+		return err
+	}
+
+	data := Data{}
+	for _, name := range c.dataFiles {
+		if err := data.LoadJSON(name); err != nil {
+			return err
+		}
+	}
+	if c.env {
+		data.LoadEnv(c.envPrefix)
+	}
+	for _, s := range c.sets {
+		if err := data.Set(s); err != nil {
+			return err
+		}
+	}
+
+	pages := c.pages
+	if len(pages) == 0 {
+		pages = set.Pages()
+	}
+
+	// every page is rendered before any is written, so a failure doesn't leave half a site
+	outputs := make([]bytes.Buffer, len(pages))
+	for i, p := range pages {
+		if err := set.Render(&outputs[i], p, data); err != nil {
+			return err
+		}
+	}
+
+	for i, p := range pages {
+		if c.out == "" {
+			if len(pages) > 1 {
+				fmt.Printf("==> %s <==\n", p)
+			}
+			outputs[i].WriteTo(os.Stdout)
+			continue
+		}
+		name := filepath.Join(c.out, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, outputs[i].Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchFiles calls changed whenever a file under one of the roots is added, removed or modified,
+// and never returns
+// it polls modification times rather than using OS notifications, which keeps it to the standard
+// library and works the same everywhere, at the cost of a short delay
+func watchFiles(roots []string, interval time.Duration, changed func()) {
+	last := snapshot(roots)
+	for range time.Tick(interval) {
+		now := snapshot(roots)
+		if !sameSnapshot(last, now) {
+			changed()
+		}
+		last = now
+	}
+}
+
+// snapshot records the modification time and size of every file under the roots
+func snapshot(roots []string) map[string]string {
+	files := make(map[string]string)
+	for _, root := range roots {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				// files can disappear while we look; the next snapshot will see the difference
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				files[p] = fmt.Sprint(info.ModTime().UnixNano(), info.Size())
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+func sameSnapshot(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
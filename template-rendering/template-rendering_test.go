@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var site = fstest.MapFS{
+	"layouts/base.tmpl":    {Data: []byte(`<title>{{block "title" .}}default{{end}}</title>{{block "content" .}}{{end}}{{template "footer" .}}`)},
+	"layouts/bare.tmpl":    {Data: []byte(`[{{block "content" .}}{{end}}]`)},
+	"partials/footer.tmpl": {Data: []byte(`<footer>{{.Name}}</footer>`)},
+	"pages/index.html.tmpl": {Data: []byte(
+		`{{define "title"}}Home{{end}}{{define "content"}}hi {{.Name}}{{end}}`)},
+	"pages/docs/about.html.tmpl": {Data: []byte(`{{define "content"}}about{{end}}`)},
+	"pages/bare.txt.tmpl":        {Data: []byte(`{{/* layout: bare */}}{{define "content"}}{{.Name | upper}}{{end}}`)},
+	"pages/alone.txt.tmpl":       {Data: []byte(`{{/* layout: none */}}just {{.Name}}`)},
+}
+
+func TestRender(t *testing.T) {
+	set, err := (&Loader{FS: site, Layout: "base", Funcs: funcs}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := strings.Join(set.Pages(), " "), "alone.txt bare.txt docs/about.html index.html"; got != want {
+		t.Errorf("pages: got %q, want %q", got, want)
+	}
+
+	var tests = []struct {
+		page, want string
+	}{
+		{"index.html", "<title>Home</title>hi Go<footer>Go</footer>"},
+		// pages that don't fill in a block get the layout's default
+		{"docs/about.html", "<title>default</title>about<footer>Go</footer>"},
+		{"bare.txt", "[GO]"},
+		{"alone.txt", "just Go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.page, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := set.Render(&b, tt.page, Data{"Name": "Go"}); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	set, err := (&Loader{FS: site, Layout: "base", Funcs: funcs}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// .html pages, and the layouts and partials they use, escape data; other pages don't
+	var tests = []struct {
+		page, want string
+	}{
+		{"index.html", "<title>Home</title>hi &lt;i&gt;Go&lt;/i&gt;<footer>&lt;i&gt;Go&lt;/i&gt;</footer>"},
+		{"bare.txt", "[<I>GO</I>]"},
+		{"alone.txt", "just <i>Go</i>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.page, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := set.Render(&b, tt.page, Data{"Name": "<i>Go</i>"}); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrict(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		set, err := (&Loader{FS: site, Layout: "base", Strict: strict, Funcs: funcs}).Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		err = set.Render(&b, "index.html", Data{})
+		switch {
+		case strict && err == nil:
+			t.Errorf("strict: got no error for a missing key")
+		case !strict && err != nil:
+			t.Errorf("not strict: %v", err)
+		case strict && b.Len() > 0:
+			t.Errorf("strict: wrote %q despite failing", b.String())
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	var tests = []struct {
+		name   string
+		fs     fstest.MapFS
+		layout string
+		want   string
+	}{
+		{"missing layout", fstest.MapFS{"pages/a.tmpl": {Data: []byte(`a`)}}, "base", `no layout named "base"`},
+		{"no pages", fstest.MapFS{"layouts/base.tmpl": {Data: []byte(`a`)}}, "base", "file does not exist"},
+		{"duplicate name", fstest.MapFS{
+			"layouts/nav.tmpl":  {Data: []byte(`a`)},
+			"partials/nav.tmpl": {Data: []byte(`b`)},
+		}, "none", `"nav" is already defined`},
+		{"syntax error", fstest.MapFS{"pages/a.tmpl": {Data: []byte(`{{.A`)}}, "none", "unclosed action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&Loader{FS: tt.fs, Layout: tt.layout}).Load()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestData(t *testing.T) {
+	d := Data{"Site": map[string]interface{}{"Title": "a", "URL": "u"}}
+	merge(d, map[string]interface{}{"Site": map[string]interface{}{"Title": "b"}})
+	for _, arg := range []string{"Site.Owner.Name=Lewis", "Greeting=hi=there", "Site.URL=v"} {
+		if err := d.Set(arg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set, err := (&Loader{FS: fstest.MapFS{"pages/p": {Data: []byte(
+		`{{.Site.Title}} {{.Site.URL}} {{.Site.Owner.Name}} {{.Greeting}}`)}}}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := set.Render(&b, "p", d); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "b v Lewis hi=there"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := d.Set("novalue"); err == nil {
+		t.Errorf("Set without = gave no error")
+	}
+}
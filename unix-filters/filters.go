@@ -0,0 +1,544 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Filter is one stage of a pipeline
+// Line is given each input line in turn, and calls emit for every line it passes on; it returns
+// false once it doesn't want any more input, as head does when it has its lines
+// Flush is called at the end of the input, for stages such as tail that only know their output then
+type Filter interface {
+	Line(line string, emit func(string)) bool
+	Flush(emit func(string))
+}
+
+// Mapper is a filter whose output for a line depends only on that line, at most one line for
+// each, so lines can go through it in any order, on several goroutines at once; Map is safe to
+// call concurrently
+type Mapper interface {
+	Filter
+	Map(line string) (out string, keep bool)
+}
+
+// The operations are:
+//   grep [-v] [-c] [-i] pattern   lines matching a regular expression; -v inverts, -c counts
+//   s/pattern/replacement/[gi]    sed-style substitution, with \1 to \9 and & in the replacement
+//   fields [-d sep] [-o sep] list awk-style fields, such as 1,3 or 2- or -1 for the last
+//   uniq [-c] [-d] [-u]           collapse repeated lines; -c counts, -d only repeated, -u only unique
+//   head [n], tail [n]            the first or last n lines, 10 by default
+//   upper, lower                  change case, as the line-filters example does
+//   sha256                        the SHA-256 of each line, in hex
+
+// Parse parses one operation
+func Parse(op string) (Filter, error) {
+	op = strings.TrimSpace(op)
+	if len(op) > 1 && op[0] == 's' && !isWordByte(op[1]) && op[1] != ' ' {
+		return parseSubst(op)
+	}
+
+	words, err := splitWords(op)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty operation")
+	}
+	name, args := words[0], words[1:]
+	switch name {
+	case "grep":
+		return parseGrep(args)
+	case "fields":
+		return parseFields(args)
+	case "uniq":
+		return parseUniq(args)
+	case "head", "tail":
+		n := 10
+		if len(args) > 1 {
+			return nil, fmt.Errorf("%s: too many arguments", name)
+		}
+		if len(args) == 1 {
+			if n, err = strconv.Atoi(strings.TrimPrefix(args[0], "-")); err != nil || n < 0 {
+				return nil, fmt.Errorf("%s: invalid line count %q", name, args[0])
+			}
+		}
+		if name == "head" {
+			return &head{n: n}, nil
+		}
+		return &tail{n: n}, nil
+	case "upper", "lower":
+		if len(args) > 0 {
+			return nil, fmt.Errorf("%s takes no arguments", name)
+		}
+		f := strings.ToUpper
+		if name == "lower" {
+			f = strings.ToLower
+		}
+		return mapper(f), nil
+	case "sha256":
+		if len(args) > 0 {
+			return nil, errors.New("sha256 takes no arguments")
+		}
+		return mapper(func(line string) string {
+			sum := sha256.Sum256([]byte(line))
+			return hex.EncodeToString(sum[:])
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// splitWords splits an operation into words as a shell would, with '...' and "..." quoting and
+// backslash escapes, so a pattern can contain spaces
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == '\'':
+			word.WriteByte(c)
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		case quote != 0:
+			word.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// mapper is a filter that changes every line
+type mapper func(string) string
+
+func (m mapper) Line(line string, emit func(string)) bool {
+	emit(m(line))
+	return true
+}
+
+func (m mapper) Map(line string) (string, bool) {
+	return m(line), true
+}
+
+func (m mapper) Flush(func(string)) {}
+
+// grep passes on the lines that match, or with invert those that don't
+// with count it passes on nothing but the number of lines selected, at the end
+// selected is updated atomically, since Map can be called concurrently
+type grep struct {
+	re            *regexp.Regexp
+	invert, count bool
+	selected      int64
+}
+
+func parseGrep(args []string) (Filter, error) {
+	g := &grep{}
+	icase := false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		for _, c := range args[0][1:] {
+			switch c {
+			case 'v':
+				g.invert = true
+			case 'c':
+				g.count = true
+			case 'i':
+				icase = true
+			default:
+				return nil, fmt.Errorf("grep: unknown flag -%c", c)
+			}
+		}
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return nil, errors.New("grep: want one pattern")
+	}
+	pattern := args[0]
+	if icase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("grep: %v", err)
+	}
+	g.re = re
+	return g, nil
+}
+
+func (g *grep) Line(line string, emit func(string)) bool {
+	if out, ok := g.Map(line); ok {
+		emit(out)
+	}
+	return true
+}
+
+func (g *grep) Map(line string) (string, bool) {
+	if g.re.MatchString(line) == g.invert {
+		return "", false
+	}
+	atomic.AddInt64(&g.selected, 1)
+	return line, !g.count
+}
+
+func (g *grep) Flush(emit func(string)) {
+	if g.count {
+		emit(strconv.FormatInt(atomic.LoadInt64(&g.selected), 10))
+	}
+}
+
+// subst replaces matches of a regular expression, the first in each line or with global all of
+// them
+type subst struct {
+	re     *regexp.Regexp
+	repl   string
+	global bool
+}
+
+// parseSubst parses s/pattern/replacement/flags; any character can take the place of /, as in
+// s|/usr|/opt|, and can appear in the pattern or replacement escaped with a backslash
+func parseSubst(op string) (Filter, error) {
+	delim := op[1]
+	var parts []string
+	var part strings.Builder
+	for i := 2; i < len(op); i++ {
+		c := op[i]
+		switch {
+		case c == '\\' && i+1 < len(op) && op[i+1] == delim:
+			part.WriteByte(delim)
+			i++
+		case c == '\\' && i+1 < len(op):
+			// other escapes are kept for the regexp or the replacement to interpret
+			part.WriteByte(c)
+			part.WriteByte(op[i+1])
+			i++
+		case c == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%s: want s%cpattern%creplacement%c[flags]", op, delim, delim, delim)
+	}
+
+	s := &subst{}
+	pattern := parts[0]
+	for _, f := range part.String() {
+		switch f {
+		case 'g':
+			s.global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return nil, fmt.Errorf("%s: unknown flag %c", op, f)
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+	s.re = re
+	s.repl = sedReplacement(parts[1])
+	return s, nil
+}
+
+// sedReplacement converts a sed replacement to regexp's template syntax: \1 becomes ${1}, & the
+// whole match, \& and \\ literal characters, and a literal $ has to be doubled
+func sedReplacement(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch n := s[i]; {
+			case '0' <= n && n <= '9':
+				b.WriteString("${" + string(n) + "}")
+			case n == 'n':
+				b.WriteByte('\n')
+			case n == 't':
+				b.WriteByte('\t')
+			case n == '$':
+				b.WriteString("$$")
+			default:
+				b.WriteByte(n)
+			}
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (s *subst) Line(line string, emit func(string)) bool {
+	out, _ := s.Map(line)
+	emit(out)
+	return true
+}
+
+func (s *subst) Map(line string) (string, bool) {
+	if s.global {
+		return s.re.ReplaceAllString(line, s.repl), true
+	}
+	m := s.re.FindStringSubmatchIndex(line)
+	if m == nil {
+		return line, true
+	}
+	out := s.re.ExpandString(nil, s.repl, line, m)
+	return line[:m[0]] + string(out) + line[m[1]:], true
+}
+
+func (s *subst) Flush(func(string)) {}
+
+// fields selects fields from each line, as awk '{print $1, $3}' does
+// fields are split on runs of whitespace, as awk does by default, or on a separator; a line
+// without a field selected gives an empty field rather than being dropped
+type fields struct {
+	sep, outSep string
+	ranges      []fieldRange
+}
+
+// fieldRange is a range of fields from 1; negative numbers count back from the last field, so -1
+// is awk's $NF, and a to of 0 means the last field
+type fieldRange struct {
+	from, to int
+}
+
+func parseFields(args []string) (Filter, error) {
+	f := &fields{}
+	outSet := false
+	for len(args) > 1 {
+		switch args[0] {
+		case "-d":
+			f.sep = args[1]
+		case "-o":
+			f.outSep, outSet = args[1], true
+		default:
+			return nil, fmt.Errorf("fields: unknown flag %s", args[0])
+		}
+		args = args[2:]
+	}
+	if len(args) != 1 {
+		return nil, errors.New("fields: want a list of fields, such as 1,3")
+	}
+	if !outSet {
+		f.outSep = " "
+		if f.sep != "" {
+			f.outSep = f.sep
+		}
+	}
+
+	for _, item := range strings.Split(args[0], ",") {
+		r, err := parseRange(item)
+		if err != nil {
+			return nil, fmt.Errorf("fields: %v", err)
+		}
+		f.ranges = append(f.ranges, r)
+	}
+	return f, nil
+}
+
+func parseRange(s string) (fieldRange, error) {
+	atoi := func(s string) (int, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("invalid field %q", s)
+		}
+		return n, nil
+	}
+
+	// a leading - is a negative field, not an open range
+	from, to, isRange := strings.Cut(strings.TrimPrefix(s, "-"), "-")
+	if strings.HasPrefix(s, "-") {
+		from = "-" + from
+	}
+	start, err := atoi(from)
+	if err != nil {
+		return fieldRange{}, err
+	}
+	if !isRange {
+		return fieldRange{start, start}, nil
+	}
+	if to == "" {
+		return fieldRange{start, 0}, nil
+	}
+	end, err := atoi(to)
+	if err != nil {
+		return fieldRange{}, err
+	}
+	return fieldRange{start, end}, nil
+}
+
+func (f *fields) Line(line string, emit func(string)) bool {
+	out, _ := f.Map(line)
+	emit(out)
+	return true
+}
+
+func (f *fields) Map(line string) (string, bool) {
+	var in []string
+	if f.sep == "" {
+		in = strings.Fields(line)
+	} else {
+		in = strings.Split(line, f.sep)
+	}
+
+	// index turns a field number into a slice index
+	index := func(n int) int {
+		if n < 0 {
+			return len(in) + n
+		}
+		return n - 1
+	}
+
+	var out []string
+	for _, r := range f.ranges {
+		from, to := index(r.from), len(in)-1
+		if r.to != 0 {
+			to = index(r.to)
+		}
+		if r.from == r.to {
+			// a single field is always written, even if the line doesn't have it
+			if from >= 0 && from < len(in) {
+				out = append(out, in[from])
+			} else {
+				out = append(out, "")
+			}
+			continue
+		}
+		for i := from; i <= to; i++ {
+			if i >= 0 && i < len(in) {
+				out = append(out, in[i])
+			}
+		}
+	}
+	return strings.Join(out, f.outSep), true
+}
+
+func (f *fields) Flush(func(string)) {}
+
+// uniq collapses runs of identical lines into one, as uniq does; only adjacent lines are compared
+type uniq struct {
+	count, repeated, unique bool
+
+	last string
+	n    int
+}
+
+func parseUniq(args []string) (Filter, error) {
+	u := &uniq{}
+	for _, a := range args {
+		switch a {
+		case "-c":
+			u.count = true
+		case "-d":
+			u.repeated = true
+		case "-u":
+			u.unique = true
+		default:
+			return nil, fmt.Errorf("uniq: unknown flag %s", a)
+		}
+	}
+	return u, nil
+}
+
+func (u *uniq) Line(line string, emit func(string)) bool {
+	if u.n > 0 && line == u.last {
+		u.n++
+		return true
+	}
+	u.Flush(emit)
+	u.last, u.n = line, 1
+	return true
+}
+
+// Flush writes the current run; uniq calls it itself whenever a run ends
+func (u *uniq) Flush(emit func(string)) {
+	if u.n == 0 || u.repeated && u.n == 1 || u.unique && u.n > 1 {
+		return
+	}
+	if u.count {
+		emit(fmt.Sprintf("%7d %s", u.n, u.last))
+	} else {
+		emit(u.last)
+	}
+	u.n = 0
+}
+
+// head passes on the first n lines, then asks for no more input
+type head struct {
+	n, seen int
+}
+
+func (h *head) Line(line string, emit func(string)) bool {
+	if h.seen < h.n {
+		h.seen++
+		emit(line)
+	}
+	return h.seen < h.n
+}
+
+func (h *head) Flush(func(string)) {}
+
+// tail keeps the last n lines in a ring, and passes them on at the end
+type tail struct {
+	n     int
+	ring  []string
+	start int
+}
+
+func (t *tail) Line(line string, emit func(string)) bool {
+	if t.n == 0 {
+		return true
+	}
+	if len(t.ring) < t.n {
+		t.ring = append(t.ring, line)
+	} else {
+		t.ring[t.start] = line
+		t.start = (t.start + 1) % t.n
+	}
+	return true
+}
+
+func (t *tail) Flush(emit func(string)) {
+	for i := range t.ring {
+		emit(t.ring[(t.start+i)%len(t.ring)])
+	}
+}
@@ -0,0 +1,3 @@
+module example/unix-filters
+
+go 1.18
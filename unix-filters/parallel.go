@@ -0,0 +1,187 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A Pipeline runs every filter on one goroutine, which is as fast as reading the input for most
+// operations, but not for ones that take real work per line: regexps on long lines, or hashing
+// ParallelPipeline runs the filters at the start of the pipeline that are Mappers, which only look
+// at one line at a time, on several goroutines, and the rest (uniq, head, tail) in order on one
+// The output is the same as the serial pipeline's, in the same order:
+//   lines are read in batches, each numbered, and handed out to workers
+//   workers send back each batch's output, which can arrive out of order
+//   batches are held until all the ones before them have arrived, then passed on in order
+// At most window lines are in flight, read but not yet passed on, which bounds the memory used
+// however unevenly the work is spread: a slow line holds up the batches behind it, and reading
+// waits until it's done
+
+// batchSize is how many lines go in a batch; sending each line to a worker on its own would spend
+// more time on channel operations than on most filters
+// batches are smaller when the window is too small to give every worker a whole one
+const batchSize = 256
+
+type batch struct {
+	seq   int
+	lines []string
+}
+
+// ParallelPipeline runs a pipeline's Mappers concurrently, keeping the output in order
+type ParallelPipeline struct {
+	filters []Filter
+	mappers []Mapper
+	rest    *Pipeline
+
+	jobs    chan batch
+	results chan batch
+	window  chan struct{}
+	workers sync.WaitGroup
+	ordered sync.WaitGroup
+
+	size    int
+	next    batch
+	stopped int32
+}
+
+// NewParallelPipeline connects filters as NewPipeline does, running the Mappers at the start on
+// workers goroutines, with at most window lines read but not yet passed on
+func NewParallelPipeline(out func(string), workers, window int, filters ...Filter) *ParallelPipeline {
+	var mappers []Mapper
+	for _, f := range filters {
+		m, ok := f.(Mapper)
+		if !ok {
+			break
+		}
+		mappers = append(mappers, m)
+	}
+
+	// every worker should have a batch to work on, if the window has room for that many
+	size := batchSize
+	if window/workers < size {
+		size = window / workers
+	}
+	if size < 1 {
+		size = 1
+	}
+	batches := window / size
+	if batches < 1 {
+		batches = 1
+	}
+	p := &ParallelPipeline{
+		filters: filters,
+		mappers: mappers,
+		rest:    NewPipeline(out, filters[len(mappers):]...),
+		jobs:    make(chan batch, batches),
+		results: make(chan batch, batches),
+		window:  make(chan struct{}, batches),
+		size:    size,
+	}
+
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.work()
+	}
+	p.ordered.Add(1)
+	go p.reorder()
+	return p
+}
+
+// mapLine runs a line through the Mappers from the ith on
+func (p *ParallelPipeline) mapLine(line string, i int) (string, bool) {
+	for _, m := range p.mappers[i:] {
+		var keep bool
+		if line, keep = m.Map(line); !keep {
+			return "", false
+		}
+	}
+	return line, true
+}
+
+// work maps batches, reusing each batch's slice for its output
+func (p *ParallelPipeline) work() {
+	defer p.workers.Done()
+	for b := range p.jobs {
+		out := b.lines[:0]
+		for _, line := range b.lines {
+			if line, ok := p.mapLine(line, 0); ok {
+				out = append(out, line)
+			}
+		}
+		p.results <- batch{b.seq, out}
+	}
+}
+
+// reorder passes batches on to the rest of the pipeline in order
+func (p *ParallelPipeline) reorder() {
+	defer p.ordered.Done()
+	pending := make(map[int][]string)
+	next := 0
+	for b := range p.results {
+		pending[b.seq] = b.lines
+		for {
+			lines, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			// once the rest of the pipeline wants no more, batches still in flight are dropped
+			if atomic.LoadInt32(&p.stopped) == 0 {
+				for _, line := range lines {
+					if !p.rest.Line(line) {
+						atomic.StoreInt32(&p.stopped, 1)
+						break
+					}
+				}
+			}
+			<-p.window
+		}
+	}
+}
+
+// Line feeds one line into the pipeline, and reports whether it wants more
+func (p *ParallelPipeline) Line(line string) bool {
+	if p.next.lines == nil {
+		// a batch takes its place in the window as it's started, as its lines have been read
+		p.window <- struct{}{}
+		p.next.lines = make([]string, 0, p.size)
+	}
+	p.next.lines = append(p.next.lines, line)
+	if len(p.next.lines) == p.size {
+		p.send()
+	}
+	return atomic.LoadInt32(&p.stopped) == 0
+}
+
+func (p *ParallelPipeline) send() {
+	p.jobs <- p.next
+	p.next = batch{seq: p.next.seq + 1}
+}
+
+// Flush ends the input, waiting for every batch to be passed on before flushing the filters
+// a Mapper's flushed output, such as grep -c's count, goes through the Mappers after it here,
+// as the serial pipeline would send it
+func (p *ParallelPipeline) Flush() {
+	if len(p.next.lines) > 0 {
+		p.send()
+	}
+	close(p.jobs)
+	p.workers.Wait()
+	close(p.results)
+	p.ordered.Wait()
+
+	for i, m := range p.mappers {
+		m.Flush(func(line string) {
+			if line, ok := p.mapLine(line, i+1); ok {
+				p.rest.Line(line)
+			}
+		})
+	}
+	p.rest.Flush()
+}
+
+// Selected reports whether any line was selected, for grep's exit status
+func (p *ParallelPipeline) Selected(outputs int) bool {
+	return selected(p.filters, outputs)
+}
@@ -0,0 +1,58 @@
+package main
+
+import "sync/atomic"
+
+// Pipeline runs lines through a list of filters, each one's output being the next one's input,
+// as a shell pipeline of grep, sed, awk and friends would, but in one process
+type Pipeline struct {
+	filters []Filter
+	emits   []func(string)
+
+	// done is set once a filter wants no more input: nothing read after that could change the
+	// output
+	done bool
+}
+
+// NewPipeline connects filters, with the last one's output going to out
+func NewPipeline(out func(string), filters ...Filter) *Pipeline {
+	p := &Pipeline{filters: filters, emits: make([]func(string), len(filters)+1)}
+	p.emits[len(filters)] = out
+	for i := len(filters) - 1; i >= 0; i-- {
+		f, next := filters[i], p.emits[i+1]
+		p.emits[i] = func(line string) {
+			if !f.Line(line, next) {
+				p.done = true
+			}
+		}
+	}
+	return p
+}
+
+// Line feeds one line into the pipeline, and reports whether it wants more
+func (p *Pipeline) Line(line string) bool {
+	p.emits[0](line)
+	return !p.done
+}
+
+// Flush ends the input, flushing each filter in turn so its output goes through the ones after it
+func (p *Pipeline) Flush() {
+	for i, f := range p.filters {
+		f.Flush(p.emits[i+1])
+	}
+}
+
+// Selected reports whether any line was selected, for grep's exit status
+func (p *Pipeline) Selected(outputs int) bool {
+	return selected(p.filters, outputs)
+}
+
+// selected is whether the last grep selected any lines if there is one, otherwise whether there
+// was any output
+func selected(filters []Filter, outputs int) bool {
+	for i := len(filters) - 1; i >= 0; i-- {
+		if g, ok := filters[i].(*grep); ok {
+			return atomic.LoadInt64(&g.selected) > 0
+		}
+	}
+	return outputs > 0
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The line-filters example reads with a default bufio.Scanner, which has two limits: a line
+// longer than 64KiB stops it with "token too long", and a line is whatever ends in a newline
+// This file sets up a Scanner without either: the longest record is configurable, or unlimited,
+// and records can be split in other ways:
+//   lines   ends at \n, with a \r before it dropped, as bufio.ScanLines does
+//   crlf    ends at \r\n only, so a lone \n is part of the record, as in CSV files from Windows
+//   nul     ends at a NUL byte, as find -print0 and xargs -0 use
+//   csv     ends at a newline that isn't inside a quoted field
+//   fixed:N every N bytes, for fixed-width records without separators
+// Input is converted to UTF-8 first: a byte order mark says what it is, and without one UTF-16 is
+// recognised by the zero bytes in every other position that ASCII text has in it
+
+// ScanOptions configures NewScanner
+type ScanOptions struct {
+	// Split is how the input is split into records, as listed above; "" means lines
+	Split string
+
+	// MaxRecord is the longest record allowed, in bytes; 0 means there's no limit, and records are
+	// held in a buffer that grows as they need
+	MaxRecord int
+
+	// Encoding is "auto", to detect it, or one of "utf-8", "utf-16le" or "utf-16be"
+	Encoding string
+}
+
+// errTooLong replaces bufio.ErrTooLong, to say what can be done about it
+type errTooLong int
+
+func (e errTooLong) Error() string {
+	return fmt.Sprintf("record longer than %d bytes; raise -max or use -max 0 for no limit", int(e))
+}
+
+// Scanner reads records, as configured by ScanOptions
+type Scanner struct {
+	*bufio.Scanner
+	max int
+}
+
+// Err reports the first error, apart from io.EOF
+func (s *Scanner) Err() error {
+	err := s.Scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return errTooLong(s.max)
+	}
+	return err
+}
+
+// NewScanner returns a Scanner reading records from r
+func NewScanner(r io.Reader, opts ScanOptions) (*Scanner, error) {
+	split, err := splitFunc(opts.Split)
+	if err != nil {
+		return nil, err
+	}
+	// NUL-separated input has zero bytes that aren't UTF-16
+	r, err = decode(r, opts.Encoding, opts.Split != "nul")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scanner{Scanner: bufio.NewScanner(r), max: opts.MaxRecord}
+	if opts.MaxRecord <= 0 {
+		s.Split(split)
+		s.Buffer(make([]byte, 4096), math.MaxInt)
+		return s, nil
+	}
+	s.Split(limit(split, opts.MaxRecord))
+	// the buffer has to hold a whole record and its separator, which is at most two bytes
+	// the Scanner only checks its limit when it grows the buffer, so the buffer mustn't start out
+	// any bigger than that
+	max := opts.MaxRecord + 2
+	size := 4096
+	if size > max {
+		size = max
+	}
+	s.Buffer(make([]byte, size), max)
+	return s, nil
+}
+
+// limit fails records longer than max; the buffer limit alone lets through records up to the
+// separator's length over it
+func limit(split bufio.SplitFunc, max int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if err == nil && len(token) > max {
+			return 0, nil, errTooLong(max)
+		}
+		return advance, token, err
+	}
+}
+
+// Separator is what the output records should end with, to match the input
+func Separator(split string) string {
+	switch split {
+	case "nul":
+		return "\x00"
+	case "crlf":
+		return "\r\n"
+	}
+	return "\n"
+}
+
+func splitFunc(split string) (bufio.SplitFunc, error) {
+	switch split {
+	case "", "lines":
+		return bufio.ScanLines, nil
+	case "crlf":
+		return splitAt([]byte("\r\n")), nil
+	case "nul":
+		return splitAt([]byte{0}), nil
+	case "csv":
+		return splitCSV(), nil
+	}
+	if strings.HasPrefix(split, "fixed:") {
+		w := strings.TrimPrefix(split, "fixed:")
+		n, err := strconv.Atoi(w)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid record width %q", w)
+		}
+		return splitFixed(n), nil
+	}
+	return nil, fmt.Errorf("unknown split %q: want lines, crlf, nul, csv or fixed:N", split)
+}
+
+// splitAt splits records at a separator
+// a final record without a separator is still a record, as a last line without a newline is
+func splitAt(sep []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.Index(data, sep); i >= 0 {
+			return i + len(sep), data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// splitFixed splits records every n bytes; the last record can be shorter
+func splitFixed(n int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) >= n {
+			return n, data[:n], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// splitCSV splits CSV records, which end at a newline outside quotes; "" inside a quoted field is
+// an escaped quote, which toggles the state twice and so needs no special case
+// the Scanner calls a split function again with more data when it asks for it, starting at the
+// same place, so the position reached and whether it's inside quotes are kept between calls rather
+// than scanning the record from the start each time
+func splitCSV() bufio.SplitFunc {
+	scanned, quoted := 0, false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		for i := scanned; i < len(data); i++ {
+			switch data[i] {
+			case '"':
+				quoted = !quoted
+			case '\n':
+				if !quoted {
+					scanned = 0
+					return i + 1, bytes.TrimSuffix(data[:i], []byte{'\r'}), nil
+				}
+			}
+		}
+		scanned = len(data)
+		if atEOF && len(data) > 0 {
+			// an unterminated quote runs to the end of the input; the CSV reader will object
+			scanned, quoted = 0, false
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// decode returns a reader that converts r to UTF-8, dropping any byte order mark
+func decode(r io.Reader, encoding string, sniff bool) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	switch encoding {
+	case "utf-8":
+		return br, nil
+	case "utf-16le":
+		return &utf16Reader{r: br, little: true}, nil
+	case "utf-16be":
+		return &utf16Reader{r: br}, nil
+	case "", "auto":
+	default:
+		return nil, fmt.Errorf("unknown encoding %q: want auto, utf-8, utf-16le or utf-16be", encoding)
+	}
+
+	// Peek returns what there is, with an error, if the input is shorter than asked for
+	head, _ := br.Peek(512)
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		br.Discard(3)
+		return br, nil
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		br.Discard(2)
+		return &utf16Reader{r: br, little: true}, nil
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		br.Discard(2)
+		return &utf16Reader{r: br}, nil
+	}
+	if sniff {
+		switch looksUTF16(head) {
+		case "le":
+			return &utf16Reader{r: br, little: true}, nil
+		case "be":
+			return &utf16Reader{r: br}, nil
+		}
+	}
+	return br, nil
+}
+
+// looksUTF16 guesses whether text without a byte order mark is UTF-16, and which way round
+// text that's mostly ASCII has a zero in every other byte in UTF-16, and rarely otherwise
+func looksUTF16(head []byte) string {
+	if len(head) < 4 {
+		return ""
+	}
+	var zeros [2]int
+	for i, b := range head {
+		if b == 0 {
+			zeros[i%2]++
+		}
+	}
+	half := len(head) / 2
+	switch {
+	case zeros[1] > half*3/4 && zeros[0] == 0:
+		return "le"
+	case zeros[0] > half*3/4 && zeros[1] == 0:
+		return "be"
+	}
+	return ""
+}
+
+// utf16Reader converts UTF-16 to UTF-8
+type utf16Reader struct {
+	r      *bufio.Reader
+	little bool
+	out    []byte
+	err    error
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.out) == 0 {
+		if u.err != nil {
+			return 0, u.err
+		}
+		u.fill()
+	}
+	n := copy(p, u.out)
+	u.out = u.out[n:]
+	return n, nil
+}
+
+// fill decodes the next run of code units into out
+func (u *utf16Reader) fill() {
+	var buf [4]byte
+	u.out = u.out[:0]
+	for i := 0; i < 1024 && u.err == nil; i++ {
+		c, err := u.unit()
+		if err != nil {
+			u.err = err
+			break
+		}
+		r := rune(c)
+		if utf16.IsSurrogate(r) {
+			// a surrogate pair, or an unpaired surrogate that becomes U+FFFD
+			next, err := u.r.Peek(2)
+			if err == nil && isLowSurrogate(u.order(next)) {
+				u.r.Discard(2)
+				r = utf16.DecodeRune(r, rune(u.order(next)))
+			} else {
+				r = utf8.RuneError
+			}
+		}
+		n := utf8.EncodeRune(buf[:], r)
+		u.out = append(u.out, buf[:n]...)
+	}
+}
+
+func isLowSurrogate(c uint16) bool {
+	return 0xDC00 <= c && c <= 0xDFFF
+}
+
+func (u *utf16Reader) order(b []byte) uint16 {
+	if u.little {
+		return uint16(b[0]) | uint16(b[1])<<8
+	}
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+// unit reads one code unit; an odd byte at the end becomes U+FFFD
+func (u *utf16Reader) unit() (uint16, error) {
+	var b [2]byte
+	n, err := io.ReadFull(u.r, b[:])
+	switch {
+	case n == 1:
+		return utf8.RuneError, nil
+	case err != nil:
+		return 0, err
+	}
+	return u.order(b[:]), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The line-filters example reads stdin and upper-cases it; grep and sed are mentioned, but it can
+// only do the one thing
+// Here's a filter command with the operations of grep, sed, awk, uniq, head and tail (filters.go),
+// composed into a pipeline (pipeline.go) so one command can do what would take several in a shell
+
+// Like grep, the exit status is 0 if any line was selected, 1 if none was, and 2 if there was an
+// error, so it can be used in shell conditions
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (s *stringList) String() string     { return strings.Join(*s, "; ") }
+func (s *stringList) Set(v string) error { *s = append(*s, v); return nil }
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+
+	// Operations are given with -e, in order, and files after them; with no files, or -, it reads
+	// stdin
+	// >> go run . -e 'grep -i error' -e 's/^([0-9-]+) .*(ERROR|Error)/\1 \2/' -e uniq app.log
+
+	// With a single operation, -e can be left out, as with sed
+	// >> printf 'b\na\nb\nb\n' | go run . 'uniq -c'
+	//       1 b
+	//       1 a
+	//       2 b
+
+	// awk-style fields, here the login and shell of every user, as awk -F: '{print $1, $NF}'
+	// >> go run . -e 'fields -d : -o " " 1,-1' -e 'head 3' /etc/passwd
+
+	// Records needn't be lines: here, files named by find -print0, which can contain newlines
+	// >> find . -print0 | go run . -split nul 'grep _test' | xargs -0 wc -l
+
+	// Input in UTF-16, as Windows tools often write, is converted to UTF-8
+	// >> go run . 'grep -i error' windows.log
+
+	// CPU-heavy operations can run on several goroutines, with the output still in input order
+	// >> go run . -j 8 -e 's/([a-z]+)@([a-z.]+)/\2 \1/g' -e sha256 -e 'head 5' big.log
+
+	// The exit status works as grep's does
+	// >> go run . 'grep -c root' /etc/passwd && echo found
+}
+
+// run is the whole command, with its input and output passed in so it can be tested
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("unix-filters", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var ops stringList
+	flags.Var(&ops, "e", "an `operation`; can be repeated, making a pipeline")
+	var opts ScanOptions
+	flags.StringVar(&opts.Split, "split", "lines", "how records are separated: lines, crlf, nul, csv or fixed:N")
+	flags.IntVar(&opts.MaxRecord, "max", 0, "longest record allowed, in bytes; 0 for no limit")
+	flags.StringVar(&opts.Encoding, "encoding", "auto", "input encoding: auto, utf-8, utf-16le or utf-16be")
+	workers := flags.Int("j", 1, "goroutines to run grep, s///, fields, upper, lower and sha256 on; output stays in input order")
+	window := flags.Int("window", 16*batchSize, "with -j, the most records read ahead of the output")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: unix-filters [flags] [-e operation]... [file...]")
+		fmt.Fprintln(stderr, "       unix-filters [flags] operation [file...]")
+		fmt.Fprintln(stderr, "operations:")
+		fmt.Fprintln(stderr, "  grep [-v] [-c] [-i] pattern")
+		fmt.Fprintln(stderr, "  s/pattern/replacement/[gi]")
+		fmt.Fprintln(stderr, "  fields [-d sep] [-o sep] list")
+		fmt.Fprintln(stderr, "  uniq [-c] [-d] [-u]")
+		fmt.Fprintln(stderr, "  head [n], tail [n], upper, lower, sha256")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	files := flags.Args()
+	if len(ops) == 0 {
+		if len(files) == 0 {
+			flags.Usage()
+			return 2
+		}
+		ops, files = files[:1], files[1:]
+	}
+
+	var filters []Filter
+	for _, op := range ops {
+		f, err := Parse(op)
+		if err != nil {
+			fmt.Fprintln(stderr, "unix-filters:", err)
+			return 2
+		}
+		filters = append(filters, f)
+	}
+
+	// check the options before reading anything
+	if _, err := NewScanner(strings.NewReader(""), opts); err != nil {
+		fmt.Fprintln(stderr, "unix-filters:", err)
+		return 2
+	}
+
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+	sep := Separator(opts.Split)
+	outputs := 0
+	out := func(line string) {
+		outputs++
+		w.WriteString(line)
+		w.WriteString(sep)
+	}
+	var p runner
+	if _, ok := filters[0].(Mapper); ok && *workers > 1 {
+		p = NewParallelPipeline(out, *workers, *window, filters...)
+	} else {
+		p = NewPipeline(out, filters...)
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	failed := false
+	for _, name := range files {
+		// like grep, a file that can't be read is reported, and the others are still filtered
+		more, err := feed(p, name, stdin, opts)
+		if err != nil {
+			fmt.Fprintln(stderr, "unix-filters:", err)
+			failed = true
+		}
+		if !more {
+			break
+		}
+	}
+	p.Flush()
+
+	switch {
+	case failed:
+		return 2
+	case p.Selected(outputs):
+		return 0
+	default:
+		return 1
+	}
+}
+
+// runner is a Pipeline or a ParallelPipeline
+type runner interface {
+	Line(line string) bool
+	Flush()
+	Selected(outputs int) bool
+}
+
+// feed reads the records of a file, or stdin for -, into the pipeline until it wants no more,
+// and reports whether it still wants more
+func feed(p runner, name string, stdin io.Reader, opts ScanOptions) (bool, error) {
+	r := stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return true, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scanner, err := NewScanner(r, opts)
+	if err != nil {
+		return true, err
+	}
+	for scanner.Scan() {
+		if !p.Line(scanner.Text()) {
+			return false, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, fmt.Errorf("%s: %v", name, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const input = `2024-03-14 INFO  started
+2024-03-14 ERROR disk full
+2024-03-14 error retrying
+2024-03-15 INFO  done
+2024-03-15 INFO  done
+`
+
+func TestRun(t *testing.T) {
+	var tests = []struct {
+		name   string
+		args   []string
+		input  string
+		want   string
+		status int
+	}{
+		{"grep", []string{"grep ERROR"}, input, "2024-03-14 ERROR disk full\n", 0},
+		{"grep -i", []string{"grep -i error"}, input, "2024-03-14 ERROR disk full\n2024-03-14 error retrying\n", 0},
+		{"grep -v -c", []string{"grep -vc INFO"}, input, "2\n", 0},
+		{"grep -c none", []string{"grep -c WARN"}, input, "0\n", 1},
+		{"grep no match", []string{"grep WARN"}, input, "", 1},
+		{"quoted pattern", []string{`grep "INFO  done"`}, input, "2024-03-15 INFO  done\n2024-03-15 INFO  done\n", 0},
+		{"subst first", []string{"s/o/0/"}, "foo boo\n", "f0o boo\n", 0},
+		{"subst global", []string{"s/o/0/g"}, "foo boo\n", "f00 b00\n", 0},
+		{"subst groups", []string{`s/([a-z]+)@([a-z.]+)/\2 has \1 (&)/`}, "mail bob@example.com\n",
+			"mail example.com has bob (bob@example.com)\n", 0},
+		{"subst delimiter", []string{`s|/usr/\(x\)|/opt$|i`}, "/USR/(x)/bin\n", "/opt$/bin\n", 0},
+		{"subst escaped delimiter", []string{`s/\//\\/g`}, "a/b/c\n", `a\b\c` + "\n", 0},
+		{"fields", []string{"fields 1,3"}, "  a  b   c d\n", "a c\n", 0},
+		{"fields last", []string{"fields -d : -o , -1,1"}, "root:x:0:/bin/sh\n", "/bin/sh,root\n", 0},
+		{"fields range", []string{"fields -d : 2-"}, "a:b:c:d\n", "b:c:d\n", 0},
+		{"fields missing", []string{"fields 1,5,2"}, "a b\n", "a  b\n", 0},
+		{"uniq", []string{"uniq"}, "a\na\nb\na\n", "a\nb\na\n", 0},
+		{"uniq -c", []string{"uniq -c"}, "a\na\nb\n", "      2 a\n      1 b\n", 0},
+		{"uniq -d", []string{"uniq -d"}, "a\na\nb\nc\nc\n", "a\nc\n", 0},
+		{"uniq -u", []string{"uniq -u"}, "a\na\nb\nc\nc\n", "b\n", 0},
+		{"head", []string{"head 2"}, "1\n2\n3\n", "1\n2\n", 0},
+		{"head default", []string{"head"}, strings.Repeat("x\n", 12), strings.Repeat("x\n", 10), 0},
+		{"tail", []string{"tail -2"}, "1\n2\n3\n", "2\n3\n", 0},
+		{"tail short", []string{"tail 5"}, "1\n2\n", "1\n2\n", 0},
+		{"empty input", []string{"upper"}, "", "", 1},
+		{"pipeline", []string{"-e", "grep INFO", "-e", "fields 1,3", "-e", "uniq -c", "-e", "upper"}, input,
+			"      1 2024-03-14 STARTED\n      2 2024-03-15 DONE\n", 0},
+		{"flush through later filters", []string{"-e", "tail 2", "-e", "upper", "-e", "head 1"}, "a\nb\nc\n", "B\n", 0},
+		{"grep status is the last grep's", []string{"-e", "grep -c WARN", "-e", "grep 0"}, input, "0\n", 0},
+		{"bad regexp", []string{"grep ("}, input, "", 2},
+		{"bad operation", []string{"sort"}, input, "", 2},
+		{"bad subst", []string{"s/a/b"}, input, "", 2},
+		{"no operation", nil, input, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errs bytes.Buffer
+			status := run(tt.args, strings.NewReader(tt.input), &out, &errs)
+			if got := out.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if status != tt.status {
+				t.Errorf("status: got %d, want %d (%s)", status, tt.status, errs.String())
+			}
+		})
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.WriteFile(a, []byte("one\ntwo\n"), 0644)
+	os.WriteFile(b, []byte("three\n"), 0644)
+
+	var out, errs bytes.Buffer
+	if status := run([]string{"grep e", a, "-", b}, strings.NewReader("eleven\n"), &out, &errs); status != 0 {
+		t.Errorf("status: got %d, want 0 (%s)", status, errs.String())
+	}
+	if got, want := out.String(), "one\neleven\nthree\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// a missing file is an error, but the others are still read
+	out.Reset()
+	errs.Reset()
+	if status := run([]string{"grep e", filepath.Join(dir, "missing"), b}, nil, &out, &errs); status != 2 {
+		t.Errorf("status: got %d, want 2", status)
+	}
+	if out.String() != "three\n" || !strings.Contains(errs.String(), "missing") {
+		t.Errorf("got output %q and errors %q", out.String(), errs.String())
+	}
+}
+
+// encodeUTF16 encodes s as UTF-16, little or big endian, with an optional byte order mark
+func encodeUTF16(s string, little, bom bool) string {
+	var b []byte
+	put := func(c uint16) {
+		if little {
+			b = append(b, byte(c), byte(c>>8))
+		} else {
+			b = append(b, byte(c>>8), byte(c))
+		}
+	}
+	if bom {
+		put(0xFEFF)
+	}
+	for _, r := range s {
+		if r >= 0x10000 {
+			r -= 0x10000
+			put(uint16(0xD800 + r>>10))
+			put(uint16(0xDC00 + r&0x3FF))
+			continue
+		}
+		put(uint16(r))
+	}
+	return string(b)
+}
+
+func TestScanner(t *testing.T) {
+	long := strings.Repeat("x", 100000)
+
+	var tests = []struct {
+		name  string
+		opts  ScanOptions
+		input string
+		want  []string
+		err   string
+	}{
+		{"lines", ScanOptions{}, "a\r\nb\nc", []string{"a", "b", "c"}, ""},
+		{"long line", ScanOptions{}, "a\n" + long + "\nb\n", []string{"a", long, "b"}, ""},
+		{"too long", ScanOptions{MaxRecord: 1000}, "a\n" + long + "\n", []string{"a"}, "record longer than 1000 bytes"},
+		{"at the limit", ScanOptions{MaxRecord: 3}, "abc\r\nde\n", []string{"abc", "de"}, ""},
+		{"over the limit", ScanOptions{MaxRecord: 3}, "abcd\n", nil, "record longer than 3 bytes"},
+		{"over the limit after a record", ScanOptions{MaxRecord: 3}, "ab\nabcde\n", []string{"ab"}, "record longer than 3 bytes"},
+		{"over the limit, fixed", ScanOptions{Split: "fixed:4", MaxRecord: 3}, "abcd", nil, "record longer than 3 bytes"},
+		{"over the limit above 4KiB", ScanOptions{MaxRecord: 5000}, strings.Repeat("y", 5001) + "\n", nil, "record longer than 5000 bytes"},
+		{"crlf", ScanOptions{Split: "crlf"}, "a\nb\r\nc\r\n", []string{"a\nb", "c"}, ""},
+		{"nul", ScanOptions{Split: "nul"}, "a b\x00c\nd\x00", []string{"a b", "c\nd"}, ""},
+		{"nul isn't utf-16", ScanOptions{Split: "nul"}, "a\x00b\x00c\x00d\x00", []string{"a", "b", "c", "d"}, ""},
+		{"csv", ScanOptions{Split: "csv"}, "id,note\r\n1,\"two\nlines\"\n2,\"say \"\"hi\"\"\nthere\"\n3,x",
+			[]string{"id,note", "1,\"two\nlines\"", "2,\"say \"\"hi\"\"\nthere\"", "3,x"}, ""},
+		{"csv long quoted field", ScanOptions{Split: "csv"}, "\"" + long + "\n" + long + "\"\nz\n",
+			[]string{"\"" + long + "\n" + long + "\"", "z"}, ""},
+		{"fixed", ScanOptions{Split: "fixed:3"}, "abcdefgh", []string{"abc", "def", "gh"}, ""},
+		{"utf-8 bom", ScanOptions{}, "\xEF\xBB\xBFcafé\n", []string{"café"}, ""},
+		{"utf-16le bom", ScanOptions{}, encodeUTF16("café\r\n🌱 ok\r\n", true, true), []string{"café", "🌱 ok"}, ""},
+		{"utf-16be bom", ScanOptions{}, encodeUTF16("café\n", false, true), []string{"café"}, ""},
+		{"utf-16le sniffed", ScanOptions{}, encodeUTF16("plain text\nmore\n", true, false), []string{"plain text", "more"}, ""},
+		{"utf-16be sniffed", ScanOptions{}, encodeUTF16("plain text\n", false, false), []string{"plain text"}, ""},
+		{"utf-16 named", ScanOptions{Encoding: "utf-16be"}, encodeUTF16("ab", false, false), []string{"ab"}, ""},
+		{"unpaired surrogate", ScanOptions{Encoding: "utf-16le"}, "\x00\xD8a\x00", []string{"�a"}, ""},
+		{"odd byte", ScanOptions{Encoding: "utf-16le"}, "a\x00b", []string{"a�"}, ""},
+		{"latin text isn't utf-16", ScanOptions{}, "caf\xe9\n", []string{"caf\xe9"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewScanner(strings.NewReader(tt.input), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for s.Scan() {
+				got = append(got, s.Text())
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			err = s.Err()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("got error %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	for _, opts := range []ScanOptions{{Split: "fixed:0"}, {Split: "tabs"}, {Encoding: "latin1"}} {
+		if _, err := NewScanner(strings.NewReader(""), opts); err == nil {
+			t.Errorf("%+v: got no error", opts)
+		}
+	}
+}
+
+func TestRunSplit(t *testing.T) {
+	var out, errs bytes.Buffer
+	status := run([]string{"-split", "nul", "grep -v skip"}, strings.NewReader("a\nb\x00skip\x00c"), &out, &errs)
+	if got, want := out.String(), "a\nb\x00c\x00"; got != want || status != 0 {
+		t.Errorf("got %q and status %d, want %q and 0", got, status, want)
+	}
+}
+
+// logLines generates n lines of a made-up log
+func logLines(n int) string {
+	var b strings.Builder
+	levels := []string{"INFO", "ERROR", "debug", "WARN"}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "2024-03-%02d %s user%d@example.com did thing %d\n", i%28+1, levels[i%len(levels)], i%97, i)
+	}
+	return b.String()
+}
+
+func TestParallel(t *testing.T) {
+	input := logLines(5000)
+
+	var tests = [][]string{
+		{"-e", "grep -i error", "-e", "s/([a-z0-9]+)@([a-z.]+)/\\2 \\1/g", "-e", "fields 1,3,-1"},
+		{"-e", "grep -v INFO", "-e", "sha256"},
+		{"-e", "grep -c WARN"},
+		{"-e", "grep -c WARN", "-e", "s/^/count: /"},
+		{"-e", "fields 2", "-e", "uniq -c"},
+		{"-e", "upper", "-e", "head 300"},
+		{"-e", "grep ERROR", "-e", "tail 3"},
+		{"-e", "grep nothing"},
+		// not Mappers, so run serially whatever -j says
+		{"-e", "uniq", "-e", "upper"},
+	}
+	for _, args := range tests {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			var want bytes.Buffer
+			wantStatus := run(args, strings.NewReader(input), &want, io.Discard)
+
+			for _, window := range []string{"1", "1000", "100000"} {
+				var got bytes.Buffer
+				status := run(append([]string{"-j", "4", "-window", window}, args...), strings.NewReader(input), &got, io.Discard)
+				if got.String() != want.String() {
+					t.Errorf("window %s: output differs from serial: got %d bytes, want %d", window, got.Len(), want.Len())
+				}
+				if status != wantStatus {
+					t.Errorf("window %s: status: got %d, want %d", window, status, wantStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestParallelWindow(t *testing.T) {
+	for _, window := range []int{1, 3, 5, 300, 5000} {
+		// lines read but not yet written never number more than the window
+		var fed, written int64
+		p := NewParallelPipeline(func(string) { atomic.AddInt64(&written, 1) }, 4, window, mapper(strings.ToUpper))
+		for i := 0; i < 3*window+1000; i++ {
+			fed++
+			p.Line("x")
+			if ahead := fed - atomic.LoadInt64(&written); ahead > int64(window) {
+				t.Fatalf("window %d: %d lines read ahead", window, ahead)
+			}
+		}
+		p.Flush()
+		if written != fed {
+			t.Errorf("window %d: got %d lines, want %d", window, written, fed)
+		}
+	}
+}
+
+// benchmarkPipeline runs a CPU-heavy pipeline over 100,000 lines, serially for workers 1
+func benchmarkPipeline(b *testing.B, workers int) {
+	input := logLines(100000)
+	args := []string{"-j", strconv.Itoa(workers),
+		"-e", `s/(\w+)@(\w+)\.(\w+)/\3.\2 \1/g`, "-e", "grep -i (error|warn)", "-e", "sha256"}
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		run(args, strings.NewReader(input), io.Discard, io.Discard)
+	}
+}
+
+func BenchmarkSerial(b *testing.B)    { benchmarkPipeline(b, 1) }
+func BenchmarkParallel2(b *testing.B) { benchmarkPipeline(b, 2) }
+func BenchmarkParallel4(b *testing.B) { benchmarkPipeline(b, 4) }
+func BenchmarkParallel8(b *testing.B) { benchmarkPipeline(b, 8) }
+
+// Compare them with
+// >> go test -bench . -benchmem
@@ -0,0 +1,5 @@
+module example/web-crawler
+
+go 1.18
+
+require golang.org/x/net v0.7.0
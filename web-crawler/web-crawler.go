@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Here we'll combine the http-clients and worker-pools examples into a web crawler
+
+// Starting from a seed URL, the crawler fetches pages with a bounded pool of workers, pulls the
+// links out of each page with an HTML tokenizer, and queues the ones it hasn't seen before
+// It stays on the seed's host and under a path prefix, obeys robots.txt, and waits between
+// requests to the same host so it doesn't overload the site
+
+// The result is a site map printed as JSON Lines: one JSON object per fetched page
+
+// Page is one line of the site map
+type Page struct {
+	URL         string `json:"url"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	DurationMS  int64  `json:"duration_ms"`
+	Depth       int    `json:"depth"`
+	Referrer    string `json:"referrer,omitempty"`
+	RedirectTo  string `json:"redirect_to,omitempty"`
+	Links       int    `json:"links"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Crawler holds the configuration for a crawl
+type Crawler struct {
+	Client *http.Client
+
+	// UserAgent is sent with every request, and used to pick the robots.txt rules that apply
+	UserAgent string
+
+	// Workers is the number of concurrent fetches
+	Workers int
+
+	// Delay is the minimum time between requests to the same host
+	// a longer Crawl-delay in robots.txt takes precedence
+	Delay time.Duration
+
+	// MaxPages and MaxDepth bound the crawl; zero means no limit
+	MaxPages int
+	MaxDepth int
+
+	// Prefix restricts the crawl to paths under it; it defaults to the seed's directory
+	Prefix string
+
+	// pages is Client, set up by Crawl to check redirects as it checks links; robots.txt is
+	// fetched with Client itself, as it's always outside the prefix
+	pages *http.Client
+}
+
+// job is a URL waiting to be fetched
+type job struct {
+	url      *url.URL
+	depth    int
+	referrer string
+}
+
+// result is a fetched page along with the links found on it
+type result struct {
+	page  Page
+	final *url.URL
+	links []*url.URL
+	depth int
+}
+
+// Normalise puts a URL into a canonical form so that trivially different spellings of the same
+// address are only fetched once: the scheme and host are lowercased, default ports and fragments
+// are dropped, dot segments are resolved, and query parameters are sorted
+func Normalise(u *url.URL) *url.URL {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	if (n.Scheme == "http" && strings.HasSuffix(n.Host, ":80")) ||
+		(n.Scheme == "https" && strings.HasSuffix(n.Host, ":443")) {
+		n.Host = n.Host[:strings.LastIndex(n.Host, ":")]
+	}
+
+	n.Fragment = ""
+	n.RawFragment = ""
+	n.User = nil
+
+	if n.Path == "" {
+		n.Path = "/"
+	} else {
+		// path.Clean drops a trailing slash, but /docs and /docs/ can be different pages
+		trailing := strings.HasSuffix(n.Path, "/")
+		n.Path = path.Clean(n.Path)
+		if trailing && n.Path != "/" {
+			n.Path += "/"
+		}
+	}
+	n.RawPath = ""
+
+	if n.RawQuery != "" {
+		n.RawQuery = n.Query().Encode()
+	}
+	n.ForceQuery = false
+
+	return &n
+}
+
+// inScope reports whether a URL is on the seed's host and under the path prefix
+func (c *Crawler) inScope(seed, u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") &&
+		u.Host == seed.Host && strings.HasPrefix(u.Path, c.Prefix)
+}
+
+// Crawl fetches every page reachable from seed and writes the site map to out
+func (c *Crawler) Crawl(ctx context.Context, seed string, out io.Writer) error {
+	start, err := url.Parse(seed)
+	if err != nil {
+		return err
+	}
+	if start.Scheme != "http" && start.Scheme != "https" {
+		return fmt.Errorf("seed %q must be an http or https URL", seed)
+	}
+	start = Normalise(start)
+
+	// the defaults are filled in on a copy, so they don't stick to a Crawler used for another seed
+	cfg := *c
+	c = &cfg
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if c.Workers < 1 {
+		c.Workers = 1
+	}
+	if c.Prefix == "" {
+		c.Prefix = start.Path[:strings.LastIndex(start.Path, "/")+1]
+	}
+
+	// cancelling on the way out releases any worker still waiting to hand back a result
+	ctx, cancel := context.WithCancel(ctx)
+
+	hosts := &hostPolicies{crawler: c, policies: make(map[string]*hostPolicy)}
+
+	// the client follows redirects itself, so where they lead is checked as links are: a redirect
+	// mustn't take the crawl off the site, or to a page robots.txt disallows
+	pages := *c.Client
+	pages.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		target := Normalise(req.URL)
+		if !c.inScope(start, target) {
+			return fmt.Errorf("redirect to %s is out of scope", target)
+		}
+		if !hosts.get(req.Context(), target).robots.allowed(target.RequestURI()) {
+			return fmt.Errorf("redirect to %s is disallowed by robots.txt", target)
+		}
+		if c.Client.CheckRedirect != nil {
+			return c.Client.CheckRedirect(req, via)
+		}
+		// the limit http.Client has by default
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	c.pages = &pages
+
+	// as in the worker-pools example, workers receive jobs on one channel and send results on
+	// another; here results can produce more jobs, so the main loop below both feeds and drains
+	// the pool and keeps its own queue, meaning a worker never blocks on a full jobs channel
+	jobs := make(chan job)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for w := 0; w < c.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				select {
+				case results <- c.fetch(ctx, hosts, j):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	defer func() {
+		cancel()
+		close(jobs)
+		wg.Wait()
+	}()
+
+	enc := json.NewEncoder(out)
+	seen := map[string]bool{start.String(): true}
+	queue := []job{{url: start}}
+	inFlight, fetched := 0, 0
+
+	for len(queue) > 0 || inFlight > 0 {
+		// only offer a job to the workers if we have one and haven't hit the page limit
+		var send chan job
+		var next job
+		if len(queue) > 0 && (c.MaxPages == 0 || fetched+inFlight < c.MaxPages) {
+			send = jobs
+			next = queue[0]
+		} else if inFlight == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case send <- next:
+			queue = queue[1:]
+			inFlight++
+		case r := <-results:
+			inFlight--
+			fetched++
+			if err := enc.Encode(r.page); err != nil {
+				return err
+			}
+
+			// a page we were redirected to has been fetched too, so there's no need to queue it
+			if r.final != nil {
+				seen[Normalise(r.final).String()] = true
+			}
+
+			if c.MaxDepth > 0 && r.depth >= c.MaxDepth {
+				continue
+			}
+			for _, link := range r.links {
+				link = Normalise(link)
+				key := link.String()
+				if seen[key] || !c.inScope(start, link) {
+					continue
+				}
+				seen[key] = true
+				queue = append(queue, job{url: link, depth: r.depth + 1, referrer: r.page.URL})
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetch downloads one page and extracts its links
+func (c *Crawler) fetch(ctx context.Context, hosts *hostPolicies, j job) result {
+	r := result{page: Page{URL: j.url.String(), Depth: j.depth, Referrer: j.referrer}, depth: j.depth}
+
+	policy := hosts.get(ctx, j.url)
+	if !policy.robots.allowed(j.url.RequestURI()) {
+		r.page.Error = "disallowed by robots.txt"
+		return r
+	}
+	if err := policy.wait(ctx); err != nil {
+		r.page.Error = err.Error()
+		return r
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url.String(), nil)
+	if err != nil {
+		r.page.Error = err.Error()
+		return r
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Accept", "text/html,*/*;q=0.5")
+
+	start := time.Now()
+	resp, err := c.pages.Do(req)
+	if err != nil {
+		r.page.DurationMS = time.Since(start).Milliseconds()
+		r.page.Error = err.Error()
+		return r
+	}
+	defer resp.Body.Close()
+
+	r.page.Status = resp.StatusCode
+	r.page.ContentType = resp.Header.Get("Content-Type")
+
+	// the client follows redirects itself, so links must be resolved against where we ended up
+	base := resp.Request.URL
+	if base.String() != j.url.String() {
+		r.final = base
+		r.page.RedirectTo = base.String()
+	}
+
+	mt, _, _ := mime.ParseMediaType(r.page.ContentType)
+	if resp.StatusCode == http.StatusOK && mt == "text/html" {
+		links, err := extractLinks(base, io.LimitReader(resp.Body, 10<<20))
+		if err != nil {
+			r.page.Error = err.Error()
+		}
+		r.links = append(r.links, links...)
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	// the response time includes reading the body, since that's what a reader of the page waits for
+	r.page.DurationMS = time.Since(start).Milliseconds()
+	r.page.Links = len(r.links)
+
+	return r
+}
+
+// extractLinks tokenizes an HTML document and returns the absolute URLs of its links
+// a tokenizer is much lighter than building the whole document tree, and is all we need to find
+// href attributes; links marked rel="nofollow" are skipped, and a <base> element changes what
+// relative links are resolved against
+func extractLinks(base *url.URL, r io.Reader) ([]*url.URL, error) {
+	var links []*url.URL
+	z := html.NewTokenizer(r)
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return links, nil
+			}
+			return links, z.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			if t.Data != "a" && t.Data != "area" && t.Data != "base" {
+				continue
+			}
+
+			var href, rel string
+			for _, a := range t.Attr {
+				switch a.Key {
+				case "href":
+					href = strings.TrimSpace(a.Val)
+				case "rel":
+					rel = strings.ToLower(a.Val)
+				}
+			}
+			if href == "" {
+				continue
+			}
+
+			u, err := base.Parse(href)
+			if err != nil {
+				continue
+			}
+			if t.Data == "base" {
+				base = u
+				continue
+			}
+			if strings.Contains(rel, "nofollow") {
+				continue
+			}
+			links = append(links, u)
+		}
+	}
+}
+
+// hostPolicies holds the robots.txt rules and request timing for each host
+type hostPolicies struct {
+	crawler  *Crawler
+	mu       sync.Mutex
+	policies map[string]*hostPolicy
+}
+
+type hostPolicy struct {
+	// ready is closed once robots.txt has been fetched
+	ready  chan struct{}
+	robots *robots
+	delay  time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// get returns the policy for a URL's host, fetching its robots.txt the first time it's seen
+// other workers asking for the same host wait for that fetch rather than starting their own
+func (h *hostPolicies) get(ctx context.Context, u *url.URL) *hostPolicy {
+	key := u.Scheme + "://" + u.Host
+
+	h.mu.Lock()
+	p, ok := h.policies[key]
+	if !ok {
+		p = &hostPolicy{ready: make(chan struct{}), delay: h.crawler.Delay}
+		h.policies[key] = p
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		p.robots = h.crawler.fetchRobots(ctx, key)
+		if p.robots.crawlDelay > p.delay {
+			p.delay = p.robots.crawlDelay
+		}
+		close(p.ready)
+	}
+	<-p.ready
+
+	return p
+}
+
+// wait blocks until it's polite to send another request to the host
+// each caller reserves the next slot before sleeping, so concurrent workers are spaced out
+func (p *hostPolicy) wait(ctx context.Context) error {
+	p.mu.Lock()
+	now := time.Now()
+	at := p.next
+	if at.Before(now) {
+		at = now
+	}
+	p.next = at.Add(p.delay)
+	p.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// disallowAll is used when we can't tell what the site allows
+func disallowAll() *robots {
+	return &robots{rules: []robotsRule{{false, "/", robotsPattern("/")}}}
+}
+
+// robots holds the rules from a robots.txt file that apply to our user agent
+type robots struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	path    string
+	pattern *regexp.Regexp
+}
+
+// allowed applies the most specific (longest) matching rule, with Allow winning ties, as
+// described in RFC 9309; with no matching rule everything is allowed
+func (r *robots) allowed(p string) bool {
+	best := -1
+	allow := true
+	for _, rule := range r.rules {
+		if rule.pattern.MatchString(p) && (len(rule.path) > best || (len(rule.path) == best && rule.allow)) {
+			best = len(rule.path)
+			allow = rule.allow
+		}
+	}
+	return allow
+}
+
+// robotsPattern compiles a rule path, which may contain * wildcards and a trailing $ anchor
+func robotsPattern(rule string) *regexp.Regexp {
+	anchored := strings.HasSuffix(rule, "$")
+	rule = strings.TrimSuffix(rule, "$")
+
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(rule), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// fetchRobots downloads and parses robots.txt for a host
+// per RFC 9309, a missing file (4xx) allows everything, while a server error means we should assume
+// everything is disallowed
+func (c *Crawler) fetchRobots(ctx context.Context, origin string) *robots {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return &robots{}
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return disallowAll()
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return disallowAll()
+	case resp.StatusCode != http.StatusOK:
+		return &robots{}
+	}
+
+	return parseRobots(io.LimitReader(resp.Body, 500<<10), c.UserAgent)
+}
+
+// productToken is the name at the start of a user agent, such as gobyexample-crawler in
+// gobyexample-crawler/1.0, lowercased, as robots.txt groups are matched without regard to case
+func productToken(userAgent string) string {
+	token := strings.ToLower(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	return token
+}
+
+// parseRobots reads the groups in a robots.txt file and keeps the rules for our user agent, falling
+// back to the rules for * if no group names us
+// a group names us if its user agent is our whole product token, per RFC 9309, so a group for a
+// doesn't apply to every crawler whose name starts with a
+func parseRobots(r io.Reader, userAgent string) *robots {
+	agent := productToken(userAgent)
+
+	var mine, star robots
+	var haveMine bool
+	var current []*robots
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// consecutive user-agent lines share the group that follows them
+			if !inAgents {
+				current = nil
+			}
+			inAgents = true
+			switch ua := strings.ToLower(value); {
+			case ua == "*":
+				current = append(current, &star)
+			case agent != "" && productToken(ua) == agent:
+				current = append(current, &mine)
+				haveMine = true
+			}
+		case "allow", "disallow":
+			inAgents = false
+			// an empty Disallow means nothing is disallowed
+			if value == "" {
+				continue
+			}
+			for _, g := range current {
+				g.rules = append(g.rules, robotsRule{key == "allow", value, robotsPattern(value)})
+			}
+		case "crawl-delay":
+			inAgents = false
+			var secs float64
+			if _, err := fmt.Sscanf(value, "%g", &secs); err == nil && secs > 0 {
+				for _, g := range current {
+					g.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	if haveMine {
+		return &mine
+	}
+	return &star
+}
+
+func main() {
+	workers := flag.Int("workers", 4, "number of concurrent fetches")
+	delay := flag.Duration("delay", 500*time.Millisecond, "minimum delay between requests to the same host")
+	maxPages := flag.Int("max-pages", 100, "stop after this many pages (0 for no limit)")
+	maxDepth := flag.Int("max-depth", 0, "don't follow links deeper than this (0 for no limit)")
+	prefix := flag.String("prefix", "", "only crawl paths under this prefix (default: the seed's directory)")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: web-crawler [flags] seed-url")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c := &Crawler{
+		UserAgent: "gobyexample-crawler/1.0",
+		Workers:   *workers,
+		Delay:     *delay,
+		MaxPages:  *maxPages,
+		MaxDepth:  *maxDepth,
+		Prefix:    *prefix,
+	}
+
+	// buffer the output, but make sure it's flushed even if the crawl fails part way
+	out := bufio.NewWriter(os.Stdout)
+	err := c.Crawl(context.Background(), flag.Arg(0), out)
+	out.Flush()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	// Crawl the first few pages of Go by Example
+	// >> go run . -max-pages 10 https://gobyexample.com/
+
+	// The site map is one JSON object per line, so it's easy to process further, e.g. with jq
+	// >> go run . https://gobyexample.com/ | jq -r 'select(.status != 200) | .url'
+}
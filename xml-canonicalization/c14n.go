@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// This file implements Exclusive XML Canonicalization 1.0 (https://www.w3.org/TR/xml-exc-c14n/)
+// The canonical form of a document is:
+//   encoded as UTF-8, with line endings normalised to \n
+//   without the XML declaration or DTD, and without comments unless they're asked for
+//   with empty elements written as start and end tag pairs, <a></a>
+//   with attribute values in double quotes, and attributes sorted by namespace URI and local name
+//   with a fixed set of character escapes in text and attribute values
+//   with each namespace declared on the first element that uses it in its name or attributes, and
+//   nowhere else, which is what makes it exclusive
+// Whitespace between elements is kept, as the specification requires; TrimSpace drops it, which
+// isn't part of the standard but is what you want when comparing indented documents
+
+// xmlNamespace is bound to the xml prefix in every document without being declared
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// Options control canonicalization
+type Options struct {
+	// Comments keeps comments, for the "with comments" variant of the algorithm
+	Comments bool
+
+	// InclusivePrefixes lists namespace prefixes that are rendered wherever they're in scope, as
+	// inclusive canonicalization does, for documents that use prefixes in content such as
+	// xsi:type="g:Plant"; "#default" stands for the default namespace
+	InclusivePrefixes []string
+
+	// TrimSpace drops text that's only whitespace, such as indentation
+	TrimSpace bool
+}
+
+// scope maps namespace prefixes to URIs, with "" for the default namespace
+type scope map[string]string
+
+// lookup finds a prefix in a stack of scopes, innermost first
+func lookup(stack []scope, prefix string) (string, bool) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if uri, ok := stack[i][prefix]; ok {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// canonicalizer holds the state of one run
+type canonicalizer struct {
+	opts Options
+	w    *bufio.Writer
+
+	// declared holds the declarations made in the input, and rendered the ones written to the
+	// output, for each open element
+	declared []scope
+	rendered []scope
+
+	// RawToken doesn't check that end tags match start tags, so open keeps track of them
+	open []xml.Name
+
+	depth    int
+	seenRoot bool
+	text     strings.Builder
+}
+
+// Canonicalize writes the canonical form of the XML document read from r to w
+func Canonicalize(w io.Writer, r io.Reader, opts Options) error {
+	c := &canonicalizer{opts: opts, w: bufio.NewWriter(w)}
+
+	// RawToken keeps namespace prefixes as written, which the canonical form needs; namespaces
+	// are resolved here instead
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := tok.(xml.CharData); !ok {
+			c.flushText()
+		}
+		if err := c.token(tok); err != nil {
+			return err
+		}
+	}
+
+	if c.depth != 0 || !c.seenRoot {
+		return errors.New("c14n: document ended early")
+	}
+	return c.w.Flush()
+}
+
+func (c *canonicalizer) token(tok xml.Token) error {
+	switch t := tok.(type) {
+	case xml.StartElement:
+		if c.depth == 0 && c.seenRoot {
+			return errors.New("c14n: more than one root element")
+		}
+		c.seenRoot = true
+		c.depth++
+		c.open = append(c.open, t.Name)
+		return c.start(t)
+
+	case xml.EndElement:
+		if len(c.open) == 0 || c.open[len(c.open)-1] != t.Name {
+			return fmt.Errorf("c14n: unexpected end tag </%s>", qname(t.Name))
+		}
+		c.open = c.open[:len(c.open)-1]
+		c.depth--
+		c.declared = c.declared[:len(c.declared)-1]
+		c.rendered = c.rendered[:len(c.rendered)-1]
+		fmt.Fprintf(c.w, "</%s>", qname(t.Name))
+
+	case xml.CharData:
+		// text outside the root element is only whitespace, and isn't part of the canonical form
+		if c.depth > 0 {
+			c.text.Write(t)
+		}
+
+	case xml.Comment:
+		if c.opts.Comments {
+			c.misc("<!--" + string(t) + "-->")
+		}
+
+	case xml.ProcInst:
+		// the XML declaration is written as a processing instruction, but isn't one
+		if t.Target == "xml" {
+			return nil
+		}
+		pi := "<?" + t.Target
+		if len(t.Inst) > 0 {
+			pi += " " + strings.TrimLeft(string(t.Inst), " \t\r\n")
+		}
+		c.misc(pi + "?>")
+	}
+
+	// directives (the DTD) aren't part of the canonical form
+	return nil
+}
+
+// misc writes a comment or processing instruction; outside the root element, each one is
+// separated from the root by a newline
+func (c *canonicalizer) misc(s string) {
+	switch {
+	case c.depth > 0:
+		c.w.WriteString(s)
+	case c.seenRoot:
+		c.w.WriteString("\n" + s)
+	default:
+		c.w.WriteString(s + "\n")
+	}
+}
+
+func (c *canonicalizer) flushText() {
+	s := c.text.String()
+	c.text.Reset()
+	if s == "" || (c.opts.TrimSpace && strings.TrimSpace(s) == "") {
+		return
+	}
+	c.w.WriteString(escapeText(s))
+}
+
+func (c *canonicalizer) start(t xml.StartElement) error {
+	declared := scope{}
+	var attrs []xml.Attr
+	for _, a := range t.Attr {
+		switch {
+		case a.Name.Space == "xmlns":
+			declared[a.Name.Local] = a.Value
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			declared[""] = a.Value
+		default:
+			attrs = append(attrs, a)
+		}
+	}
+	c.declared = append(c.declared, declared)
+
+	// the prefixes this element visibly uses: its own, and those of its prefixed attributes
+	// (unprefixed attributes are in no namespace, not the default one)
+	used := map[string]bool{t.Name.Space: true}
+	for _, a := range attrs {
+		if a.Name.Space != "" {
+			used[a.Name.Space] = true
+		}
+	}
+	for _, p := range c.opts.InclusivePrefixes {
+		if p == "#default" {
+			p = ""
+		}
+		if _, ok := lookup(c.declared, p); ok {
+			used[p] = true
+		}
+	}
+
+	rendered := scope{}
+	for p := range used {
+		if p == "xml" {
+			continue
+		}
+		uri, ok := lookup(c.declared, p)
+		if !ok && p != "" {
+			return fmt.Errorf("c14n: undeclared namespace prefix %q on <%s>", p, qname(t.Name))
+		}
+		// an undeclared default namespace is the same as xmlns="", which only needs writing to
+		// undo a default namespace an output ancestor declared
+		if prev, _ := lookup(c.rendered, p); prev != uri {
+			rendered[p] = uri
+		}
+	}
+	c.rendered = append(c.rendered, rendered)
+
+	// namespace declarations come first, sorted by prefix with the default namespace first
+	prefixes := make([]string, 0, len(rendered))
+	for p := range rendered {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	// then the other attributes, sorted by namespace URI and then local name, so attributes in
+	// no namespace come first
+	uri := func(a xml.Attr) string {
+		switch a.Name.Space {
+		case "":
+			return ""
+		case "xml":
+			return xmlNamespace
+		}
+		u, _ := lookup(c.declared, a.Name.Space)
+		return u
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		ui, uj := uri(attrs[i]), uri(attrs[j])
+		if ui != uj {
+			return ui < uj
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+
+	c.w.WriteString("<" + qname(t.Name))
+	for _, p := range prefixes {
+		if p == "" {
+			fmt.Fprintf(c.w, ` xmlns="%s"`, escapeAttr(rendered[p]))
+		} else {
+			fmt.Fprintf(c.w, ` xmlns:%s="%s"`, p, escapeAttr(rendered[p]))
+		}
+	}
+	for _, a := range attrs {
+		fmt.Fprintf(c.w, ` %s="%s"`, qname(a.Name), escapeAttr(a.Value))
+	}
+	c.w.WriteString(">")
+	return nil
+}
+
+// qname formats a raw name, whose Space is its prefix
+func qname(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;",
+		"\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// This file compares two documents structurally: as trees of elements, attributes and text,
+// rather than as bytes
+// Children are matched by name and position among their same-named siblings, so the first <plant>
+// in one document is compared with the first <plant> in the other; moving an element past a
+// sibling with a different name doesn't count as a change
+
+// element is a parsed element, with names qualified by namespace URI rather than prefix, so the
+// choice of prefixes doesn't matter either
+type element struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*element
+}
+
+// parseTree reads a document into a tree of elements
+// text is the element's own text, with surrounding whitespace trimmed unless exact is set
+func parseTree(r io.Reader, exact bool) (*element, error) {
+	dec := xml.NewDecoder(r)
+	var stack []*element
+	var texts []*strings.Builder
+	var root *element
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			el := &element{name: clark(t.Name), attrs: make(map[string]string)}
+			for _, a := range t.Attr {
+				// namespace declarations are how names are written, not part of the data
+				if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+					continue
+				}
+				el.attrs[clark(a.Name)] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, el)
+			} else {
+				root = el
+			}
+			stack = append(stack, el)
+			texts = append(texts, &strings.Builder{})
+
+		case xml.CharData:
+			if len(texts) > 0 {
+				texts[len(texts)-1].Write(t)
+			}
+
+		case xml.EndElement:
+			el := stack[len(stack)-1]
+			el.text = texts[len(texts)-1].String()
+			if !exact {
+				el.text = strings.TrimSpace(el.text)
+			}
+			stack, texts = stack[:len(stack)-1], texts[:len(texts)-1]
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+// clark writes a resolved name in Clark notation, {uri}local, or just local if it has no
+// namespace
+func clark(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return "{" + n.Space + "}" + n.Local
+}
+
+// Change is one difference between two documents
+// Kind is "+" for something only in the second document, "-" for something only in the first,
+// and "~" for something in both with different values
+// Path is an XPath-like location: /catalogue/plant[2]/@id, or /catalogue/plant[2]/name/text()
+type Change struct {
+	Kind     string
+	Path     string
+	Old, New string
+}
+
+func (c Change) String() string {
+	if c.Kind == "~" {
+		return fmt.Sprintf("~ %s: %q -> %q", c.Path, c.Old, c.New)
+	}
+	return c.Kind + " " + c.Path
+}
+
+// Diff compares two documents and returns their differences, in document order
+func Diff(a, b io.Reader, exact bool) ([]Change, error) {
+	ta, err := parseTree(a, exact)
+	if err != nil {
+		return nil, fmt.Errorf("first document: %v", err)
+	}
+	tb, err := parseTree(b, exact)
+	if err != nil {
+		return nil, fmt.Errorf("second document: %v", err)
+	}
+
+	var changes []Change
+	if ta.name != tb.name {
+		// different roots have nothing in common to compare
+		return []Change{{Kind: "-", Path: "/" + ta.name}, {Kind: "+", Path: "/" + tb.name}}, nil
+	}
+	diffElements(ta, tb, "/"+ta.name, &changes)
+	return changes, nil
+}
+
+func diffElements(a, b *element, path string, changes *[]Change) {
+	// attributes, in name order
+	names := make([]string, 0, len(a.attrs)+len(b.attrs))
+	for n := range a.attrs {
+		names = append(names, n)
+	}
+	for n := range b.attrs {
+		if _, ok := a.attrs[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		p := path + "/@" + n
+		av, inA := a.attrs[n]
+		bv, inB := b.attrs[n]
+		switch {
+		case !inB:
+			*changes = append(*changes, Change{Kind: "-", Path: p, Old: av})
+		case !inA:
+			*changes = append(*changes, Change{Kind: "+", Path: p, New: bv})
+		case av != bv:
+			*changes = append(*changes, Change{"~", p, av, bv})
+		}
+	}
+
+	if a.text != b.text {
+		*changes = append(*changes, Change{"~", path + "/text()", a.text, b.text})
+	}
+
+	// children, grouped by name in the order the names first appear
+	var order []string
+	groups := make(map[string][2][]*element)
+	for i, children := range [][]*element{a.children, b.children} {
+		for _, c := range children {
+			g, ok := groups[c.name]
+			if !ok {
+				order = append(order, c.name)
+			}
+			g[i] = append(g[i], c)
+			groups[c.name] = g
+		}
+	}
+
+	for _, name := range order {
+		g := groups[name]
+		n := len(g[0])
+		if len(g[1]) > n {
+			n = len(g[1])
+		}
+		for i := 0; i < n; i++ {
+			// positions are only written where there's more than one element of a name
+			p := path + "/" + name
+			if n > 1 {
+				p += fmt.Sprintf("[%d]", i+1)
+			}
+			switch {
+			case i >= len(g[1]):
+				*changes = append(*changes, Change{Kind: "-", Path: p})
+			case i >= len(g[0]):
+				*changes = append(*changes, Change{Kind: "+", Path: p})
+			default:
+				diffElements(g[0][i], g[1][i], p, changes)
+			}
+		}
+	}
+}
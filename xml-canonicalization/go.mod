@@ -0,0 +1,3 @@
+module example/xml-canonicalization
+
+go 1.18
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The xml example prints a Plant with xml.MarshalIndent
+// Comparing output like that byte for byte, in a golden test for instance, breaks as soon as the
+// indentation changes, attributes are written in a different order or a namespace gets another
+// prefix, even though the document means the same thing
+
+// There are two ways round that, and we'll implement both:
+//   canonicalize both documents, so equivalent documents become identical bytes (c14n.go)
+//   compare the documents as trees, and report what changed where (diff.go)
+
+// Plant is the type from the xml example
+type Plant struct {
+	XMLName xml.Name `xml:"plant"`
+	Id      int      `xml:"id,attr"`
+	Name    string   `xml:"name"`
+	Origin  []string `xml:"origin"`
+}
+
+func main() {
+	comments := flag.Bool("comments", false, "keep comments in the canonical form")
+	trim := flag.Bool("trim", false, "drop whitespace-only text from the canonical form")
+	exact := flag.Bool("exact", false, "compare text exactly, rather than ignoring surrounding whitespace")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: xml-canonicalization [flags] file        print the canonical form")
+		fmt.Fprintln(os.Stderr, "       xml-canonicalization [flags] old new    compare; exit status 1 if they differ")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch flag.NArg() {
+	case 0:
+		demo()
+	case 1:
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		if err := Canonicalize(os.Stdout, f, Options{Comments: *comments, TrimSpace: *trim}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	case 2:
+		os.Exit(compareFiles(flag.Arg(0), flag.Arg(1), *exact))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Print a document's canonical form
+	// >> go run . plant.xml
+
+	// Compare two documents, as diff does: exit status 0 if they're the same, 1 if they differ,
+	// 2 if something went wrong
+	// >> go run . want.xml got.xml
+}
+
+// compareFiles prints the differences between two files and returns the exit status
+func compareFiles(oldName, newName string, exact bool) int {
+	a, err := os.Open(oldName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer a.Close()
+	b, err := os.Open(newName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer b.Close()
+
+	changes, err := Diff(a, b, exact)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func canonical(doc string, opts Options) string {
+	var b bytes.Buffer
+	if err := Canonicalize(&b, strings.NewReader(doc), opts); err != nil {
+		panic(err)
+	}
+	return b.String()
+}
+
+func demo() {
+	coffee := &Plant{Id: 27, Name: "Coffee", Origin: []string{"Ethiopia", "Brazil"}}
+	indented, _ := xml.MarshalIndent(coffee, " ", "\t")
+
+	// the same plant written by hand, with different formatting and attribute quoting, and a
+	// namespace declared that isn't used
+	handWritten := `<?xml version="1.0"?>
+<plant xmlns:unused="urn:x" id='27'><name>Coffee</name>
+  <origin>Ethiopia</origin><origin>Brazil</origin>
+</plant>`
+
+	fmt.Println("bytes equal:", string(indented) == handWritten)
+
+	opts := Options{TrimSpace: true}
+	c1, c2 := canonical(string(indented), opts), canonical(handWritten, opts)
+	fmt.Println("canonical:", c1)
+	fmt.Println("canonical forms equal:", c1 == c2)
+
+	// exclusive canonicalization moves namespace declarations to where they're used, and sorts
+	// attributes by namespace URI, so the prefixes chosen don't reorder them
+	fmt.Println(canonical(`<a:plant xmlns:a="urn:a" xmlns:b="urn:b" xmlns:z="urn:0" b:x="1" z:y="2" id="27"><b:name>Coffee</b:name></a:plant>`, opts))
+
+	// a structural diff says what changed, and where
+	tea := &Plant{Id: 28, Name: "Tea", Origin: []string{"Ethiopia", "China", "India"}}
+	changed, _ := xml.Marshal(tea)
+	changes, err := Diff(bytes.NewReader(indented), bytes.NewReader(changed), false)
+	if err != nil {
+		panic(err)
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+}
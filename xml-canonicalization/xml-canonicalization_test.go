@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	var tests = []struct {
+		name, in, want string
+		opts           Options
+	}{
+		{"empty elements", `<a><b/></a>`, `<a><b></b></a>`, Options{}},
+		{"declaration and dtd", "<?xml version=\"1.0\"?>\n<!DOCTYPE a>\n<a/>", `<a></a>`, Options{}},
+		{"attribute order", `<a z="1" b='2' xmlns:p="urn:p" p:a="3"/>`, `<a xmlns:p="urn:p" b="2" z="1" p:a="3"></a>`, Options{}},
+		{"escapes", "<a x='&lt;\"&#9;'>&gt;&amp;\"</a>", `<a x="&lt;&quot;&#x9;">&gt;&amp;"</a>`, Options{}},
+		{"unused namespace", `<a xmlns:p="urn:p"><b/></a>`, `<a><b></b></a>`, Options{}},
+		{"namespace pushed down", `<a xmlns:p="urn:p"><p:b><p:c/></p:b></a>`, `<a><p:b xmlns:p="urn:p"><p:c></p:c></p:b></a>`, Options{}},
+		{"default namespace undone", `<a xmlns="urn:a"><b xmlns=""/></a>`, `<a xmlns="urn:a"><b xmlns=""></b></a>`, Options{}},
+		{"empty default not written", `<a xmlns=""><b/></a>`, `<a><b></b></a>`, Options{}},
+		{"inclusive prefix", `<a xmlns:p="urn:p" t="p:x"/>`, `<a xmlns:p="urn:p" t="p:x"></a>`, Options{InclusivePrefixes: []string{"p"}}},
+		{"whitespace kept", "<a>\n  <b/>\n</a>", "<a>\n  <b></b>\n</a>", Options{}},
+		{"whitespace trimmed", "<a>\n  <b> x </b>\n</a>", "<a><b> x </b></a>", Options{TrimSpace: true}},
+		{"comments dropped", `<!--c--><a><!--d--></a>`, `<a></a>`, Options{}},
+		{"comments kept", `<!--c--><a><!--d--></a><?pi x?>`, "<!--c-->\n<a><!--d--></a>\n<?pi x?>", Options{Comments: true}},
+		{"cdata", `<a><![CDATA[<x>]]></a>`, `<a>&lt;x&gt;</a>`, Options{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := Canonicalize(&b, strings.NewReader(tt.in), tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{`<a></b>`, `<a><b></a>`, `<p:a/>`, `<a/><b/>`, `<a>`} {
+		if err := Canonicalize(&strings.Builder{}, strings.NewReader(bad), Options{}); err == nil {
+			t.Errorf("Canonicalize(%s) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	var tests = []struct {
+		a, b string
+		want []string
+	}{
+		{`<a x="1"><b>t</b></a>`, "<a x='1'>\n  <b> t </b>\n</a>", nil},
+		{`<p:a xmlns:p="urn:a"/>`, `<q:a xmlns:q="urn:a"/>`, nil},
+		{`<a x="1" y="2"/>`, `<a y="3" z="4"/>`, []string{`- /a/@x`, `~ /a/@y: "2" -> "3"`, `+ /a/@z`}},
+		{`<a><b/><c/><b>1</b></a>`, `<a><b/><b>2</b></a>`, []string{`~ /a/b[2]/text(): "1" -> "2"`, `- /a/c`}},
+		{`<a><b/></a>`, `<a><b/><b/></a>`, []string{`+ /a/b[2]`}},
+		{`<a/>`, `<b/>`, []string{`- /a`, `+ /b`}},
+	}
+
+	for _, tt := range tests {
+		changes, err := Diff(strings.NewReader(tt.a), strings.NewReader(tt.b), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range changes {
+			got = append(got, c.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Diff(%s, %s): got %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a := write("a.xml", `<plant id="27"><name>Coffee</name></plant>`)
+	b := write("b.xml", "<plant id=\"27\">\n\t<name>Coffee</name>\n</plant>")
+	c := write("c.xml", `<plant id="28"><name>Coffee</name></plant>`)
+
+	var tests = []struct {
+		a, b string
+		want int
+	}{
+		{a, b, 0},
+		{a, c, 1},
+		{a, filepath.Join(dir, "missing.xml"), 2},
+	}
+	for _, tt := range tests {
+		if got := compareFiles(tt.a, tt.b, false); got != tt.want {
+			t.Errorf("compareFiles(%s, %s): got %d, want %d", filepath.Base(tt.a), filepath.Base(tt.b), got, tt.want)
+		}
+	}
+}
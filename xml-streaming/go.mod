@@ -0,0 +1,3 @@
+module example/xml-streaming
+
+go 1.18
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// This file matches a compiled Path against a stream of tokens
+// The stream keeps one frame per open element, holding the steps that element's children could
+// match next, so memory grows with the document's depth rather than its size
+
+// frame is the matching state inside one open element
+type frame struct {
+	// active holds the indexes of the steps a child element can match
+	active []int
+
+	// counts holds, per step and predicate, how many children have reached that predicate, for
+	// position predicates
+	counts map[[2]int]int
+}
+
+// Stream finds the elements a Path selects in an XML document
+type Stream struct {
+	dec   *xml.Decoder
+	path  *Path
+	stack []*frame
+
+	cur      *xml.StartElement
+	consumed bool
+}
+
+// NewStream returns a stream over r
+func NewStream(r io.Reader, path *Path) *Stream {
+	return &Stream{
+		dec:   xml.NewDecoder(r),
+		path:  path,
+		stack: []*frame{{active: []int{0}}},
+	}
+}
+
+// errNoMatch is returned by Decode, Text and Encode when Next hasn't found an element
+var errNoMatch = errors.New("xml-streaming: no current match")
+
+// Next advances to the next selected element and returns its start tag, or io.EOF at the end of
+// the document
+// the element's content can then be read with Decode, Text or Encode; otherwise it's skipped
+// elements nested inside a selected element aren't matched themselves
+func (s *Stream) Next() (xml.StartElement, error) {
+	if s.cur != nil && !s.consumed {
+		if err := s.dec.Skip(); err != nil {
+			return xml.StartElement{}, err
+		}
+	}
+	s.cur = nil
+
+	for {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			matched, child := s.match(t, s.stack[len(s.stack)-1])
+			if matched {
+				start := t.Copy()
+				s.cur, s.consumed = &start, false
+				return start, nil
+			}
+			if len(child.active) == 0 {
+				// nothing below this element can match, so there's no need to track it
+				if err := s.dec.Skip(); err != nil {
+					return xml.StartElement{}, err
+				}
+				continue
+			}
+			s.stack = append(s.stack, child)
+		case xml.EndElement:
+			s.stack = s.stack[:len(s.stack)-1]
+		}
+	}
+}
+
+// match checks an element against the steps active in its parent, and returns whether it's
+// selected along with the frame for its children
+func (s *Stream) match(el xml.StartElement, parent *frame) (bool, *frame) {
+	child := &frame{}
+	add := func(i int) {
+		for _, a := range child.active {
+			if a == i {
+				return
+			}
+		}
+		child.active = append(child.active, i)
+	}
+
+	matched := false
+	for _, i := range parent.active {
+		st := s.path.steps[i]
+
+		// a descendant step can still match at any depth below
+		if st.axis == axisDescendant {
+			add(i)
+		}
+		if !s.test(el, i, parent) {
+			continue
+		}
+		if i == len(s.path.steps)-1 {
+			matched = true
+		} else {
+			add(i + 1)
+		}
+	}
+	return matched, child
+}
+
+// test applies step i's name test and predicates to an element
+// predicates are applied in order, and a position counts only the siblings that passed the
+// predicates before it, as in XPath
+func (s *Stream) test(el xml.StartElement, i int, parent *frame) bool {
+	st := s.path.steps[i]
+	if st.name != "*" && st.name != el.Name.Local {
+		return false
+	}
+
+	for j, p := range st.preds {
+		if p.pos > 0 {
+			if parent.counts == nil {
+				parent.counts = make(map[[2]int]int)
+			}
+			key := [2]int{i, j}
+			parent.counts[key]++
+			if parent.counts[key] != p.pos {
+				return false
+			}
+			continue
+		}
+
+		value, ok := attr(el, p.attr)
+		switch {
+		case !ok && p.op != "!=":
+			return false
+		case p.op == "=" && value != p.value:
+			return false
+		case p.op == "!=" && ok && value == p.value:
+			return false
+		}
+	}
+	return true
+}
+
+func attr(el xml.StartElement, name string) (string, bool) {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Decode decodes the current element into v, as xml.Unmarshal would
+func (s *Stream) Decode(v interface{}) error {
+	if s.cur == nil || s.consumed {
+		return errNoMatch
+	}
+	s.consumed = true
+	return s.dec.DecodeElement(v, s.cur)
+}
+
+// Text returns the text directly inside the current element, which is what a path ending in
+// text() selects; text inside child elements isn't included
+func (s *Stream) Text() (string, error) {
+	if s.cur == nil || s.consumed {
+		return "", errNoMatch
+	}
+	s.consumed = true
+
+	var b strings.Builder
+	for depth := 0; ; {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return b.String(), nil
+			}
+			depth--
+		case xml.CharData:
+			if depth == 0 {
+				b.Write(t)
+			}
+		}
+	}
+}
+
+// Encode copies the current element, token by token, to an encoder
+func (s *Stream) Encode(enc *xml.Encoder) error {
+	if s.cur == nil || s.consumed {
+		return errNoMatch
+	}
+	s.consumed = true
+
+	if err := enc.EncodeToken(*s.cur); err != nil {
+		return err
+	}
+	for depth := 0; ; {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.ProcInst, xml.Directive:
+			// neither is allowed inside an element by the encoder
+			continue
+		}
+		if err := enc.EncodeToken(tok); err != nil {
+			return err
+		}
+		if depth < 0 {
+			return enc.Flush()
+		}
+	}
+}
+
+// Each decodes every element the expression selects into a T and passes it to fn, stopping at
+// the first error
+// for an expression ending in text(), T must be string
+func Each[T any](r io.Reader, expr string, fn func(T) error) error {
+	path, err := Compile(expr)
+	if err != nil {
+		return err
+	}
+
+	s := NewStream(r, path)
+	for {
+		if _, err := s.Next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var v T
+		if path.text {
+			sp, ok := interface{}(&v).(*string)
+			if !ok {
+				return fmt.Errorf("%s selects text, which can't be decoded into %T", path, v)
+			}
+			if *sp, err = s.Text(); err != nil {
+				return err
+			}
+		} else if err := s.Decode(&v); err != nil {
+			return err
+		}
+
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// The xml example marshals one Plant with xml.MarshalIndent and reads it back with xml.Unmarshal,
+// which needs the whole document in memory at once
+// That's fine for one plant, but not for a catalogue feed of several gigabytes
+
+// xml.Decoder can also be used one token at a time, like json.Decoder in the json-query example
+// Here we'll use it to evaluate a subset of XPath against a stream of tokens (xpath.go), and
+// decode each selected element into a Go value as soon as it's complete (stream.go)
+// Only the current path through the document and the element being decoded are ever in memory
+
+// Plant is the type from the xml example
+type Plant struct {
+	XMLName xml.Name `xml:"plant"`
+	Id      int      `xml:"id,attr"`
+	Name    string   `xml:"name"`
+	Origin  []string `xml:"origin"`
+}
+
+func (p Plant) String() string {
+	return fmt.Sprintf("Plant id=%v, name=%v, origin=%v", p.Id, p.Name, p.Origin)
+}
+
+// catalogue writes a generated catalogue of n plants in a few sections, so the example can stream
+// through a large document without one on disk
+func catalogue(w io.Writer, n int) error {
+	bw := bufio.NewWriter(w)
+	sections := []string{"herbs", "tropical", "vegetables"}
+	names := []string{"Coffee", "Tomato", "Basil", "Banana", "Mint", "Pepper"}
+	origins := []string{"Ethiopia", "Brazil", "Mexico", "California", "India"}
+
+	fmt.Fprintln(bw, xml.Header+"<catalogue>")
+	for s, section := range sections {
+		fmt.Fprintf(bw, "  <section name=%q>\n", section)
+		for i := s; i < n; i += len(sections) {
+			// every tenth plant has no id, to give the [@id] predicate something to do
+			id := ""
+			if i%10 != 9 {
+				id = fmt.Sprintf(" id=\"%d\"", i)
+			}
+			fmt.Fprintf(bw, "    <plant%s><name>%s</name><origin>%s</origin><origin>%s</origin></plant>\n",
+				id, names[i%len(names)], origins[i%len(origins)], origins[(i+1)%len(origins)])
+		}
+		fmt.Fprintln(bw, "  </section>")
+	}
+	fmt.Fprintln(bw, "</catalogue>")
+	return bw.Flush()
+}
+
+// generated returns a reader over a generated catalogue, written by another goroutine as it's read
+func generated(n int) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(catalogue(pw, n))
+	}()
+	return pr
+}
+
+// heap reports the bytes currently allocated on the heap
+func heap() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func main() {
+	expr := flag.String("xpath", "//plant", "the XPath expression to select, for files given as arguments")
+	n := flag.Int("n", 1_000_000, "how many plants to generate for the demo")
+	flag.Parse()
+
+	// with files as arguments, print what the expression selects in each of them
+	if flag.NArg() > 0 {
+		path, err := Compile(*expr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		enc := xml.NewEncoder(os.Stdout)
+		for _, name := range flag.Args() {
+			if err := printMatches(name, path, enc); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	// decode every tropical plant that has an id into a Plant, as it arrives
+	count, peak := 0, heap()
+	err := Each(generated(*n), "/catalogue/section[@name='tropical']/plant[@id]", func(p Plant) error {
+		if count < 3 {
+			fmt.Println(p)
+		}
+		count++
+		if count%50_000 == 0 {
+			if h := heap(); h > peak {
+				peak = h
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// the generated document is roughly 100 bytes per plant, but the heap stays small however
+	// many plants there are
+	fmt.Printf("%d tropical plants with ids, from a ~%d MB document, peak heap %d KB\n",
+		count, *n*100>>20, peak>>10)
+
+	// text() selects just the text: here the name of the first plant in each section
+	err = Each(generated(*n), "//section/plant[1]/name/text()", func(name string) error {
+		fmt.Println("first plant:", name)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// Next gives lower-level access, including to the start tag of each match
+	s := NewStream(generated(20), MustCompile("//plant[@id!='4'][2]"))
+	for {
+		start, err := s.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		id, _ := attr(start, "id")
+		fmt.Println("second plant in its section, skipping id 4:", id)
+	}
+
+	// Run the demo over a generated catalogue
+	// >> go run .
+
+	// Or query files, printing the selected elements
+	// >> go run . -xpath '//plant[@id="27"]/origin' plants.xml
+}
+
+func printMatches(name string, path *Path, enc *xml.Encoder) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := NewStream(bufio.NewReader(f), path)
+	for {
+		if _, err := s.Next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if path.text {
+			text, err := s.Text()
+			if err != nil {
+				return err
+			}
+			fmt.Println(text)
+			continue
+		}
+		if err := s.Encode(enc); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	var tests = []struct {
+		expr  string
+		steps []step
+		text  bool
+	}{
+		{"/catalog", []step{{axis: axisChild, name: "catalog"}}, false},
+		{"/catalog/plant", []step{{axis: axisChild, name: "catalog"}, {axis: axisChild, name: "plant"}}, false},
+		{"//plant", []step{{axis: axisDescendant, name: "plant"}}, false},
+		{"/catalog//*", []step{{axis: axisChild, name: "catalog"}, {axis: axisDescendant, name: "*"}}, false},
+		{"//g:plant", []step{{axis: axisDescendant, name: "plant"}}, false},
+		{"//plant/name/text()", []step{{axis: axisDescendant, name: "plant"}, {axis: axisChild, name: "name"}}, true},
+		{"//plant[2]", []step{{axis: axisDescendant, name: "plant", preds: []predicate{{pos: 2}}}}, false},
+		{"//plant[@id]", []step{{axis: axisDescendant, name: "plant", preds: []predicate{{attr: "id"}}}}, false},
+		{"//plant[@xml:lang]", []step{{axis: axisDescendant, name: "plant", preds: []predicate{{attr: "lang"}}}}, false},
+		{"//plant[@id='27']", []step{{axis: axisDescendant, name: "plant",
+			preds: []predicate{{attr: "id", op: "=", value: "27"}}}}, false},
+		{`//plant[ @id != "27" ]`, []step{{axis: axisDescendant, name: "plant",
+			preds: []predicate{{attr: "id", op: "!=", value: "27"}}}}, false},
+		{"//plant[@id][1]", []step{{axis: axisDescendant, name: "plant",
+			preds: []predicate{{attr: "id"}, {pos: 1}}}}, false},
+		// brackets and the other kind of quote inside a literal are part of the value
+		{"//plant[@name='a]b']", []step{{axis: axisDescendant, name: "plant",
+			preds: []predicate{{attr: "name", op: "=", value: "a]b"}}}}, false},
+		{`//plant[@name="[x]"]/name`, []step{{axis: axisDescendant, name: "plant",
+			preds: []predicate{{attr: "name", op: "=", value: "[x]"}}}, {axis: axisChild, name: "name"}}, false},
+		{`//plant[@name="it's"]`, []step{{axis: axisDescendant, name: "plant",
+			preds: []predicate{{attr: "name", op: "=", value: "it's"}}}}, false},
+		{"//plant[@name='']", []step{{axis: axisDescendant, name: "plant",
+			preds: []predicate{{attr: "name", op: "=", value: ""}}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.steps, tt.steps) || p.text != tt.text {
+				t.Errorf("got %+v, text %v\nwant %+v, text %v", p.steps, p.text, tt.steps, tt.text)
+			}
+			if p.String() != tt.expr {
+				t.Errorf("String gave %q", p.String())
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	var tests = []struct {
+		expr, err string
+	}{
+		{"", "must start with / or //"},
+		{"plant", "must start with / or //"},
+		{"/", "expected an element name"},
+		{"/catalog/", "expected an element name"},
+		{"///plant", "expected an element name"},
+		{"/text()", "text() must follow an element step with /"},
+		{"//plant//text()", "text() must follow an element step with /"},
+		{"//name/text()/x", "text() must be the last step"},
+		{"//plant[", "offset 7: unterminated predicate"},
+		{"//plant[@id='27'", "unterminated predicate"},
+		{"//plant[@name='a]b'", "unterminated predicate"},
+		{"//plant[@name='a'b']", "unterminated predicate"},
+		{"//plant[0]", "unsupported predicate [0]"},
+		{"//plant[last()]", "unsupported predicate [last()]"},
+		{"//plant[@]", "expected an attribute name after @"},
+		{"//plant[@id>2]", "expected = or != after @id"},
+		{"//plant[@id=27]", "expected a quoted value after ="},
+		{`//plant[@id='27"]`, "unterminated predicate"},
+		{`//plant[@id="a"b"c"]`, "expected a quoted value after ="},
+		{"//plant x", "expected / or //"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Compile(tt.expr)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file parses the XPath subset the stream understands:
+//   /a/b        child steps, starting from the document
+//   //b         descendant steps, matching b at any depth below
+//   *           any element name
+//   [@id]       elements with an id attribute
+//   [@id='27']  elements whose id attribute is 27, or isn't with !=
+//   [2]         the second matching element among its siblings
+//   text()      as the last step, the text of the matched elements
+// Every predicate can be decided from an element's start tag, which is what lets the stream match
+// elements as they arrive instead of building a tree
+// Names are matched on their local part, ignoring any namespace prefix
+
+// axis says how a step relates to the previous one
+type axis int
+
+const (
+	axisChild axis = iota
+	axisDescendant
+)
+
+// predicate is a filter inside [...]
+// a position predicate has pos > 0; an attribute predicate has attr set, and op is "" to test
+// for presence, or "=" or "!=" to compare with value
+type predicate struct {
+	pos   int
+	attr  string
+	op    string
+	value string
+}
+
+// step is one location step, such as //plant[@id='27']
+type step struct {
+	axis  axis
+	name  string // "*" matches any element
+	preds []predicate
+}
+
+// Path is a compiled XPath expression
+type Path struct {
+	expr  string
+	steps []step
+	text  bool // the expression ends in text()
+}
+
+func (p *Path) String() string {
+	return p.expr
+}
+
+// Compile parses an XPath expression
+func Compile(expr string) (*Path, error) {
+	p := &Path{expr: expr}
+	rest := expr
+	fail := func(format string, args ...interface{}) (*Path, error) {
+		return nil, fmt.Errorf("xpath %q at offset %d: %s", expr, len(expr)-len(rest), fmt.Sprintf(format, args...))
+	}
+
+	if !strings.HasPrefix(rest, "/") {
+		return fail("expressions must start with / or //")
+	}
+
+	for rest != "" {
+		var s step
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			s.axis = axisDescendant
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "/"):
+			s.axis = axisChild
+			rest = rest[1:]
+		default:
+			return fail("expected / or //")
+		}
+
+		if p.text {
+			return fail("text() must be the last step")
+		}
+		if strings.HasPrefix(rest, "text()") {
+			if s.axis != axisChild || len(p.steps) == 0 {
+				return fail("text() must follow an element step with /")
+			}
+			p.text = true
+			rest = rest[len("text()"):]
+			continue
+		}
+
+		n := nameLength(rest)
+		if strings.HasPrefix(rest, "*") {
+			n = 1
+		}
+		if n == 0 {
+			return fail("expected an element name")
+		}
+		s.name = rest[:n]
+		if i := strings.IndexByte(s.name, ':'); i >= 0 {
+			s.name = s.name[i+1:]
+		}
+		rest = rest[n:]
+
+		for strings.HasPrefix(rest, "[") {
+			end := predicateEnd(rest)
+			if end < 0 {
+				return fail("unterminated predicate")
+			}
+			pred, err := parsePredicate(strings.TrimSpace(rest[1:end]))
+			if err != nil {
+				return fail("%v", err)
+			}
+			s.preds = append(s.preds, pred)
+			rest = rest[end+1:]
+		}
+		p.steps = append(p.steps, s)
+	}
+
+	if len(p.steps) == 0 {
+		return fail("no steps")
+	}
+	return p, nil
+}
+
+// MustCompile is like Compile but panics on error, for expressions written in code
+func MustCompile(expr string) *Path {
+	p, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// predicateEnd returns the index of the ] that closes the predicate at the start of s, or -1
+// brackets inside quoted literals, as in [@name='a]b'], don't count
+func predicateEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parsePredicate(s string) (predicate, error) {
+	if !strings.HasPrefix(s, "@") {
+		pos, err := strconv.Atoi(s)
+		if err != nil || pos < 1 {
+			return predicate{}, fmt.Errorf("unsupported predicate [%s]", s)
+		}
+		return predicate{pos: pos}, nil
+	}
+
+	s = s[1:]
+	n := nameLength(s)
+	if n == 0 {
+		return predicate{}, fmt.Errorf("expected an attribute name after @")
+	}
+	pred := predicate{attr: s[:n]}
+	if i := strings.IndexByte(pred.attr, ':'); i >= 0 {
+		pred.attr = pred.attr[i+1:]
+	}
+	s = strings.TrimSpace(s[n:])
+	if s == "" {
+		return pred, nil
+	}
+
+	switch {
+	case strings.HasPrefix(s, "!="):
+		pred.op, s = "!=", s[2:]
+	case strings.HasPrefix(s, "="):
+		pred.op, s = "=", s[1:]
+	default:
+		return predicate{}, fmt.Errorf("expected = or != after @%s", pred.attr)
+	}
+
+	// literals are quoted with either kind of quote, as in XPath
+	s = strings.TrimSpace(s)
+	// a literal can't contain its own kind of quote
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] ||
+		strings.IndexByte(s[1:len(s)-1], s[0]) >= 0 {
+		return predicate{}, fmt.Errorf("expected a quoted value after %s", pred.op)
+	}
+	pred.value = s[1 : len(s)-1]
+	return pred, nil
+}
+
+// nameLength returns the length of the XML name at the start of s
+// this accepts the ASCII subset of XML names, which covers the names used in practice
+func nameLength(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' ||
+			i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.') {
+			continue
+		}
+		return i
+	}
+	return len(s)
+}